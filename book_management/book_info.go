@@ -1,6 +1,8 @@
 package book_management
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -21,8 +23,10 @@ const (
 
 var AllLocalBookType = []string{
 	LocalBookTypeEpub,
+	LocalBookTypeHTML,
 	LocalBookTypeImage,
 	LocalBookTypeLatex,
+	LocalBookTypePdf,
 	LocalBookTypeTypst,
 	LocalBookTypeZip,
 }
@@ -48,10 +52,11 @@ type BookMeta struct {
 
 // Represents infomation about a single book.
 type BookInfo struct {
-	Title  string `json:"title"`   // Book title
-	Author string `json:"author"`  // Book author
-	Artist string `json:"artist"`  // Book artist
-	TocURL string `json:"toc_url"` // URL to book's table of contents page
+	ID     string `json:"id,omitempty"` // stable ID linking book to its records in database, kept unchanged when book gets renamed
+	Title  string `json:"title"`        // Book title
+	Author string `json:"author"`       // Book author
+	Artist string `json:"artist"`       // Book artist
+	TocURL string `json:"toc_url"`      // URL to book's table of contents page
 
 	RootDir     string `json:"root_dir,omitempty"`     // root directory of book
 	RawDir      string `json:"raw_dir,omitempty"`      // directory for cyphered HTML output
@@ -71,6 +76,16 @@ type BookInfo struct {
 	Meta *BookMeta `json:"meta,omitempty"`
 }
 
+// NewBookID generates a random book ID.
+func NewBookID() string {
+	buffer := make([]byte, 16)
+	if _, err := rand.Read(buffer); err != nil {
+		panic(fmt.Sprintf("failed to generate book ID: %s", err))
+	}
+
+	return hex.EncodeToString(buffer)
+}
+
 // Generates book info struct from JSON file.
 func ReadBookInfo(infoPath string) (*BookInfo, error) {
 	data, err := os.ReadFile(infoPath)
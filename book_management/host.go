@@ -0,0 +1,49 @@
+package book_management
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// SupportedHosts lists hostname suffix of sites supported by book downloader.
+var SupportedHosts = []string{
+	"bilimanga.net",
+	"bilicomic.net",
+	"linovelib.com",
+	"senmanga.com",
+	"syosetu.com",
+}
+
+// ClosedHosts maps hostname suffix of sites no longer supported to the reason.
+var ClosedHosts = map[string]string{
+	"bilinovel.com": "mobile support is closed for now",
+}
+
+// CheckTocURL returns error if TOC URL can't be parsed, or no downloader supports
+// its host.
+func CheckTocURL(tocURL string) error {
+	url, err := url.Parse(tocURL)
+	if err != nil {
+		return fmt.Errorf("unable to parse TOC URL: %s", tocURL)
+	}
+
+	hostname := url.Hostname()
+	if hostname == "" {
+		return fmt.Errorf("TOC URL has no host: %s", tocURL)
+	}
+
+	for suffix, reason := range ClosedHosts {
+		if strings.HasSuffix(hostname, suffix) {
+			return fmt.Errorf("unsupported host %s: %s", hostname, reason)
+		}
+	}
+
+	for _, suffix := range SupportedHosts {
+		if strings.HasSuffix(hostname, suffix) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unsupported host %s", hostname)
+}
@@ -82,6 +82,7 @@ func ReadLibraryInfo(infoPath string) (*LibraryInfo, error) {
 		entry.Path = common.ResolveRelativePath(entry.Path, info.RootDir)
 	}
 
+	idSet := map[string]int{}
 	for i := range info.Books {
 		book := &info.Books[i]
 
@@ -89,6 +90,13 @@ func ReadLibraryInfo(infoPath string) (*LibraryInfo, error) {
 			return nil, fmt.Errorf("book %d contains no title", i)
 		}
 
+		if book.ID != "" {
+			if index, ok := idSet[book.ID]; ok {
+				return nil, fmt.Errorf("book %d has the same ID as book %d: %s", i, index, book.ID)
+			}
+			idSet[book.ID] = i
+		}
+
 		book.RootDir = common.GetStrOr(book.RootDir, book.Title)
 		book.RootDir = common.ResolveRelativePath(book.RootDir, info.RootDir)
 
@@ -159,6 +167,42 @@ func (info *LibraryInfo) SetupDefaultValues() {
 	}
 }
 
+// AssignBookIDs generates ID for all books that have none, returns number of
+// books updated.
+func (info *LibraryInfo) AssignBookIDs() int {
+	cnt := 0
+	for i := range info.Books {
+		book := &info.Books[i]
+		if book.ID == "" {
+			book.ID = NewBookID()
+			cnt++
+		}
+	}
+
+	return cnt
+}
+
+// AssignBookIDsInFile generates ID for books that have none in library info
+// file, and writes them back to file. Returns number of books updated.
+func AssignBookIDsInFile(infoPath string) (int, error) {
+	data, err := os.ReadFile(infoPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read info file %s: %s", infoPath, err)
+	}
+
+	info := &LibraryInfo{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return 0, fmt.Errorf("failed to parse info file %s: %s", infoPath, err)
+	}
+
+	cnt := info.AssignBookIDs()
+	if cnt == 0 {
+		return 0, nil
+	}
+
+	return cnt, info.SaveFile(infoPath)
+}
+
 // GetHeaderFileFor returns header file path for given URL.
 func (info *LibraryInfo) GetHeaderFileFor(urlStr string) string {
 	target := ""
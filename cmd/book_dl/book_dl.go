@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
 	"strings"
@@ -32,6 +33,13 @@ func Cmd() *cli.Command {
 		Name:    "download",
 		Aliases: []string{"dl"},
 		Usage:   "download novel or manga",
+		Description: "Validators of TOC page are stored after each complete download, book whose TOC page" +
+			" is not modified since then is skipped. Stored validators are not used when output directory" +
+			" of book is empty. Use --no-conditional to download such book anyway, validators stored for" +
+			" it are replaced once download finishes.",
+		Commands: []*cli.Command{
+			subCmdImport(),
+		},
 		Flags: []cli.Flag{
 			&cli.BoolFlag{
 				Name:  "ignore-taken-down-flag",
@@ -42,6 +50,14 @@ func Cmd() *cli.Command {
 				Usage: "path to library info JSON file",
 				Value: "./library.json",
 			},
+			&cli.BoolFlag{
+				Name:  "no-conditional",
+				Usage: "do not skip books whose TOC page is not modified since last download",
+			},
+			&cli.StringFlag{
+				Name:  "proxy",
+				Usage: "proxy url, e.g. http://127.0.0.1:1080",
+			},
 			&cli.IntFlag{
 				Name:  "retry",
 				Usage: "retry count for page download request",
@@ -61,13 +77,13 @@ func Cmd() *cli.Command {
 				Max:         1,
 			},
 		},
-		Action: func(_ context.Context, cmd *cli.Command) error {
+		Action: func(ctx context.Context, cmd *cli.Command) error {
 			options, targets, err := getOptionsFromCmd(cmd, rawKeyword)
 			if err != nil {
 				return err
 			}
 
-			return cmdMain(options, targets)
+			return cmdMain(ctx, options, targets)
 		},
 	}
 }
@@ -76,11 +92,15 @@ func getOptionsFromCmd(cmd *cli.Command, rawKeyword string) (page_collect.Option
 	options := page_collect.Options{
 		Timeout:  cmd.Duration("timeout"),
 		RetryCnt: cmd.Int("retry"),
+		Proxy:    cmd.String("proxy"),
 
 		IgnoreTakenDownFlag: cmd.Bool("ignore-taken-down-flag"),
+		NoConditional:       cmd.Bool("no-conditional"),
 	}
 
 	libFilePath := cmd.String("library")
+	options.LibraryPath = libFilePath
+
 	targets, err := loadLibraryInfo(&options, libFilePath, rawKeyword)
 	if err != nil {
 		return options, nil, err
@@ -110,6 +130,7 @@ func loadLibraryInfo(options *page_collect.Options, libInfoPath string, rawKeywo
 		}
 
 		targets = append(targets, page_collect.DlTarget{
+			BookID: book.ID,
 			Title:  book.Title,
 			Author: book.Author,
 
@@ -120,6 +141,8 @@ func loadLibraryInfo(options *page_collect.Options, libInfoPath string, rawKeywo
 			HeaderFile: book.HeaderFile,
 			DbPath:     info.DatabasePath,
 
+			BookIndex: i,
+
 			IsTakenDown: book.Meta.IsTakenDown,
 			IsLocal:     book.LocalInfo != nil,
 		})
@@ -128,12 +151,23 @@ func loadLibraryInfo(options *page_collect.Options, libInfoPath string, rawKeywo
 	return targets, nil
 }
 
-func cmdMain(options page_collect.Options, targets []page_collect.DlTarget) error {
+func cmdMain(ctx context.Context, options page_collect.Options, targets []page_collect.DlTarget) error {
 	if len(targets) <= 0 {
 		return fmt.Errorf("no download target found")
 	}
 
+	statusMap := map[int]int{}
+	defer func() {
+		if err := saveBookStatus(options.LibraryPath, statusMap); err != nil {
+			log.Warnf("%s", err)
+		}
+	}()
+
 	for _, target := range targets {
+		if ctx.Err() != nil {
+			break
+		}
+
 		logBookDlBeginBanner(target)
 		if target.IsLocal {
 			log.Infof("skip local book")
@@ -152,25 +186,86 @@ func cmdMain(options page_collect.Options, targets []page_collect.DlTarget) erro
 
 		target.Options = &options
 
-		c, err := makeCollector(target)
+		c, global, err := makeCollector(ctx, target)
 		if err != nil {
 			log.Errorf("failed to create collector for %s:\n\t%s", target.TargetURL, err)
 			continue
 		}
 
 		err = setupCollectorCallback(c, target)
-		if err != nil {
+		if err == nil {
+			c.Visit(target.TargetURL)
+			c.Wait()
+		} else {
 			log.Errorf("unable to setup collector for %s:\n\t%s", target.TargetURL, err)
-			continue
 		}
 
-		c.Visit(target.TargetURL)
-		c.Wait()
+		saveTocValidator(global)
+
+		if err := global.History.Save(global.Db); err != nil {
+			log.Warnf("%s", err)
+		}
+
+		if global.Db != nil {
+			if err := database.Close(global.Db); err != nil {
+				log.Warnf("%s", err)
+			}
+		}
+
+		if status := global.GetBookStatus(); status != book_mgr.BookStatusUnknown {
+			statusMap[target.BookIndex] = status
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("download interrupted: %s", err)
 	}
 
 	return nil
 }
 
+// saveBookStatus writes series status found during downloading back to library
+// info file. `statusMap` maps book index to its new status.
+func saveBookStatus(libInfoPath string, statusMap map[int]int) error {
+	if libInfoPath == "" || len(statusMap) == 0 {
+		return nil
+	}
+
+	data, err := os.ReadFile(libInfoPath)
+	if err != nil {
+		return fmt.Errorf("failed to read info file %s: %s", libInfoPath, err)
+	}
+
+	info := &book_mgr.LibraryInfo{}
+	err = json.Unmarshal(data, info)
+	if err != nil {
+		return fmt.Errorf("failed to parse info file %s: %s", libInfoPath, err)
+	}
+
+	isChanged := false
+	for index, status := range statusMap {
+		if index < 0 || index >= len(info.Books) {
+			continue
+		}
+
+		book := &info.Books[index]
+		if book.Meta == nil {
+			book.Meta = new(book_mgr.BookMeta)
+		}
+
+		if book.Meta.Status != status {
+			book.Meta.Status = status
+			isChanged = true
+		}
+	}
+
+	if !isChanged {
+		return nil
+	}
+
+	return info.SaveFile(libInfoPath)
+}
+
 // logBookDlBeginBanner prints a banner indicating a new download of book starts.
 func logBookDlBeginBanner(target page_collect.DlTarget) {
 	msgs := []string{
@@ -189,25 +284,27 @@ func logBookDlBeginBanner(target page_collect.DlTarget) {
 	common.LogBannerMsg(msgs, 5)
 }
 
-// Returns collector used for novel downloading.
-func makeCollector(target page_collect.DlTarget) (*colly.Collector, error) {
+// Returns collector used for novel downloading. All requests made by returned
+// collector get canceled along with `ctx`.
+func makeCollector(ctx context.Context, target page_collect.DlTarget) (*colly.Collector, *page_collect.CtxGlobal, error) {
 	// ensure output directory
 	if stat, err := os.Stat(target.OutputDir); errors.Is(err, os.ErrNotExist) {
 		if err = os.MkdirAll(target.OutputDir, 0o777); err != nil {
-			return nil, fmt.Errorf("failed to create output directory: %s", err)
+			return nil, nil, fmt.Errorf("failed to create output directory: %s", err)
 		}
 	} else if err != nil {
-		return nil, fmt.Errorf("failed to access output directory %s: %s", target.OutputDir, err)
+		return nil, nil, fmt.Errorf("failed to access output directory %s: %s", target.OutputDir, err)
 	} else if !stat.IsDir() {
-		return nil, fmt.Errorf("An file with name %s already exists", target.OutputDir)
+		return nil, nil, fmt.Errorf("An file with name %s already exists", target.OutputDir)
 	}
 
 	// load headers
-	headers := map[string]string{}
+	var headerTemplate *network.HeaderTemplate
 	if target.HeaderFile != "" {
-		err := readHeaderFile(target.HeaderFile, headers)
+		var err error
+		headerTemplate, err = network.ReadHeaderFile(target.HeaderFile)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
@@ -216,99 +313,92 @@ func makeCollector(target page_collect.DlTarget) (*colly.Collector, error) {
 		var err error
 		db, err = database.Open(target.DbPath)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
-	c := colly.NewCollector(
-		colly.Headers(headers),
-		colly.Async(true),
-	)
-
-	global := page_collect.NewCtxGlobal()
+	global := page_collect.NewCtxGlobal(ctx)
 	global.Target = &target
-	global.Collector = c
 	global.Db = db
+	global.History = database.NewHistoryRun(database.HistoryCommandDownload, target.BookID, target.Title, "")
 
-	c.OnRequest(func(r *colly.Request) {
-		r.Ctx.Put("global", global)
-	})
-	c.OnResponse(func(r *colly.Response) {
-		if data, err := network.DecompressResponseBody(r); err == nil {
-			r.Body = data
-		} else {
-			log.Error(err)
-		}
+	clientOptions := network.ClientOptions{
+		Ctx:            ctx,
+		HeaderTemplate: headerTemplate,
+		HTTPProxy:      target.Options.Proxy,
+		HTTPSProxy:     target.Options.Proxy,
+		RetryCnt:       int(target.Options.RetryCnt),
+		Async:          true,
 
-		if onResponse, ok := r.Ctx.GetAny("onResponse").(colly.ResponseCallback); ok {
-			onResponse(r)
-		}
-	})
-	c.OnError(func(r *colly.Response, err error) {
-		ctx := r.Ctx
+		TranscodeHTML: true,
 
-		if onError, ok := ctx.GetAny("onError").(colly.ErrorCallback); ok {
-			onError(r, err)
-		} else {
-			log.Errorf("error requesting %s: %s", r.Request.URL, err)
-		}
-	})
+		ErrorLogger: func(msg string) {
+			global.MarkFailed()
+			log.Error(msg)
+		},
+	}
 
-	return c, nil
-}
+	if target.Options.ImportDir != "" {
+		var fallback http.RoundTripper
+		if !target.Options.Offline {
+			fallback = network.NewTransport(clientOptions)
+		}
+		clientOptions.Transport = network.NewLocalFileTransport(target.Options.ImportDir, fallback)
+	}
 
-// setupCollectorCallback sets collector HTML callback for collecting novel pages.
-func setupCollectorCallback(collector *colly.Collector, target page_collect.DlTarget) error {
-	url, err := url.Parse(target.TargetURL)
+	c, err := network.NewCollector(clientOptions)
 	if err != nil {
-		return fmt.Errorf("unable to parse target URL: %s", target.TargetURL)
+		if db != nil {
+			database.Close(db)
+		}
+		return nil, nil, err
 	}
 
-	hostname := url.Hostname()
-	hostMap := map[string]func(*colly.Collector, page_collect.DlTarget) error{
-		"bilinovel.com": func(_ *colly.Collector, _ page_collect.DlTarget) error {
-			return fmt.Errorf("mobile support is closed for now")
-		},
-		"bilimanga.net": bilimanga.SetupCollector,
-		"bilicomic.net": bilicomic.SetupCollector,
-		"linovelib.com": linovelib.SetupCollector,
-		"senmanga.com":  senmanga.SetupCollector,
-		"syosetu.com":   syosetu.SetupCollector,
+	global.Collector = c
+
+	c.OnRequest(func(r *colly.Request) {
+		r.Ctx.Put("global", global)
+	})
+
+	if db != nil && target.Options.ImportDir == "" && !target.Options.NoConditional {
+		setupConditionalRequest(c, global)
 	}
 
-	for suffix, setupFunc := range hostMap {
-		if strings.HasSuffix(hostname, suffix) {
-			err = setupFunc(collector, target)
-			break
-		}
+	if db != nil {
+		setupBookRecord(global)
 	}
 
-	return err
+	return c, global, nil
 }
 
-type headerValue struct {
-	Name  string `json:"name"`
-	Value string `json:"value"`
+// hostSetupFuncs maps hostname suffix of supported sites to function setting
+// up collector callback for that site. Keys must be the same as
+// book_mgr.SupportedHosts, which is checked by test.
+var hostSetupFuncs = map[string]func(*colly.Collector, page_collect.DlTarget) error{
+	"bilimanga.net": bilimanga.SetupCollector,
+	"bilicomic.net": bilicomic.SetupCollector,
+	"linovelib.com": linovelib.SetupCollector,
+	"senmanga.com":  senmanga.SetupCollector,
+	"syosetu.com":   syosetu.SetupCollector,
 }
 
-// Reads header value from file and stores then into the map passed as argument.
-// Header file should a JSON containing array of header objects. Each header
-// objects should be object with tow string field `name` and `value`.
-func readHeaderFile(path string, result map[string]string) error {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return fmt.Errorf("failed to read %s: %s", path, err)
+// setupCollectorCallback sets collector HTML callback for collecting novel pages.
+func setupCollectorCallback(collector *colly.Collector, target page_collect.DlTarget) error {
+	if err := book_mgr.CheckTocURL(target.TargetURL); err != nil {
+		return err
 	}
 
-	list := []headerValue{}
-	err = json.Unmarshal(data, &list)
+	url, err := url.Parse(target.TargetURL)
 	if err != nil {
-		return fmt.Errorf("failed to parse %s: %s", path, err)
+		return fmt.Errorf("unable to parse target URL: %s", target.TargetURL)
 	}
 
-	for _, entry := range list {
-		result[entry.Name] = entry.Value
+	hostname := url.Hostname()
+	for suffix, setupFunc := range hostSetupFuncs {
+		if strings.HasSuffix(hostname, suffix) {
+			return setupFunc(collector, target)
+		}
 	}
 
-	return nil
+	return fmt.Errorf("no downloader found for host %s", hostname)
 }
@@ -0,0 +1,22 @@
+package book_dl
+
+import (
+	"slices"
+	"testing"
+
+	book_mgr "github.com/SirZenith/delite/book_management"
+)
+
+func TestHostSetupFuncsMatchSupportedHosts(t *testing.T) {
+	for _, host := range book_mgr.SupportedHosts {
+		if _, ok := hostSetupFuncs[host]; !ok {
+			t.Errorf("supported host %s has no setup function", host)
+		}
+	}
+
+	for host := range hostSetupFuncs {
+		if !slices.Contains(book_mgr.SupportedHosts, host) {
+			t.Errorf("host %s has setup function but is not listed in supported hosts", host)
+		}
+	}
+}
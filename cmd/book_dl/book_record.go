@@ -0,0 +1,38 @@
+package book_dl
+
+import (
+	"github.com/SirZenith/delite/database"
+	"github.com/SirZenith/delite/database/data_model"
+	"github.com/SirZenith/delite/page_collect"
+	"github.com/charmbracelet/log"
+)
+
+// setupBookRecord saves book record of download target, and enables recording
+// volumes and chapters in book tables.
+func setupBookRecord(global *page_collect.CtxGlobal) {
+	db := global.Db
+	target := global.Target
+
+	if target.BookID == "" {
+		log.Warnf("book has no ID, run `database sync-books` to assign one and enable chapter records")
+		return
+	}
+
+	if !database.HasBookTables(db) {
+		log.Warnf("no book tables in database, run `database migrate` to enable chapter records")
+		return
+	}
+
+	err := database.SaveBook(db, &data_model.Book{
+		ID:     target.BookID,
+		Title:  target.Title,
+		Author: target.Author,
+		TocURL: target.TargetURL,
+	})
+	if err != nil {
+		log.Warnf("%s", err)
+		return
+	}
+
+	global.BookRecord = true
+}
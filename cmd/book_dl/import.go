@@ -0,0 +1,88 @@
+package book_dl
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v3"
+)
+
+func subCmdImport() *cli.Command {
+	var rawKeyword string
+	var importDir string
+
+	return &cli.Command{
+		Name:  "import",
+		Usage: "import HTML pages saved by browser through the same process as downloading",
+		Description: "Pages are looked up by their original URL under import directory. HTML files" +
+			" whose <link rel=\"canonical\"> points to requested URL are used first, otherwise files" +
+			" are looked up as <dir>/<host>/<path> or <dir>/<path>. URL path ending with `/` maps to" +
+			" `index.html`, and path without extension also matches file with `.html` extension." +
+			" Query string is kept in file name, as `index.html?p=2` or `index_p=2.html`.",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "library",
+				Usage: "path to library info JSON file",
+				Value: "./library.json",
+			},
+			&cli.BoolFlag{
+				Name:  "offline",
+				Usage: "do not fetch pages missing from import directory over network",
+			},
+			&cli.StringFlag{
+				Name:  "proxy",
+				Usage: "proxy url, e.g. http://127.0.0.1:1080",
+			},
+			&cli.IntFlag{
+				Name:  "retry",
+				Usage: "retry count for page download request",
+				Value: 3,
+			},
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "request timeout for content page in milisecond",
+				Value: -1,
+			},
+		},
+		Arguments: []cli.Argument{
+			&cli.StringArg{
+				Name:        "book-keyword",
+				UsageText:   "<book>",
+				Destination: &rawKeyword,
+				Min:         1,
+				Max:         1,
+			},
+			&cli.StringArg{
+				Name:        "import-dir",
+				UsageText:   " <dir>",
+				Destination: &importDir,
+				Min:         1,
+				Max:         1,
+			},
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			if stat, err := os.Stat(importDir); err != nil {
+				return fmt.Errorf("failed to access import directory %s: %s", importDir, err)
+			} else if !stat.IsDir() {
+				return fmt.Errorf("import target %s is not a directory", importDir)
+			}
+
+			options, targets, err := getOptionsFromCmd(cmd, rawKeyword)
+			if err != nil {
+				return err
+			}
+
+			if len(targets) > 1 {
+				return fmt.Errorf("keyword %q matches %d books, import requires exactly one", rawKeyword, len(targets))
+			}
+
+			options.ImportDir = importDir
+			options.Offline = cmd.Bool("offline")
+			// saved pages are often the only source left for books taken down
+			options.IgnoreTakenDownFlag = true
+
+			return cmdMain(ctx, options, targets)
+		},
+	}
+}
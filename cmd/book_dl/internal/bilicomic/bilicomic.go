@@ -2,6 +2,7 @@ package bilicomic
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
@@ -13,6 +14,7 @@ import (
 	"github.com/PuerkitoBio/goquery"
 	"github.com/SirZenith/delite/common"
 	"github.com/SirZenith/delite/database/data_model"
+	"github.com/SirZenith/delite/network"
 	collect "github.com/SirZenith/delite/page_collect"
 	"github.com/charmbracelet/log"
 	"github.com/gocolly/colly/v2"
@@ -91,14 +93,14 @@ func getVolumeInfo(volIndex int, e *colly.HTMLElement, target *collect.DlTarget)
 		outputTitle = fmt.Sprintf("%03d - %s", volIndex, outputTitle)
 	}
 
-	return collect.VolumeInfo{
+	volumeInfo := collect.VolumeInfo{
 		Book:     target.Title,
 		VolIndex: volIndex,
 		Title:    title,
-
-		OutputDir:    filepath.Join(target.OutputDir, outputTitle),
-		ImgOutputDir: filepath.Join(target.ImgOutputDir, outputTitle),
 	}
+	volumeInfo.SetOutputDir(target, outputTitle)
+
+	return volumeInfo
 }
 
 // Handles one chapter link found in desktop chapter entry.
@@ -139,11 +141,12 @@ func onPageContent(e *colly.HTMLElement) {
 		page.Title = getChapterTitle(e)
 	}
 
-	state.ResultChan <- page
+	state.SendPage(global.Ctx, page)
 
 	if tasks != nil {
-		taskCtx := context.WithValue(context.Background(), "db", global.Db)
+		taskCtx := context.WithValue(global.Ctx, "db", global.Db)
 		taskCtx = context.WithValue(taskCtx, "book", state.Info.Book)
+		taskCtx = context.WithValue(taskCtx, "part", state.Info.PartTitle)
 		taskCtx = context.WithValue(taskCtx, "volume", state.Info.Title)
 
 		for _, task := range tasks {
@@ -242,12 +245,13 @@ func downloadImage(collator *colly.Collector, task collect.ImageTask, resultChan
 
 	dlContext := colly.NewContext()
 	dlContext.Put("onResponse", colly.ResponseCallback(func(resp *colly.Response) {
-		saveImageEntryInfo(task)
-
-		err := common.SaveImageAs(resp.Body, outputName, common.ImageFormatAvif)
+		err := network.SaveImageResponse(task.Ctx, resp, outputName, common.ImageFormatAvif)
 		if err == nil {
+			saveImageEntryInfo(task)
 			log.Infof("file downloaded: %s", outputName)
 			resultChan <- true
+		} else if errors.Is(err, network.ErrRetrying) {
+			// result will be reported by retried request
 		} else {
 			log.Warnf("failed to save file %s: %s\n", outputName, err)
 			resultChan <- false
@@ -284,11 +288,13 @@ func saveImageEntryInfo(task collect.ImageTask) {
 	}
 
 	book := task.Ctx.Value("book").(string)
+	part := task.Ctx.Value("part").(string)
 	volume := task.Ctx.Value("volume").(string)
 
 	entry := data_model.FileEntry{
 		URL:      task.URL,
 		Book:     book,
+		Part:     part,
 		Volume:   volume,
 		FileName: filepath.Base(task.OutputName),
 	}
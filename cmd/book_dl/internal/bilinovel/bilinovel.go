@@ -71,14 +71,14 @@ func getVolumeInfo(volIndex int, e *colly.HTMLElement, target *collect.DlTarget)
 		outputTitle = fmt.Sprintf("%03d - %s", volIndex, outputTitle)
 	}
 
-	return collect.VolumeInfo{
+	volumeInfo := collect.VolumeInfo{
 		Book:     target.Title,
 		VolIndex: volIndex,
 		Title:    title,
-
-		OutputDir:    filepath.Join(target.OutputDir, outputTitle),
-		ImgOutputDir: filepath.Join(target.ImgOutputDir, outputTitle),
 	}
+	volumeInfo.SetOutputDir(target, outputTitle)
+
+	return volumeInfo
 }
 
 // Handles one chapter link found in mobile chapter entry.
@@ -102,18 +102,19 @@ func onChapterEntry(chapIndex int, e *colly.HTMLElement, volumeInfo collect.Volu
 
 func onPageContent(e *colly.HTMLElement) {
 	ctx := e.Request.Ctx
+	global := ctx.GetAny("global").(*collect.CtxGlobal)
 	state := ctx.GetAny("downloadState").(*collect.ChapterDownloadState)
 
 	content := getContentText(e)
-	state.ResultChan <- collect.PageContent{
+	state.SendPage(global.Ctx, collect.PageContent{
 		PageNumber: state.CurPageNumber,
 		Content:    content,
-	}
+	})
 
 	downloadChapterImages(e)
 
 	if checkChapterIsFinished(e) {
-		close(state.ResultChan)
+		state.CloseResult()
 	} else {
 		requestNextPage(e)
 	}
@@ -176,18 +177,21 @@ func downloadChapterImages(e *colly.HTMLElement) {
 			return
 		}
 
-		if global.Db != nil {
+		dlContext := colly.NewContext()
+		dlContext.Put("onResponse", network.MakeSaveImageBodyCallback(global.Ctx, outputName, common.ImageFormatPng, func() {
+			if global.Db == nil {
+				return
+			}
+
 			entry := data_model.FileEntry{
 				URL:      url,
 				Book:     state.Info.Book,
+				Part:     state.Info.PartTitle,
 				Volume:   state.Info.Title,
 				FileName: basename,
 			}
 			global.Db.Save(&entry)
-		}
-
-		dlContext := colly.NewContext()
-		dlContext.Put("onResponse", network.MakeSaveImageBodyCallback(outputName, common.ImageFormatPng))
+		}))
 
 		global.Collector.Request("GET", url, nil, dlContext, map[string][]string{
 			"Referer": {"https://www.bilinovel.com"},
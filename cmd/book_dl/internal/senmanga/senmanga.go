@@ -2,6 +2,7 @@ package senmanga
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
@@ -13,6 +14,7 @@ import (
 	"github.com/PuerkitoBio/goquery"
 	"github.com/SirZenith/delite/common"
 	"github.com/SirZenith/delite/database/data_model"
+	"github.com/SirZenith/delite/network"
 	collect "github.com/SirZenith/delite/page_collect"
 	"github.com/charmbracelet/log"
 	"github.com/gocolly/colly/v2"
@@ -84,13 +86,13 @@ func onVolumeEntry(volIndex int, e *colly.HTMLElement) {
 func getVolumeInfo(volIndex int, _ *colly.HTMLElement, target *collect.DlTarget) collect.VolumeInfo {
 	outputTitle := fmt.Sprintf("Vol.%03d", volIndex)
 
-	return collect.VolumeInfo{
+	volumeInfo := collect.VolumeInfo{
 		Book:     target.Title,
 		VolIndex: volIndex,
-
-		OutputDir:    filepath.Join(target.OutputDir, outputTitle),
-		ImgOutputDir: filepath.Join(target.ImgOutputDir, outputTitle),
 	}
+	volumeInfo.SetOutputDir(target, outputTitle)
+
+	return volumeInfo
 }
 
 // Handles one chapter link found in desktop chapter entry.
@@ -123,18 +125,20 @@ func onPageContent(e *colly.HTMLElement) {
 	content, tasks := getContentText(e)
 	nextPageURL, nextChapterURL := getNextURL(e)
 
-	state.ResultChan <- collect.PageContent{
+	state.SendPage(global.Ctx, collect.PageContent{
 		PageNumber:     state.CurPageNumber,
 		Content:        content,
 		NextChapterURL: nextChapterURL,
-	}
+	})
 
 	if tasks != nil {
-		taskCtx := context.WithValue(context.Background(), "db", global.Db)
+		taskCtx := context.WithValue(global.Ctx, "db", global.Db)
 		taskCtx = context.WithValue(taskCtx, "book", state.Info.Book)
+		taskCtx = context.WithValue(taskCtx, "part", state.Info.PartTitle)
 		taskCtx = context.WithValue(taskCtx, "volume", state.Info.Title)
 
 		for _, task := range tasks {
+			task.Ctx = taskCtx
 			dlChan <- task
 		}
 	}
@@ -225,12 +229,13 @@ func downloadImage(collator *colly.Collector, task collect.ImageTask, resultChan
 
 	dlContext := colly.NewContext()
 	dlContext.Put("onResponse", colly.ResponseCallback(func(resp *colly.Response) {
-		saveImageEntryInfo(task)
-
-		err := common.SaveImageAs(resp.Body, outputName, common.ImageFormatAvif)
+		err := network.SaveImageResponse(task.Ctx, resp, outputName, common.ImageFormatAvif)
 		if err == nil {
+			saveImageEntryInfo(task)
 			log.Infof("file downloaded: %s", outputName)
 			resultChan <- true
+		} else if errors.Is(err, network.ErrRetrying) {
+			// result will be reported by retried request
 		} else {
 			log.Warnf("failed to save file %s: %s\n", outputName, err)
 			resultChan <- false
@@ -263,11 +268,13 @@ func saveImageEntryInfo(task collect.ImageTask) {
 	}
 
 	book := task.Ctx.Value("book").(string)
+	part := task.Ctx.Value("part").(string)
 	volume := task.Ctx.Value("volume").(string)
 
 	entry := data_model.FileEntry{
 		URL:      task.URL,
 		Book:     book,
+		Part:     part,
 		Volume:   volume,
 		FileName: filepath.Base(task.OutputName),
 	}
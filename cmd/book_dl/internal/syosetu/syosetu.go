@@ -6,10 +6,12 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
+	book_mgr "github.com/SirZenith/delite/book_management"
 	"github.com/SirZenith/delite/common"
 	"github.com/SirZenith/delite/database/data_model"
 	"github.com/SirZenith/delite/network"
@@ -21,6 +23,22 @@ import (
 const defaultDelay = 50
 const defaultTimeOut = 10_000 * time.Millisecond
 
+// Class names of containers wrapping different parts of chapter content.
+const (
+	classPreface   = "chapter-preface"
+	classBody      = "chapter-body"
+	classAfterword = "chapter-afterword"
+)
+
+// Fragment appended to URL of short story. Short story has its content on TOC
+// page, this fragment makes content request distinct from TOC request, so that
+// it won't be blocked as a revisit.
+const shortStoryFragment = "short-story"
+
+// Matches chapter group heading starting with part number, e.g. `第一部　王都編`.
+// First group is the part title, second group is the rest of heading.
+var patternPartHeading = regexp.MustCompile(`^(第[0-9０-９一二三四五六七八九十百千〇零]+[部篇])[\s　:：・\-－―]*(.*)$`)
+
 // Setups collector callbacks for collecting novel content from desktop novel page.
 func SetupCollector(c *colly.Collector, target collect.DlTarget) error {
 	if len(target.Options.LimitRules) > 0 {
@@ -36,6 +54,7 @@ func SetupCollector(c *colly.Collector, target collect.DlTarget) error {
 	c.SetRequestTimeout(timeout)
 
 	c.OnHTML("article.p-novel", onNovelPage)
+	c.OnHTML("div.p-infotop-type", onInfoTopType)
 
 	return nil
 }
@@ -43,21 +62,74 @@ func SetupCollector(c *colly.Collector, target collect.DlTarget) error {
 // A struct used to pass volume information between different TOC page content
 // handling callbacks.
 type volumeRecord struct {
+	partIndex     int
+	partTitle     string
 	volIndex      int
 	title         string
 	chapterOffset int // how many chapters has been handled before current callback
 }
 
 func onNovelPage(e *colly.HTMLElement) {
+	ctx := e.Request.Ctx
+	_, isChapterPage := ctx.GetAny("downloadState").(*collect.ChapterDownloadState)
+	_, isFollowingTOCPage := ctx.GetAny("volumeInfo").(volumeRecord)
+	if !isChapterPage && !isFollowingTOCPage {
+		requestInfoTop(e.Request)
+	}
+
 	episodeList := e.DOM.Find("div.p-eplist").First()
 	if len(episodeList.Nodes) > 0 {
 		onEpisodeList(e.Request, episodeList)
 	}
 
 	novelContents := e.DOM.Find("div.p-novel__text")
-	if len(novelContents.Nodes) > 0 {
+	if len(novelContents.Nodes) <= 0 {
+		return
+	}
+
+	if isChapterPage {
 		onPageContent(e.Request, novelContents)
+	} else if len(episodeList.Nodes) <= 0 {
+		onShortStory(e)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Book info
+
+// Makes a request to info page of the novel, series status can be found there.
+func requestInfoTop(req *colly.Request) {
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	ncode := segments[0]
+	if ncode == "" {
+		return
+	}
+
+	url := fmt.Sprintf("%s://%s/novelview/infotop/ncode/%s/", req.URL.Scheme, req.URL.Host, ncode)
+
+	global := req.Ctx.GetAny("global").(*collect.CtxGlobal)
+	global.Collector.Request("GET", url, nil, colly.NewContext(), req.Headers.Clone())
+}
+
+// Handles novel type label on info page, records series status of the book.
+func onInfoTopType(e *colly.HTMLElement) {
+	global := e.Request.Ctx.GetAny("global").(*collect.CtxGlobal)
+
+	label := strings.TrimSpace(e.DOM.Find("span.p-infotop-type__type").First().Text())
+
+	var status int
+	switch {
+	case strings.Contains(label, "連載中"):
+		status = book_mgr.BookStatusOngoing
+	case strings.Contains(label, "完結"), strings.Contains(label, "短編"):
+		status = book_mgr.BookStatusCompleted
+	default:
+		log.Debugf("unknown novel type label: %q", label)
+		return
 	}
+
+	log.Infof("series status: %s", label)
+	global.SetBookStatus(status)
 }
 
 // ----------------------------------------------------------------------------
@@ -87,10 +159,7 @@ func onEpisodeList(req *colly.Request, episodeList *goquery.Selection) {
 			title := child.Text()
 			title = strings.TrimSpace(title)
 
-			record = volumeRecord{
-				volIndex: record.volIndex + 1,
-				title:    title,
-			}
+			record = nextVolumeRecord(record, title)
 			chapterList = chapterList[:0]
 		case "p-eplist__sublist":
 			// new chapter
@@ -113,11 +182,41 @@ func onEpisodeList(req *colly.Request, episodeList *goquery.Selection) {
 	if letftCnt > 0 {
 		onVolumeEntry(req, record, chapterList, global)
 	}
-	record.chapterOffset = letftCnt
+	record.chapterOffset += letftCnt
 
 	tryGoToNextEpisodeListPage(req, episodeList, record)
 }
 
+// nextVolumeRecord makes record for volume started by given chapter group
+// heading. Heading with part number starts a new part when its part title
+// differs from previous one, headings without part number stay in current part.
+func nextVolumeRecord(prev volumeRecord, heading string) volumeRecord {
+	record := volumeRecord{
+		partIndex: prev.partIndex,
+		partTitle: prev.partTitle,
+		volIndex:  prev.volIndex + 1,
+		title:     heading,
+	}
+
+	match := patternPartHeading.FindStringSubmatch(heading)
+	if match == nil {
+		return record
+	}
+
+	partTitle, volumeTitle := match[1], strings.TrimSpace(match[2])
+	if volumeTitle != "" {
+		record.title = volumeTitle
+	}
+
+	if partTitle != prev.partTitle {
+		record.partIndex++
+		record.partTitle = partTitle
+		log.Infof("part %d: %s", record.partIndex, partTitle)
+	}
+
+	return record
+}
+
 func tryGoToNextEpisodeListPage(req *colly.Request, episodeList *goquery.Selection, record volumeRecord) {
 	pager := episodeList.Siblings().Filter("div.c-pager").First()
 	aTag := pager.Find("a.c-pager__item.c-pager__item--next").First()
@@ -164,14 +263,45 @@ func makeVolumeInfo(record volumeRecord, target *collect.DlTarget) collect.Volum
 		outputTitle = fmt.Sprintf("%03d - %s", record.volIndex, outputTitle)
 	}
 
-	return collect.VolumeInfo{
-		Book:     target.Title,
-		VolIndex: record.volIndex,
-		Title:    record.title,
+	volumeInfo := collect.VolumeInfo{
+		Book:      target.Title,
+		PartIndex: record.partIndex,
+		PartTitle: record.partTitle,
+		VolIndex:  record.volIndex,
+		Title:     record.title,
+	}
+	volumeInfo.SetOutputDir(target, outputTitle)
+
+	return volumeInfo
+}
+
+// Handles short story, whose TOC page contains novel content instead of
+// episode list. Content is downloaded as the only chapter of the only volume.
+func onShortStory(e *colly.HTMLElement) {
+	global := e.Request.Ctx.GetAny("global").(*collect.CtxGlobal)
+
+	title := strings.TrimSpace(e.DOM.Find("h1.p-novel__title").First().Text())
+	if title == "" {
+		title = global.Target.Title
+	}
+
+	url := *e.Request.URL
+	url.Fragment = shortStoryFragment
 
-		OutputDir:    filepath.Join(target.OutputDir, outputTitle),
-		ImgOutputDir: filepath.Join(target.ImgOutputDir, outputTitle),
+	record := volumeRecord{
+		volIndex: 1,
+		title:    global.Target.Title,
 	}
+
+	log.Infof("short story: %s", title)
+
+	onVolumeEntry(e.Request, record, []collect.ChapterInfo{
+		{
+			ChapIndex: 1,
+			Title:     title,
+			URL:       url.String(),
+		},
+	}, global)
 }
 
 // ----------------------------------------------------------------------------
@@ -180,6 +310,7 @@ func makeVolumeInfo(record volumeRecord, target *collect.DlTarget) collect.Volum
 // Handles novel chapter content page encountered during collecting.
 func onPageContent(req *colly.Request, novelContents *goquery.Selection) {
 	ctx := req.Ctx
+	global := ctx.GetAny("global").(*collect.CtxGlobal)
 	state := ctx.GetAny("downloadState").(*collect.ChapterDownloadState)
 
 	content := getContentText(novelContents)
@@ -190,9 +321,9 @@ func onPageContent(req *colly.Request, novelContents *goquery.Selection) {
 
 	downloadChapterImages(req, novelContents)
 
-	state.ResultChan <- page
+	state.SendPage(global.Ctx, page)
 
-	close(state.ResultChan)
+	state.CloseResult()
 }
 
 // Extracts chapter title from page element.
@@ -203,17 +334,28 @@ func getChapterTitle(e *colly.HTMLElement) string {
 }
 
 // Extracts chapter content from page element.
-// This function will do text decypher by font descramble map before returning
-// page content.
+// Preface, main text and afterword are wrapped in containers with different
+// class names, so that they can be told apart after download.
 func getContentText(containers *goquery.Selection) string {
 	buffer := []string{}
 
 	containers.Each(func(_ int, container *goquery.Selection) {
+		class := classBody
+		if container.HasClass("p-novel__text--preface") {
+			class = classPreface
+		} else if container.HasClass("p-novel__text--afterword") {
+			class = classAfterword
+		}
+
+		buffer = append(buffer, "<div class=\""+class+"\">")
+
 		container.Children().Each(func(_ int, child *goquery.Selection) {
 			if html, err := goquery.OuterHtml(child); err == nil {
 				buffer = append(buffer, html)
 			}
 		})
+
+		buffer = append(buffer, "</div>")
 	})
 
 	return strings.Join(buffer, "\n")
@@ -250,18 +392,21 @@ func downloadChapterImages(req *colly.Request, containers *goquery.Selection) {
 			return
 		}
 
-		if global.Db != nil {
+		dlContext := colly.NewContext()
+		dlContext.Put("onResponse", network.MakeSaveImageBodyCallback(global.Ctx, outputName, common.ImageFormatPng, func() {
+			if global.Db == nil {
+				return
+			}
+
 			entry := data_model.FileEntry{
 				URL:      url,
 				Book:     state.Info.Book,
+				Part:     state.Info.PartTitle,
 				Volume:   state.Info.Title,
 				FileName: basename,
 			}
 			global.Db.Save(&entry)
-		}
-
-		dlContext := colly.NewContext()
-		dlContext.Put("onResponse", network.MakeSaveImageBodyCallback(outputName, common.ImageFormatPng))
+		}))
 
 		global.Collector.Request("GET", url, nil, dlContext, map[string][]string{
 			"Referer": {"https://ncode.syosetu.com/"},
@@ -0,0 +1,93 @@
+package book_dl
+
+import (
+	"os"
+
+	"github.com/SirZenith/delite/database/data_model"
+	"github.com/SirZenith/delite/page_collect"
+	"github.com/charmbracelet/log"
+	"github.com/gocolly/colly/v2"
+)
+
+// setupConditionalRequest makes request to book's TOC page conditional, with
+// validators stored in book database. When server replies 304, no TOC parsing
+// will happen for that book.
+// Validators of new TOC page are kept in global context, and saved by
+// saveTocValidator after download finishes.
+// Stored validators are not sent if output directory of book is empty, so that
+// a deleted book gets downloaded again.
+func setupConditionalRequest(c *colly.Collector, global *page_collect.CtxGlobal) {
+	db := global.Db
+	tocURL := global.Target.TargetURL
+	isOutputEmpty := isDirEmpty(global.Target.OutputDir)
+
+	if !db.Migrator().HasTable(&data_model.HTTPValidator{}) {
+		log.Warnf("no HTTP validator table in database, run `database migrate` to enable conditional request")
+		return
+	}
+
+	c.OnRequest(func(r *colly.Request) {
+		if r.URL.String() != tocURL {
+			return
+		}
+
+		if isOutputEmpty {
+			log.Debugf("output directory is empty, TOC validators not used")
+			return
+		}
+
+		entry := data_model.HTTPValidator{}
+		db.Limit(1).Find(&entry, "url = ?", tocURL)
+
+		if entry.ETag != "" {
+			r.Headers.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			r.Headers.Set("If-Modified-Since", entry.LastModified)
+		}
+
+		r.Ctx.Put("onNotModified", colly.ResponseCallback(func(_ *colly.Response) {
+			log.Infof("TOC page not modified since last download, skip book")
+		}))
+	})
+
+	c.OnResponse(func(r *colly.Response) {
+		if r.Request.URL.String() != tocURL {
+			return
+		}
+
+		global.SetTocValidator(&data_model.HTTPValidator{
+			URL:          tocURL,
+			ETag:         r.Headers.Get("ETag"),
+			LastModified: r.Headers.Get("Last-Modified"),
+		})
+	})
+}
+
+// saveTocValidator writes validators of TOC page received during downloading to
+// database. Nothing is saved if download was interrupted or any chapter failed,
+// so that next run won't skip an incomplete book.
+func saveTocValidator(global *page_collect.CtxGlobal) {
+	validator := global.GetTocValidator()
+	if validator == nil || global.Db == nil {
+		return
+	}
+
+	if global.Ctx.Err() != nil || global.HasFailure() {
+		log.Debugf("book download incomplete, TOC validators not saved")
+		return
+	}
+
+	if validator.IsEmpty() {
+		global.Db.Delete(&data_model.HTTPValidator{}, "url = ?", validator.URL)
+		return
+	}
+
+	validator.Upsert(global.Db)
+}
+
+// isDirEmpty reports if given directory has no entry in it, or doesn't exist.
+func isDirEmpty(dir string) bool {
+	entryList, err := os.ReadDir(dir)
+	return err != nil || len(entryList) == 0
+}
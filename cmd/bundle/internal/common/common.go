@@ -1,6 +1,12 @@
 package common
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/SirZenith/delite/page_collect"
+)
 
 // volume name used for books with only one volume, and should be omitted in output
 // book's name.
@@ -15,3 +21,15 @@ func CombineOutputName(book, volume string) string {
 		return fmt.Sprintf("%s %s", book, volume)
 	}
 }
+
+// IsPartDir checks if given directory is a part directory, which holds volume
+// directories instead of chapter files. Part directories are recognized by the
+// `Part.NNN` naming used by downloader.
+func IsPartDir(dir string) bool {
+	if !page_collect.IsPartDirName(filepath.Base(dir)) {
+		return false
+	}
+
+	info, err := os.Stat(dir)
+	return err == nil && info.IsDir()
+}
@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 
 	book_mgr "github.com/SirZenith/delite/book_management"
@@ -69,6 +70,7 @@ func Cmd() *cli.Command {
 }
 
 type bookInfo struct {
+	bookID    string
 	textDir   string
 	imageDir  string
 	outputDir string
@@ -89,9 +91,14 @@ type epubInfo struct {
 	tocURL *url.URL
 	db     *gorm.DB
 
+	bookID      string // used for looking up volume and chapter titles in database
+	bookTextDir string // text directory of the whole book
+
 	outputName string
 	textDir    string
 	imgDir     string
+
+	isPart bool // when true, text directory holds volume directories of a part
 }
 
 func getOptionsFromCmd(cmd *cli.Command, rawKeyword string, volumeIndex int) (options, []bookInfo, error) {
@@ -125,6 +132,7 @@ func loadLibraryTargets(libInfoPath string, rawKeyword string, volumeIndex int)
 		url, _ := url.Parse(book.TocURL)
 
 		targets = append(targets, bookInfo{
+			bookID:    book.ID,
 			textDir:   book.TextDir,
 			imageDir:  book.ImgDir,
 			outputDir: book.EpubDir,
@@ -172,6 +180,12 @@ func cmdMain(options options, targets []bookInfo) error {
 			}
 		}
 
+		volume := ""
+		if target.targetVolume > 0 {
+			volume = strconv.Itoa(target.targetVolume)
+		}
+		history := database.NewHistoryRun(database.HistoryCommandBundleEpub, target.bookID, target.bookTitle, volume)
+
 		for index, child := range entryList {
 			if target.targetVolume > 0 && index+1 != target.targetVolume {
 				continue
@@ -197,18 +211,31 @@ func cmdMain(options options, targets []bookInfo) error {
 				tocURL: target.tocURL,
 				db:     db,
 
+				bookID:      target.bookID,
+				bookTextDir: target.textDir,
+
 				outputName: outputName,
 				textDir:    textDir,
 				imgDir:     imgDir,
+
+				isPart: bundle_common.IsPartDir(textDir),
 			})
 
 			if err != nil {
+				history.AddFailed()
 				log.Warnf("failed to make epub %s: %s", outputName, err)
 			} else {
+				history.AddProcessed(outputName)
 				log.Infof("book save to: %s", outputName)
 			}
 		}
 
+		if db != nil {
+			if err := history.Save(db); err != nil {
+				log.Warnf("%s", err)
+			}
+			database.Close(db)
+		}
 	}
 
 	return nil
@@ -235,25 +262,76 @@ func makeEpub(info epubInfo) error {
 
 	epub.SetAuthor(info.author)
 
-	imgNameMap, err := addImages(epub, info.imgDir)
+	if info.isPart {
+		err = addPartVolumes(epub, info)
+	} else {
+		err = addVolume(epub, info.textDir, info.imgDir, "", info)
+	}
 	if err != nil {
 		return err
 	}
 
-	ctx := context.WithValue(context.Background(), "imgNameMap", imgNameMap)
-	ctx = context.WithValue(ctx, "db", info.db)
-	ctx = context.WithValue(ctx, "url", info.tocURL)
+	epub.Write(info.outputName)
 
-	err = addTexts(epub, info.textDir, ctx)
+	return nil
+}
+
+// Adds each volume directory under part directory to epub. Every volume gets
+// a title section in TOC, with its chapters nested under it.
+func addPartVolumes(epub *epub.Epub, info epubInfo) error {
+	entryList, err := os.ReadDir(info.textDir)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to read part directory %s: %s", info.textDir, err)
 	}
 
-	epub.Write(info.outputName)
+	names := []string{}
+	for _, child := range entryList {
+		if child.IsDir() {
+			names = append(names, child.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		volumeTitle := getVolumeTitle(info, filepath.Join(info.textDir, name))
+		body := "<h1 class=\"volume-title\">" + html.EscapeString(volumeTitle) + "</h1>"
+
+		parent, err := epub.AddSection(body, volumeTitle, "", "")
+		if err != nil {
+			log.Warnf("failed to add volume section %s: %s", name, err)
+			continue
+		}
+
+		textDir := filepath.Join(info.textDir, name)
+		imgDir := info.imgDir
+		if imgDir != "" {
+			imgDir = filepath.Join(imgDir, name)
+		}
+
+		if err = addVolume(epub, textDir, imgDir, parent, info); err != nil {
+			log.Warnf("failed to add volume %s: %s", name, err)
+		}
+	}
 
 	return nil
 }
 
+// Adds images and chapter texts of a volume to epub. If `parent` is not empty,
+// chapters are added as sub-sections of section with that internal file name.
+func addVolume(epub *epub.Epub, textDir, imgDir, parent string, info epubInfo) error {
+	imgNameMap, err := addImages(epub, imgDir)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.WithValue(context.Background(), "imgNameMap", imgNameMap)
+	ctx = context.WithValue(ctx, "db", info.db)
+	ctx = context.WithValue(ctx, "url", info.tocURL)
+	ctx = context.WithValue(ctx, "bookInfo", info)
+
+	return addTexts(epub, textDir, parent, ctx)
+}
+
 // Adds all files in given directory as image into epub. Returns a map with base
 // name of original file as key, internal path in epub of that file as value.
 // If this function cannot read given directory, it will return error. But any
@@ -296,7 +374,7 @@ func addImages(epub *epub.Epub, imgDir string) (map[string]string, error) {
 // text will only be logged but not returned.
 // All `img` tags in input text content will be updated to use internal image
 // path before gets added to book.
-func addTexts(epub *epub.Epub, textDir string, ctx context.Context) error {
+func addTexts(epub *epub.Epub, textDir string, parent string, ctx context.Context) error {
 	entryList, err := os.ReadDir(textDir)
 	if err != nil {
 		return fmt.Errorf("failed to read text directory %s: %s", textDir, err)
@@ -318,7 +396,7 @@ func addTexts(epub *epub.Epub, textDir string, ctx context.Context) error {
 		}
 
 		fullPath := filepath.Join(textDir, name)
-		if err = addTextFile(epub, fullPath, ctx); err != nil {
+		if err = addTextFile(epub, fullPath, parent, ctx); err != nil {
 			fmt.Printf("failed to add %s: %s\n", fullPath, err)
 		}
 	}
@@ -327,9 +405,10 @@ func addTexts(epub *epub.Epub, textDir string, ctx context.Context) error {
 }
 
 // Adds one text file to epub. Before adding it, src attribute value of all `img`
-// gets replaced with internal image path.
+// gets replaced with internal image path. When `parent` is not empty, text is
+// added as sub-section of that section.
 // Any error happens during the process will be returned.
-func addTextFile(epub *epub.Epub, fileName string, ctx context.Context) error {
+func addTextFile(epub *epub.Epub, fileName string, parent string, ctx context.Context) error {
 	data, err := os.ReadFile(fileName)
 	if err != nil {
 		return err
@@ -341,8 +420,12 @@ func addTextFile(epub *epub.Epub, fileName string, ctx context.Context) error {
 		return err
 	}
 
-	sectionName := getSectionNameFromFileName(fileName)
-	_, err = epub.AddSection(content, sectionName, "", "")
+	sectionName := getChapterTitle(ctx, fileName)
+	if parent == "" {
+		_, err = epub.AddSection(content, sectionName, "", "")
+	} else {
+		_, err = epub.AddSubSection(parent, content, sectionName, "", "")
+	}
 	if err != nil {
 		return err
 	}
@@ -350,6 +433,35 @@ func addTextFile(epub *epub.Epub, fileName string, ctx context.Context) error {
 	return nil
 }
 
+// getChapterTitle returns chapter title recorded in database for given text
+// file. If no record is found, title is parsed from file name.
+func getChapterTitle(ctx context.Context, fileName string) string {
+	info, ok := ctx.Value("bookInfo").(epubInfo)
+	if ok && info.db != nil && info.bookID != "" {
+		if relPath, err := filepath.Rel(info.bookTextDir, fileName); err == nil {
+			if title, ok := database.GetChapterTitle(info.db, info.bookID, relPath); ok && title != "" {
+				return title
+			}
+		}
+	}
+
+	return getSectionNameFromFileName(fileName)
+}
+
+// getVolumeTitle returns volume title recorded in database for given volume
+// directory. If no record is found, title is parsed from directory name.
+func getVolumeTitle(info epubInfo, dirName string) string {
+	if info.db != nil && info.bookID != "" {
+		if relPath, err := filepath.Rel(info.bookTextDir, dirName); err == nil {
+			if title, ok := database.GetVolumeTitle(info.db, info.bookID, relPath); ok && title != "" {
+				return title
+			}
+		}
+	}
+
+	return getVolumeTitleFromDirName(filepath.Base(dirName))
+}
+
 // Returns a table of contents entry name for given text file name.
 func getSectionNameFromFileName(fileName string) string {
 	basename := filepath.Base(fileName)
@@ -363,6 +475,13 @@ func getSectionNameFromFileName(fileName string) string {
 	return sectionName
 }
 
+// Returns a table of contents entry name for given volume directory name.
+func getVolumeTitleFromDirName(dirName string) string {
+	// remove prefix automatically prepended during downloading.
+	patt := regexp.MustCompile(`^\d{3} \- `)
+	return patt.ReplaceAllString(dirName, "")
+}
+
 // Parses given text as HTML, and replace all `img` tags' `src` attribute value
 // with internal image path used by epub.
 func replaceImgSrc(content string, ctx context.Context) (string, error) {
@@ -2,6 +2,7 @@ package html_script
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"image"
@@ -11,6 +12,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"unicode"
@@ -110,6 +112,7 @@ type workerTask struct {
 }
 
 type bookInfo struct {
+	bookID        string
 	rootDir       string
 	textDir       string
 	imageDir      string
@@ -127,6 +130,8 @@ type bookInfo struct {
 
 	preprocessScript string
 	bundleOption     map[string]any
+
+	history *database.HistoryRun // statistics of bundling this book
 }
 
 type volumeInfo struct {
@@ -189,6 +194,7 @@ func loadLibraryTargets(options *options, libInfoPath string, rawKeyword string,
 		}
 
 		target := bookInfo{
+			bookID:   book.ID,
 			rootDir:  book.RootDir,
 			textDir:  book.TextDir,
 			imageDir: book.ImgDir,
@@ -235,13 +241,35 @@ func cmdMain(options options, targets []bookInfo) error {
 		go buildWorker(taskChan, &group)
 	}
 
-	buildBoss(&options, targets, taskChan, &group)
+	histories := buildBoss(&options, targets, taskChan, &group)
 
 	group.Wait()
 
+	saveHistories(targets, histories)
+
 	return nil
 }
 
+// saveHistories writes history records of bundled books to library database.
+func saveHistories(targets []bookInfo, histories []*database.HistoryRun) {
+	if len(targets) == 0 || len(histories) == 0 || targets[0].dbPath == "" {
+		return
+	}
+
+	db, err := database.Open(targets[0].dbPath)
+	if err != nil {
+		log.Warnf("%s", err)
+		return
+	}
+	defer database.Close(db)
+
+	for _, history := range histories {
+		if err := history.Save(db); err != nil {
+			log.Warnf("%s", err)
+		}
+	}
+}
+
 func getBookPreprocessScript(cliScript string, bookScript string) string {
 	if cliScript != "" {
 		return cliScript
@@ -268,8 +296,11 @@ func logWorkBeginBanner(target bookInfo) {
 
 // ----------------------------------------------------------------------------
 
-func buildBoss(options *options, targets []bookInfo, taskChan chan workerTask, group *sync.WaitGroup) {
+// buildBoss dispatches bundling tasks of all books, returns history runs of
+// books being bundled.
+func buildBoss(options *options, targets []bookInfo, taskChan chan workerTask, group *sync.WaitGroup) []*database.HistoryRun {
 	var err error
+	histories := []*database.HistoryRun{}
 
 	for _, target := range targets {
 		logWorkBeginBanner(target)
@@ -279,6 +310,13 @@ func buildBoss(options *options, targets []bookInfo, taskChan chan workerTask, g
 			continue
 		}
 
+		volume := ""
+		if target.targetVolume > 0 {
+			volume = strconv.Itoa(target.targetVolume)
+		}
+		target.history = database.NewHistoryRun(database.HistoryCommandBundleHTML, target.bookID, target.bookTitle, volume)
+		histories = append(histories, target.history)
+
 		if target.isEpubSrc {
 			err = buildFromEpubBoss(options, target, taskChan, group)
 		} else {
@@ -286,9 +324,12 @@ func buildBoss(options *options, targets []bookInfo, taskChan chan workerTask, g
 		}
 
 		if err != nil {
+			target.history.AddFailed()
 			log.Errorf("%s", err)
 		}
 	}
+
+	return histories
 }
 
 func buildWorker(taskChan chan workerTask, group *sync.WaitGroup) {
@@ -357,7 +398,7 @@ func buildFromHTMLWorker(task workerTask) {
 	textDir := filepath.Join(target.textDir, volumeName)
 	imgDir := filepath.Join(target.imageDir, volumeName)
 
-	err := bundleBook(ctx, volumeInfo{
+	outputDir, err := bundleBook(ctx, volumeInfo{
 		bookInfo:  target,
 		volume:    volumeName,
 		fullTitle: title,
@@ -369,13 +410,15 @@ func buildFromHTMLWorker(task workerTask) {
 	})
 
 	if err != nil {
+		target.history.AddFailed()
 		log.Warnf("conversion failed %s: %s", title, err)
 	} else {
+		target.history.AddProcessed(outputDir)
 		log.Infof("conversion done: %s", title)
 	}
 }
 
-func bundleBook(ctx context.Context, info volumeInfo) error {
+func bundleBook(ctx context.Context, info volumeInfo) (string, error) {
 	bookInfo := info.bookInfo
 
 	ls, stateInfo, err := luamodule.MakeConverterLuaState(info.converterScript, luamodule.ConversionArgs{
@@ -397,17 +440,17 @@ func bundleBook(ctx context.Context, info volumeInfo) error {
 	}
 
 	if err != nil {
-		return fmt.Errorf("failed to prepare Lua state for converter script: %s", err)
+		return "", fmt.Errorf("failed to prepare Lua state for converter script: %s", err)
 	}
 
 	relativeImgDir, err := filepath.Rel(stateInfo.Meta.OutputDir, info.imgDir)
 	if err != nil {
-		return fmt.Errorf("failed to get realtive path of image asset directory: %s", err)
+		return "", fmt.Errorf("failed to get realtive path of image asset directory: %s", err)
 	}
 
 	nodes, err := readTextFiles(info.textDir)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	ctx = context.WithValue(ctx, "imgDir", info.imgDir)
@@ -448,16 +491,16 @@ func bundleBook(ctx context.Context, info volumeInfo) error {
 		if processed, err := luamodule.RunPreprocessScript(nodes, bookInfo.preprocessScript, meta); err == nil {
 			nodes = processed
 		} else {
-			return err
+			return "", err
 		}
 	}
 
 	err = os.MkdirAll(stateInfo.Meta.OutputDir, 0o777)
 	if err != nil {
-		return fmt.Errorf("failed to create output directory %s: %s", stateInfo.Meta.OutputDir, err)
+		return "", fmt.Errorf("failed to create output directory %s: %s", stateInfo.Meta.OutputDir, err)
 	}
 
-	return runConverterScript(ls, stateInfo, nodes)
+	return stateInfo.Meta.OutputDir, runConverterScript(ls, stateInfo, nodes)
 }
 
 func readTextFiles(textDir string) ([]*html.Node, error) {
@@ -497,14 +540,17 @@ func readTextFiles(textDir string) ([]*html.Node, error) {
 // gets replaced with internal image path.
 // Any error happens during the process will be returned.
 func readTextFile(fileName string) (*html.Node, error) {
-	file, err := os.Open(fileName)
+	data, err := os.ReadFile(fileName)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
-	reader := bufio.NewReader(file)
-	tree, err := html.Parse(reader)
+	data, _, err = common.DecodeHTMLToUTF8(data, "")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", fileName, err)
+	}
+
+	tree, err := html.Parse(bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
@@ -679,7 +725,7 @@ func buildFromEpubWorker(task workerTask) {
 		title = fmt.Sprintf("%s %s", target.bookTitle, volumeName)
 	}
 
-	err := extractEpub(overwriteAssets, volumeInfo{
+	outputDir, err := extractEpub(overwriteAssets, volumeInfo{
 		bookInfo:  target,
 		volume:    volumeName,
 		fullTitle: title,
@@ -690,13 +736,15 @@ func buildFromEpubWorker(task workerTask) {
 	})
 
 	if err != nil {
+		target.history.AddFailed()
 		log.Warnf("conversion failed %s: %s", title, err)
 	} else {
+		target.history.AddProcessed(outputDir)
 		log.Infof("conversion done: %s", title)
 	}
 }
 
-func extractEpub(overwriteAssets bool, info volumeInfo) error {
+func extractEpub(overwriteAssets bool, info volumeInfo) (string, error) {
 	bookInfo := info.bookInfo
 
 	ls, stateInfo, err := luamodule.MakeConverterLuaState(info.converterScript, luamodule.ConversionArgs{
@@ -719,15 +767,15 @@ func extractEpub(overwriteAssets bool, info volumeInfo) error {
 	}
 
 	if err != nil {
-		return fmt.Errorf("failed to prepare Lua state for converter script: %s", err)
+		return "", fmt.Errorf("failed to prepare Lua state for converter script: %s", err)
 	}
 
 	err = os.MkdirAll(stateInfo.Meta.OutputDir, 0o777)
 	if err != nil {
-		return fmt.Errorf("failed to create output directory %s: %s", stateInfo.Meta.OutputDir, err)
+		return "", fmt.Errorf("failed to create output directory %s: %s", stateInfo.Meta.OutputDir, err)
 	}
 
-	return epub.Merge(epub.EpubMergeOptions{
+	err = epub.Merge(epub.EpubMergeOptions{
 		ReaderOption: epub.EpubReaderOptions{
 			EpubFile:     info.epubFile,
 			OutputDir:    stateInfo.Meta.OutputDir,
@@ -771,6 +819,8 @@ func extractEpub(overwriteAssets bool, info volumeInfo) error {
 			return runConverterScript(ls, stateInfo, nodes)
 		},
 	})
+
+	return stateInfo.Meta.OutputDir, err
 }
 
 // ----------------------------------------------------------------------------
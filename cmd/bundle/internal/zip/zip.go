@@ -10,11 +10,13 @@ import (
 	"path/filepath"
 	"runtime"
 	"slices"
+	"strconv"
 	"strings"
 
 	book_mgr "github.com/SirZenith/delite/book_management"
 	bundle_common "github.com/SirZenith/delite/cmd/bundle/internal/common"
 	"github.com/SirZenith/delite/common"
+	"github.com/SirZenith/delite/database"
 	"github.com/charmbracelet/log"
 	"github.com/urfave/cli/v3"
 )
@@ -78,6 +80,8 @@ func Cmd() *cli.Command {
 }
 
 type bookInfo struct {
+	bookID    string
+	dbPath    string
 	rootDir   string
 	textDir   string
 	imageDir  string
@@ -140,6 +144,8 @@ func loadLibraryTargets(libInfoPath string, rawKeyword string, volumeIndex int)
 		}
 
 		targets = append(targets, bookInfo{
+			bookID:    book.ID,
+			dbPath:    info.DatabasePath,
 			rootDir:   book.RootDir,
 			textDir:   book.TextDir,
 			imageDir:  book.ImgDir,
@@ -176,6 +182,12 @@ func cmdMain(options options, targets []bookInfo) error {
 			continue
 		}
 
+		volume := ""
+		if target.targetVolume > 0 {
+			volume = strconv.Itoa(target.targetVolume)
+		}
+		history := database.NewHistoryRun(database.HistoryCommandBundleZip, target.bookID, target.bookTitle, volume)
+
 		for index, child := range entryList {
 			if target.targetVolume > 0 && index+1 != target.targetVolume {
 				continue
@@ -208,17 +220,38 @@ func cmdMain(options options, targets []bookInfo) error {
 			})
 
 			if err != nil {
+				history.AddFailed()
 				log.Warnf("failed to make epub %s: %s", outputName, err)
 			} else {
+				history.AddProcessed(outputName)
 				log.Infof("book save to: %s", outputName)
 			}
 		}
 
+		saveHistory(target.dbPath, history)
 	}
 
 	return nil
 }
 
+// saveHistory writes history record of bundled book to library database.
+func saveHistory(dbPath string, history *database.HistoryRun) {
+	if dbPath == "" {
+		return
+	}
+
+	db, err := database.Open(dbPath)
+	if err != nil {
+		log.Warnf("%s", err)
+		return
+	}
+	defer database.Close(db)
+
+	if err := history.Save(db); err != nil {
+		log.Warnf("%s", err)
+	}
+}
+
 // logWorkBeginBanner prints a banner indicating a new download of book starts.
 func logWorkBeginBanner(target bookInfo) {
 	msgs := []string{
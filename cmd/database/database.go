@@ -2,10 +2,18 @@ package database
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/SirZenith/delite/database"
+	"github.com/charmbracelet/log"
+	"github.com/mattn/go-runewidth"
 	"github.com/urfave/cli/v3"
+	"gorm.io/gorm"
 )
 
 func Cmd() *cli.Command {
@@ -13,9 +21,106 @@ func Cmd() *cli.Command {
 		Name:  "database",
 		Usage: "database management utility",
 		Commands: []*cli.Command{
+			subCmdBackup(),
 			subCmdExport(),
+			subCmdFsck(),
 			subCmdImport(),
 			subCmdMigrate(),
+			subCmdQuery(),
+			subCmdRestore(),
+			subCmdSyncBooks(),
+		},
+	}
+}
+
+func subCmdBackup() *cli.Command {
+	var dbPath string
+	var outputPath string
+
+	return &cli.Command{
+		Name:  "backup",
+		Usage: "dump all tables in database into a compressed archive",
+		Arguments: []cli.Argument{
+			&cli.StringArg{
+				Name:        "dbpath",
+				UsageText:   "<db>",
+				Destination: &dbPath,
+				Min:         1,
+				Max:         1,
+			},
+			&cli.StringArg{
+				Name:        "output",
+				UsageText:   " <out>",
+				Destination: &outputPath,
+				Min:         1,
+				Max:         1,
+			},
+		},
+		Action: func(_ context.Context, _ *cli.Command) error {
+			db, err := database.OpenNoMigrate(dbPath)
+			if err != nil {
+				return err
+			}
+			defer database.Close(db)
+
+			info, err := database.Backup(db, outputPath)
+			if err != nil {
+				return err
+			}
+
+			log.Infof("schema version: %d", info.SchemaVersion)
+			for _, table := range info.Tables {
+				log.Infof("%s: %d record(s)", table.Name, table.Count)
+			}
+			log.Infof("backup saved to %s", outputPath)
+
+			return nil
+		},
+	}
+}
+
+func subCmdRestore() *cli.Command {
+	var archivePath string
+	var dbPath string
+
+	return &cli.Command{
+		Name:  "restore",
+		Usage: "recreate database from backup archive in a new database file",
+		Arguments: []cli.Argument{
+			&cli.StringArg{
+				Name:        "archive",
+				UsageText:   "<archive>",
+				Destination: &archivePath,
+				Min:         1,
+				Max:         1,
+			},
+			&cli.StringArg{
+				Name:        "dbpath",
+				UsageText:   " <db>",
+				Destination: &dbPath,
+				Min:         1,
+				Max:         1,
+			},
+		},
+		Action: func(_ context.Context, _ *cli.Command) error {
+			info, err := database.Restore(archivePath, dbPath)
+			if err != nil {
+				return err
+			}
+
+			log.Infof("backup created at: %s", info.CreatedAt.Format(time.DateTime))
+			log.Infof("schema version: %d", info.SchemaVersion)
+			for _, table := range info.Tables {
+				log.Infof("%s: %d record(s)", table.Name, table.Count)
+			}
+			log.Infof("database restored to %s", dbPath)
+			log.Infof("full-text search index is not included in backup, run `library search --reindex` to rebuild it")
+
+			if info.SchemaVersion < database.LatestVersion() {
+				log.Infof("restored database is at schema version %d, run `database migrate` to upgrade it", info.SchemaVersion)
+			}
+
+			return nil
 		},
 	}
 }
@@ -23,11 +128,17 @@ func Cmd() *cli.Command {
 func subCmdExport() *cli.Command {
 	var dbPath string
 	var tableName string
-	var csvFilePath string
+	var dataFilePath string
 
 	return &cli.Command{
 		Name:  "export",
-		Usage: "export data as CSV",
+		Usage: "export table data to file",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "data format, one of csv, json, jsonl. Guessed by file extension by default",
+			},
+		},
 		Arguments: []cli.Argument{
 			&cli.StringArg{
 				Name:        "dbpath",
@@ -44,25 +155,31 @@ func subCmdExport() *cli.Command {
 				Max:         1,
 			},
 			&cli.StringArg{
-				Name:        "csv-file",
-				UsageText:   " <csv>",
-				Destination: &csvFilePath,
+				Name:        "data-file",
+				UsageText:   " <file>",
+				Destination: &dataFilePath,
 				Min:         1,
 				Max:         1,
 			},
 		},
-		Action: func(_ context.Context, _ *cli.Command) error {
+		Action: func(_ context.Context, cmd *cli.Command) error {
 			model := database.GetModel(tableName)
 			if model == nil {
 				return fmt.Errorf("invald table name %q", tableName)
 			}
 
+			format, err := getDataFormat(cmd, dataFilePath)
+			if err != nil {
+				return err
+			}
+
 			db, err := database.Open(dbPath)
 			if err != nil {
 				return err
 			}
+			defer database.Close(db)
 
-			err = database.ExportCSV(db, model, csvFilePath)
+			err = database.ExportFile(db, model, dataFilePath, format)
 			if err != nil {
 				return fmt.Errorf("failed to export table %s: %s", tableName, err)
 			}
@@ -74,12 +191,27 @@ func subCmdExport() *cli.Command {
 
 func subCmdImport() *cli.Command {
 	var dbPath string
-	var csvFilePath string
+	var dataFilePath string
 	var tableName string
 
 	return &cli.Command{
 		Name:  "import",
-		Usage: "import data from CSV",
+		Usage: "import table data from file",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "data format, one of csv, json, jsonl. Guessed by file extension by default",
+			},
+			&cli.StringFlag{
+				Name:  "on-conflict",
+				Usage: "how to handle record conflicting with existing one, one of " + strings.Join(database.AllConflictPolicies, ", "),
+				Value: database.ConflictSkip,
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "print changes to be made without writing database",
+			},
+		},
 		Arguments: []cli.Argument{
 			&cli.StringArg{
 				Name:        "dbpath",
@@ -89,9 +221,9 @@ func subCmdImport() *cli.Command {
 				Max:         1,
 			},
 			&cli.StringArg{
-				Name:        "csv-file",
-				UsageText:   " <csv>",
-				Destination: &csvFilePath,
+				Name:        "data-file",
+				UsageText:   " <file>",
+				Destination: &dataFilePath,
 				Min:         1,
 				Max:         1,
 			},
@@ -103,33 +235,98 @@ func subCmdImport() *cli.Command {
 				Max:         1,
 			},
 		},
-		Action: func(_ context.Context, _ *cli.Command) error {
+		Action: func(_ context.Context, cmd *cli.Command) error {
 			model := database.GetModel(tableName)
 			if model == nil {
 				return fmt.Errorf("invald table name %q", tableName)
 			}
 
+			format, err := getDataFormat(cmd, dataFilePath)
+			if err != nil {
+				return err
+			}
+
 			db, err := database.Open(dbPath)
 			if err != nil {
 				return err
 			}
+			defer database.Close(db)
 
-			err = database.ImportCSV(db, model, csvFilePath)
+			options := database.ImportOptions{
+				OnConflict: cmd.String("on-conflict"),
+				DryRun:     cmd.Bool("dry-run"),
+			}
+
+			report, err := database.ImportFile(db, model, dataFilePath, format, options)
 			if err != nil {
-				return fmt.Errorf("failed to export table %s: %s", tableName, err)
+				return fmt.Errorf("failed to import table %s: %s", tableName, err)
 			}
 
+			if options.DryRun {
+				printImportDiffs(report)
+			}
+
+			log.Infof(
+				"inserted: %d, updated: %d, unchanged: %d, skipped: %d",
+				report.Inserted, report.Updated, report.Unchanged, report.Skipped,
+			)
+
 			return nil
 		},
 	}
 }
 
+// printImportDiffs prints records to be inserted, updated or skipped by import.
+func printImportDiffs(report *database.ImportReport) {
+	for _, diff := range report.Diffs {
+		if diff.IsNew {
+			fmt.Printf("+ %s\n", diff.Key)
+			continue
+		}
+
+		if diff.IsSkipped {
+			fmt.Printf("! %s (skipped)\n", diff.Key)
+		} else {
+			fmt.Printf("~ %s\n", diff.Key)
+		}
+		for _, change := range diff.Changes {
+			fmt.Printf("    %s: %q -> %q\n", change.Column, change.Old, change.New)
+		}
+	}
+}
+
+// getDataFormat returns data format specified by `--format` flag, or format
+// guessed by file name if flag is not set.
+func getDataFormat(cmd *cli.Command, fileName string) (string, error) {
+	format := strings.ToLower(cmd.String("format"))
+	if format == "" {
+		return database.GetFormatByFileName(fileName), nil
+	}
+
+	if !slices.Contains(database.AllFormats, format) {
+		return "", fmt.Errorf("invalid format %q, expecting one of: %s", format, strings.Join(database.AllFormats, ", "))
+	}
+
+	return format, nil
+}
+
 func subCmdMigrate() *cli.Command {
 	var dbPath string
 
 	return &cli.Command{
 		Name:  "migrate",
-		Usage: "auto migrate database schema",
+		Usage: "apply pending schema migrations to database",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "status",
+				Usage: "print applying state of all migrations instead of migrating",
+			},
+			&cli.IntFlag{
+				Name:  "to",
+				Usage: "migrate up to given version, latest version is used by default",
+				Value: -1,
+			},
+		},
 		Arguments: []cli.Argument{
 			&cli.StringArg{
 				Name:        "dbpath",
@@ -139,13 +336,253 @@ func subCmdMigrate() *cli.Command {
 				Max:         1,
 			},
 		},
-		Action: func(_ context.Context, _ *cli.Command) error {
+		Action: func(_ context.Context, cmd *cli.Command) error {
+			db, err := database.OpenNoMigrate(dbPath)
+			if err != nil {
+				return err
+			}
+			defer database.Close(db)
+
+			if cmd.Bool("status") {
+				return printMigrationStatus(db)
+			}
+
+			target := int(cmd.Int("to"))
+			if target < 0 {
+				target = database.LatestVersion()
+			}
+
+			return database.MigrateTo(db, target)
+		},
+	}
+}
+
+// printMigrationStatus prints all migrations with their applying state.
+func printMigrationStatus(db *gorm.DB) error {
+	states, err := database.GetMigrationStates(db)
+	if err != nil {
+		return err
+	}
+
+	for _, state := range states {
+		if state.Applied {
+			fmt.Printf("[x] %03d %s (applied at %s)\n", state.Version, state.Name, state.AppliedAt.Format(time.DateTime))
+		} else {
+			fmt.Printf("[ ] %03d %s\n", state.Version, state.Name)
+		}
+	}
+
+	return nil
+}
+
+func subCmdQuery() *cli.Command {
+	var dbPath string
+	var tableName string
+
+	return &cli.Command{
+		Name:  "query",
+		Usage: "list records in table with filters",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "book",
+				Usage: "only records whose book name contains given string",
+			},
+			&cli.StringFlag{
+				Name:  "volume",
+				Usage: "only records whose volume name contains given string",
+			},
+			&cli.StringFlag{
+				Name:  "url-like",
+				Usage: "SQL LIKE pattern matched against URL columns, plain string is matched as sub-string",
+			},
+			&cli.BoolFlag{
+				Name:  "failed",
+				Usage: "only records marked as download failed",
+			},
+			&cli.BoolFlag{
+				Name:  "deleted",
+				Usage: "only soft deleted records",
+			},
+			&cli.StringFlag{
+				Name:  "sort",
+				Usage: "column to sort records by, prefix with `-` for descending order",
+			},
+			&cli.IntFlag{
+				Name:  "limit",
+				Usage: "max number of records to print, 0 for no limit",
+				Value: 50,
+			},
+			&cli.IntFlag{
+				Name:  "page",
+				Usage: "page number starting from 1, page size is decided by --limit",
+				Value: 1,
+			},
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "output format, one of table, json",
+				Value: "table",
+			},
+		},
+		Arguments: []cli.Argument{
+			&cli.StringArg{
+				Name:        "dbpath",
+				UsageText:   "<db>",
+				Destination: &dbPath,
+				Min:         1,
+				Max:         1,
+			},
+			&cli.StringArg{
+				Name:        "table-name",
+				UsageText:   " <table>",
+				Destination: &tableName,
+				Min:         1,
+				Max:         1,
+			},
+		},
+		Action: func(_ context.Context, cmd *cli.Command) error {
+			format := strings.ToLower(cmd.String("format"))
+			if format != "table" && format != "json" {
+				return fmt.Errorf("invalid format %q, expecting one of: table, json", format)
+			}
+
+			limit := int(cmd.Int("limit"))
+			page := int(cmd.Int("page"))
+			if limit < 0 {
+				return fmt.Errorf("invalid limit %d", limit)
+			}
+			if page < 1 {
+				return fmt.Errorf("invalid page number %d", page)
+			}
+
+			options := database.QueryOptions{
+				Book:    cmd.String("book"),
+				Volume:  cmd.String("volume"),
+				URLLike: cmd.String("url-like"),
+				Failed:  cmd.Bool("failed"),
+				Deleted: cmd.Bool("deleted"),
+				Sort:    cmd.String("sort"),
+				Limit:   limit,
+				Offset:  (page - 1) * limit,
+			}
+
 			db, err := database.Open(dbPath)
 			if err != nil {
 				return err
 			}
+			defer database.Close(db)
 
-			return database.Migrate(db)
+			result, err := database.QueryTable(db, tableName, options)
+			if err != nil {
+				return err
+			}
+
+			if format == "json" {
+				return printQueryResultJSON(result)
+			}
+
+			printQueryResultTable(result)
+			if len(result.Rows) > 0 {
+				fmt.Printf("\n%d-%d of %d record(s)\n", options.Offset+1, options.Offset+len(result.Rows), result.Total)
+			} else {
+				fmt.Printf("no record in page, %d matching record(s) in total\n", result.Total)
+			}
+
+			return nil
 		},
 	}
 }
+
+// formatQueryValue converts field value into text for table output.
+func formatQueryValue(value any) string {
+	switch v := value.(type) {
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Local().Format(time.DateTime)
+	case gorm.DeletedAt:
+		if !v.Valid {
+			return ""
+		}
+		return v.Time.Local().Format(time.DateTime)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// printQueryResultTable prints records as an aligned table, column width is
+// measured in terminal cells so that CJK text lines up.
+func printQueryResultTable(result *database.QueryResult) {
+	widths := make([]int, len(result.Columns))
+	for i, column := range result.Columns {
+		widths[i] = runewidth.StringWidth(column)
+	}
+
+	cells := make([][]string, 0, len(result.Rows))
+	for _, row := range result.Rows {
+		line := make([]string, len(row))
+		for i, value := range row {
+			text := strings.ReplaceAll(formatQueryValue(value), "\n", " ")
+			line[i] = text
+			widths[i] = max(widths[i], runewidth.StringWidth(text))
+		}
+		cells = append(cells, line)
+	}
+
+	printLine := func(line []string) {
+		buffer := strings.Builder{}
+		for i, text := range line {
+			if i > 0 {
+				buffer.WriteString("  ")
+			}
+			if i < len(line)-1 {
+				text = runewidth.FillRight(text, widths[i])
+			}
+			buffer.WriteString(text)
+		}
+		fmt.Println(buffer.String())
+	}
+
+	printLine(result.Columns)
+
+	separator := make([]string, len(widths))
+	for i, width := range widths {
+		separator[i] = strings.Repeat("-", width)
+	}
+	printLine(separator)
+
+	for _, line := range cells {
+		printLine(line)
+	}
+}
+
+// printQueryResultJSON prints records as a JSON array, each record is an object
+// keyed by column names.
+func printQueryResultJSON(result *database.QueryResult) error {
+	records := make([]json.RawMessage, 0, len(result.Rows))
+	for _, row := range result.Rows {
+		buffer := strings.Builder{}
+		buffer.WriteByte('{')
+		for i, value := range row {
+			key, _ := json.Marshal(result.Columns[i])
+			data, err := json.Marshal(value)
+			if err != nil {
+				return fmt.Errorf("failed to marshal column %s: %s", result.Columns[i], err)
+			}
+
+			if i > 0 {
+				buffer.WriteByte(',')
+			}
+			buffer.Write(key)
+			buffer.WriteByte(':')
+			buffer.Write(data)
+		}
+		buffer.WriteByte('}')
+
+		records = append(records, json.RawMessage(buffer.String()))
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(records)
+}
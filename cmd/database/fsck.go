@@ -0,0 +1,579 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	book_mgr "github.com/SirZenith/delite/book_management"
+	"github.com/SirZenith/delite/common"
+	"github.com/SirZenith/delite/database"
+	"github.com/SirZenith/delite/database/data_model"
+	"github.com/charmbracelet/log"
+	"github.com/urfave/cli/v3"
+	"gorm.io/gorm"
+)
+
+// default database name used by gelbooru downloader when library provides none.
+const fsckDefaultDbName = "library.db"
+
+// extensions of files considered as downloaded images in image directory.
+var fsckImageExts = []string{".avif", ".bmp", ".gif", ".jpeg", ".jpg", ".png", ".tif", ".tiff", ".webp"}
+
+// matches directory or file name with index prefix, e.g. `001 - Title`,
+// `Part.001 - Title`.
+var patternIndexedName = regexp.MustCompile(`^(?:\d+|Part\.\d+) - (.+)$`)
+
+// matches directory or file name generated for entry with empty title.
+var patternIndexOnlyName = regexp.MustCompile(`^(Part|Vol|Chap)\.\d+(\.html)?$`)
+
+func subCmdFsck() *cli.Command {
+	var rawKeyword string
+
+	return &cli.Command{
+		Name:  "fsck",
+		Usage: "cross-check download records in database against files on disk",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "fix",
+				Usage: "soft-delete stale records, register orphan files, and queue missing tagged posts for re-download",
+			},
+			&cli.StringFlag{
+				Name:  "library",
+				Usage: "path to library info JSON file",
+				Value: "./library.json",
+			},
+		},
+		Arguments: []cli.Argument{
+			&cli.StringArg{
+				Name:        "keyword",
+				UsageText:   "<keyword>",
+				Destination: &rawKeyword,
+				Max:         1,
+			},
+		},
+		Action: func(_ context.Context, cmd *cli.Command) error {
+			info, err := book_mgr.ReadLibraryInfo(cmd.String("library"))
+			if err != nil {
+				return err
+			}
+
+			dbPath := common.GetStrOr(info.DatabasePath, filepath.Join(info.RootDir, fsckDefaultDbName))
+			db, err := database.Open(dbPath)
+			if err != nil {
+				return err
+			}
+			defer database.Close(db)
+
+			return fsckMain(db, info, book_mgr.NewSearchKeyword(rawKeyword), cmd.Bool("fix"))
+		},
+	}
+}
+
+// fsckResult counts problems found in one target.
+type fsckResult struct {
+	missing   int // records whose file does not exist
+	orphan    int // files with no record
+	duplicate int // records pointing at a file already taken by another record
+	fixed     int // problems fixed
+}
+
+func (r *fsckResult) add(other fsckResult) {
+	r.missing += other.missing
+	r.orphan += other.orphan
+	r.duplicate += other.duplicate
+	r.fixed += other.fixed
+}
+
+func (r *fsckResult) isClean() bool {
+	return r.missing == 0 && r.orphan == 0 && r.duplicate == 0
+}
+
+func fsckMain(db *gorm.DB, info *book_mgr.LibraryInfo, keyword *book_mgr.SearchKeyword, isFix bool) error {
+	total := fsckResult{}
+
+	for i, book := range info.Books {
+		if !keyword.MatchBook(i, book) || book.LocalInfo != nil || book.TocURL == "" {
+			continue
+		}
+
+		result, err := fsckBook(db, book, isFix)
+		if err != nil {
+			log.Errorf("%s: %s", book.Title, err)
+			continue
+		}
+
+		logFsckResult(book.Title, result, isFix)
+		total.add(result)
+	}
+
+	for i, tag := range info.TaggedPosts {
+		if !keyword.MatchTaggedPost(i, tag) {
+			continue
+		}
+
+		result, err := fsckTaggedPost(db, tag, isFix)
+		if err != nil {
+			log.Errorf("%s: %s", tag.Tag, err)
+			continue
+		}
+
+		logFsckResult(tag.Tag, result, isFix)
+		total.add(result)
+	}
+
+	log.Infof(
+		"total: %d missing file(s), %d orphan file(s), %d duplicate record(s)",
+		total.missing, total.orphan, total.duplicate,
+	)
+
+	if isFix {
+		log.Infof("%d problem(s) fixed", total.fixed)
+	} else if !total.isClean() {
+		log.Infof("run with --fix to repair database")
+	}
+
+	return nil
+}
+
+func logFsckResult(name string, result fsckResult, isFix bool) {
+	if result.isClean() {
+		log.Debugf("%s: ok", name)
+		return
+	}
+
+	msg := fmt.Sprintf(
+		"%s: %d missing file(s), %d orphan file(s), %d duplicate record(s)",
+		name, result.missing, result.orphan, result.duplicate,
+	)
+	if isFix {
+		msg += fmt.Sprintf(", %d fixed", result.fixed)
+	}
+
+	log.Warn(msg)
+}
+
+// ----------------------------------------------------------------------------
+// Disk index
+
+// diskFile is a downloaded file found on disk.
+type diskFile struct {
+	path   string // path to file
+	part   string // name of part directory, empty if file is not placed under part directory
+	volume string // name of volume directory, empty if file is placed at top level
+	key    string // name used for matching file with database record
+
+	claimed bool // whether the file is already taken by a record
+}
+
+// diskIndex maps matching key to files.
+type diskIndex map[string][]*diskFile
+
+// buildDiskIndex walks through directory, collects all files accepted by
+// `filter`, and indexes them by key returned by `keyOf`.
+func buildDiskIndex(rootDir string, filter func(name string) bool, keyOf func(name string) string) (diskIndex, error) {
+	index := diskIndex{}
+
+	if _, err := os.Stat(rootDir); os.IsNotExist(err) {
+		return index, nil
+	}
+
+	err := filepath.WalkDir(rootDir, func(fileName string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		name := d.Name()
+		if d.IsDir() || strings.HasPrefix(name, ".") || !filter(name) {
+			return nil
+		}
+
+		relDir, _ := filepath.Rel(rootDir, filepath.Dir(fileName))
+
+		file := &diskFile{
+			path: fileName,
+			key:  keyOf(name),
+		}
+
+		if relDir != "." {
+			segments := strings.Split(filepath.ToSlash(relDir), "/")
+			file.volume = segments[len(segments)-1]
+			if len(segments) >= 2 {
+				file.part = segments[len(segments)-2]
+			}
+		}
+
+		index[file.key] = append(index[file.key], file)
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %s", rootDir, err)
+	}
+
+	return index, nil
+}
+
+// find returns the first file with given key accepted by `match`, unclaimed
+// files are preferred. Returns nil if no file is accepted.
+func (index diskIndex) find(key string, match func(file *diskFile) bool) *diskFile {
+	var result *diskFile
+	for _, file := range index[key] {
+		if !match(file) {
+			continue
+		}
+
+		if !file.claimed {
+			return file
+		}
+
+		if result == nil {
+			result = file
+		}
+	}
+
+	return result
+}
+
+// orphans returns all files not claimed by any record.
+func (index diskIndex) orphans() []*diskFile {
+	result := []*diskFile{}
+	for _, files := range index {
+		for _, file := range files {
+			if !file.claimed {
+				result = append(result, file)
+			}
+		}
+	}
+
+	slices.SortFunc(result, func(a, b *diskFile) int {
+		return strings.Compare(a.path, b.path)
+	})
+
+	return result
+}
+
+// matchDirName checks if directory name is generated from given title.
+func matchDirName(dirName, title string) bool {
+	if title == "" {
+		return dirName == "" || patternIndexOnlyName.MatchString(dirName)
+	}
+
+	title = common.InvalidPathCharReplace(title)
+	if dirName == title {
+		return true
+	}
+
+	match := patternIndexedName.FindStringSubmatch(dirName)
+	return match != nil && match[1] == title
+}
+
+// titleFromName strips index prefix of directory or file name generated by
+// downloader, and returns the original title.
+func titleFromName(name string) string {
+	if patternIndexOnlyName.MatchString(name) {
+		return ""
+	}
+
+	if match := patternIndexedName.FindStringSubmatch(name); match != nil {
+		return match[1]
+	}
+
+	return name
+}
+
+// makeFileURL returns `file://` URL used as record key of files registered
+// without known download URL.
+func makeFileURL(fileName string) string {
+	absName, err := filepath.Abs(fileName)
+	if err != nil {
+		absName = fileName
+	}
+
+	fileURL := url.URL{Scheme: "file", Path: filepath.ToSlash(absName)}
+	return fileURL.String()
+}
+
+func isImageFileName(name string) bool {
+	return slices.Contains(fsckImageExts, strings.ToLower(filepath.Ext(name)))
+}
+
+func isChapterFileName(name string) bool {
+	return strings.ToLower(filepath.Ext(name)) == ".html"
+}
+
+// chapterKeyOf returns chapter title in chapter file name.
+func chapterKeyOf(name string) string {
+	return titleFromName(strings.TrimSuffix(name, filepath.Ext(name)))
+}
+
+// ----------------------------------------------------------------------------
+// Book
+
+// fsckBook checks file records of a book against its raw text directory and
+// image directory.
+func fsckBook(db *gorm.DB, book book_mgr.BookInfo, isFix bool) (fsckResult, error) {
+	result := fsckResult{}
+
+	chapterIndex, err := buildDiskIndex(book.RawDir, isChapterFileName, chapterKeyOf)
+	if err != nil {
+		return result, err
+	}
+
+	imageIndex, err := buildDiskIndex(book.ImgDir, isImageFileName, func(name string) string { return name })
+	if err != nil {
+		return result, err
+	}
+
+	// newer records are visited first, so they are kept when duplicates are found.
+	entries := []data_model.FileEntry{}
+	if err := db.Order("updated_at DESC").Find(&entries, "book = ?", book.Title).Error; err != nil {
+		return result, fmt.Errorf("failed to read file records: %s", err)
+	}
+
+	staleEntries := []*data_model.FileEntry{}
+	for i := range entries {
+		entry := &entries[i]
+
+		file := findFileOfEntry(chapterIndex, imageIndex, entry)
+		if file == nil {
+			log.Warnf("missing file: %s (%s)", entry.FileName, entry.URL)
+			result.missing++
+			staleEntries = append(staleEntries, entry)
+		} else if file.claimed {
+			log.Warnf("duplicate record: %s -> %s", entry.URL, file.path)
+			result.duplicate++
+			staleEntries = append(staleEntries, entry)
+		} else {
+			file.claimed = true
+		}
+	}
+
+	orphans := append(chapterIndex.orphans(), imageIndex.orphans()...)
+	for _, file := range orphans {
+		log.Warnf("orphan file: %s", file.path)
+	}
+	result.orphan = len(orphans)
+
+	if !isFix {
+		return result, nil
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, entry := range staleEntries {
+			if err := tx.Delete(entry).Error; err != nil {
+				return fmt.Errorf("failed to delete record %s: %s", entry.URL, err)
+			}
+			result.fixed++
+		}
+
+		for _, file := range orphans {
+			if err := registerBookFile(tx, book.Title, file); err != nil {
+				return err
+			}
+			result.fixed++
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+// findFileOfEntry looks up file pointed by record in disk indices.
+func findFileOfEntry(chapterIndex, imageIndex diskIndex, entry *data_model.FileEntry) *diskFile {
+	match := func(file *diskFile) bool {
+		if !matchDirName(file.volume, entry.Volume) {
+			return false
+		}
+
+		return entry.Part == "" || matchDirName(file.part, entry.Part)
+	}
+
+	if isImageFileName(entry.FileName) {
+		return imageIndex.find(entry.FileName, match)
+	}
+
+	return chapterIndex.find(common.InvalidPathCharReplace(entry.FileName), match)
+}
+
+// registerBookFile restores soft deleted record of orphan file if there is one,
+// else a new record with file URL is created for it.
+func registerBookFile(tx *gorm.DB, bookTitle string, file *diskFile) error {
+	isImage := isImageFileName(file.path)
+
+	deleted := []data_model.FileEntry{}
+	if err := tx.Unscoped().Order("updated_at DESC").Find(&deleted, "book = ? AND deleted_at IS NOT NULL", bookTitle).Error; err != nil {
+		return fmt.Errorf("failed to read deleted records: %s", err)
+	}
+
+	for i := range deleted {
+		entry := &deleted[i]
+		if isImageFileName(entry.FileName) != isImage {
+			continue
+		}
+
+		index := diskIndex{file.key: {file}}
+		if findFileOfEntry(index, index, entry) == nil {
+			continue
+		}
+
+		if err := tx.Unscoped().Model(entry).Update("deleted_at", nil).Error; err != nil {
+			return fmt.Errorf("failed to restore record %s: %s", entry.URL, err)
+		}
+		log.Infof("restore record: %s -> %s", entry.URL, file.path)
+
+		return nil
+	}
+
+	entry := data_model.FileEntry{
+		URL:      makeFileURL(file.path),
+		Book:     bookTitle,
+		Part:     titleFromName(file.part),
+		Volume:   titleFromName(file.volume),
+		FileName: file.key,
+	}
+	if err := tx.Save(&entry).Error; err != nil {
+		return fmt.Errorf("failed to register %s: %s", file.path, err)
+	}
+	log.Infof("register file: %s", file.path)
+
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// Tagged post
+
+// fsckTaggedPost checks post records of a tag against its gelbooru output
+// directory.
+func fsckTaggedPost(db *gorm.DB, tag book_mgr.TaggedPostInfo, isFix bool) (fsckResult, error) {
+	result := fsckResult{}
+
+	// same output directory as gelbooru downloader uses
+	outputDir := common.GetStrOr(tag.Title, common.InvalidPathCharReplace(tag.Tag))
+
+	index, err := buildDiskIndex(
+		outputDir,
+		func(name string) bool { return !strings.HasSuffix(name, ".tmp") },
+		func(name string) string { return name },
+	)
+	if err != nil {
+		return result, err
+	}
+
+	entries := []data_model.TaggedPostEntry{}
+	if err := db.Order("updated_at DESC").Find(&entries, "tag = ?", tag.Tag).Error; err != nil {
+		return result, fmt.Errorf("failed to read post records: %s", err)
+	}
+
+	missingEntries := []*data_model.TaggedPostEntry{}
+	duplicateEntries := []*data_model.TaggedPostEntry{}
+	for i := range entries {
+		entry := &entries[i]
+		if entry.FileName == "" {
+			continue
+		}
+
+		file := index.find(entry.FileName, func(file *diskFile) bool { return file.volume == "" })
+		if file == nil {
+			if entry.MarkDeleted {
+				continue
+			}
+
+			if entry.DlFailed {
+				log.Debugf("missing file already queued: %s (%s)", entry.FileName, entry.ContentURL)
+			} else {
+				log.Warnf("missing file: %s (%s)", entry.FileName, entry.ContentURL)
+				result.missing++
+				missingEntries = append(missingEntries, entry)
+			}
+		} else if file.claimed {
+			log.Warnf("duplicate record: %s -> %s", entry.ThumbnailURL, file.path)
+			result.duplicate++
+			duplicateEntries = append(duplicateEntries, entry)
+		} else {
+			file.claimed = true
+		}
+	}
+
+	orphans := index.orphans()
+	for _, file := range orphans {
+		log.Warnf("orphan file: %s", file.path)
+	}
+	result.orphan = len(orphans)
+
+	if !isFix {
+		return result, nil
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// missing posts are marked as failed, so `gelbooru retry-failed` picks them up
+		for _, entry := range missingEntries {
+			if err := tx.Model(entry).Update("dl_failed", true).Error; err != nil {
+				return fmt.Errorf("failed to queue record %s: %s", entry.ThumbnailURL, err)
+			}
+			result.fixed++
+		}
+
+		for _, entry := range duplicateEntries {
+			if err := tx.Delete(entry).Error; err != nil {
+				return fmt.Errorf("failed to delete record %s: %s", entry.ThumbnailURL, err)
+			}
+			result.fixed++
+		}
+
+		for _, file := range orphans {
+			if err := registerTaggedPostFile(tx, tag.Tag, file); err != nil {
+				return err
+			}
+			result.fixed++
+		}
+
+		return nil
+	})
+
+	if len(missingEntries) > 0 && err == nil {
+		log.Infof("%s: %d missing post(s) queued, run `gelbooru retry-failed` to download them", tag.Tag, len(missingEntries))
+	}
+
+	return result, err
+}
+
+// registerTaggedPostFile restores soft deleted record of orphan file if there is
+// one, else a new record with file URL is created for it.
+func registerTaggedPostFile(tx *gorm.DB, tagName string, file *diskFile) error {
+	entry := data_model.TaggedPostEntry{}
+	err := tx.Unscoped().Limit(1).Find(&entry, "tag = ? AND file_name = ? AND deleted_at IS NOT NULL", tagName, file.key).Error
+	if err != nil {
+		return fmt.Errorf("failed to read deleted records: %s", err)
+	}
+
+	if entry.ThumbnailURL != "" {
+		if err := tx.Unscoped().Model(&entry).Update("deleted_at", nil).Error; err != nil {
+			return fmt.Errorf("failed to restore record %s: %s", entry.ThumbnailURL, err)
+		}
+		log.Infof("restore record: %s -> %s", entry.ThumbnailURL, file.path)
+
+		return nil
+	}
+
+	fileURL := makeFileURL(file.path)
+	entry = data_model.TaggedPostEntry{
+		ThumbnailURL: fileURL,
+		ContentURL:   fileURL,
+		FileName:     file.key,
+		Tag:          tagName,
+	}
+	if err := tx.Save(&entry).Error; err != nil {
+		return fmt.Errorf("failed to register %s: %s", file.path, err)
+	}
+	log.Infof("register file: %s", file.path)
+
+	return nil
+}
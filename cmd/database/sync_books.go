@@ -0,0 +1,351 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	book_mgr "github.com/SirZenith/delite/book_management"
+	"github.com/SirZenith/delite/common"
+	"github.com/SirZenith/delite/database"
+	"github.com/SirZenith/delite/database/data_model"
+	"github.com/SirZenith/delite/page_collect"
+	"github.com/charmbracelet/log"
+	"github.com/urfave/cli/v3"
+	"gorm.io/gorm"
+)
+
+// matches index in directory or file name generated by downloader, e.g.
+// `001 - Title`, `Vol.001`, `0001 - Title.html`, `Chap.0001.html`.
+var patternNameIndex = regexp.MustCompile(`^(?:(\d+) - |(?:Part|Vol|Chap)\.(\d+))`)
+
+func subCmdSyncBooks() *cli.Command {
+	var rawKeyword string
+
+	return &cli.Command{
+		Name:  "sync-books",
+		Usage: "assign ID to books in library that have none, and record books, along with volumes and chapters already downloaded, into book tables",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "library",
+				Usage: "path to library info JSON file",
+				Value: "./library.json",
+			},
+		},
+		Arguments: []cli.Argument{
+			&cli.StringArg{
+				Name:        "keyword",
+				UsageText:   "<keyword>",
+				Destination: &rawKeyword,
+				Max:         1,
+			},
+		},
+		Action: func(_ context.Context, cmd *cli.Command) error {
+			libFilePath := cmd.String("library")
+
+			cnt, err := book_mgr.AssignBookIDsInFile(libFilePath)
+			if err != nil {
+				return err
+			}
+			if cnt > 0 {
+				log.Infof("assign ID to %d book(s) in %s", cnt, libFilePath)
+			}
+
+			info, err := book_mgr.ReadLibraryInfo(libFilePath)
+			if err != nil {
+				return err
+			}
+
+			if info.DatabasePath == "" {
+				return fmt.Errorf("no database path provided by library")
+			}
+
+			db, err := database.Open(info.DatabasePath)
+			if err != nil {
+				return err
+			}
+			defer database.Close(db)
+
+			if !database.HasBookTables(db) {
+				return fmt.Errorf("no book tables in database, run `database migrate` first")
+			}
+
+			keyword := book_mgr.NewSearchKeyword(rawKeyword)
+			for i, book := range info.Books {
+				if !keyword.MatchBook(i, book) {
+					continue
+				}
+
+				if err := syncBook(db, book); err != nil {
+					log.Errorf("%s: %s", book.Title, err)
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+// syncBook saves book record, and records volumes and chapters found in raw
+// directory of book. Chapters already recorded are left untouched. URL and
+// original title of chapter are taken from file records written by older
+// downloads.
+func syncBook(db *gorm.DB, book book_mgr.BookInfo) error {
+	err := database.SaveBook(db, &data_model.Book{
+		ID:     book.ID,
+		Title:  book.Title,
+		Author: book.Author,
+		TocURL: book.TocURL,
+	})
+	if err != nil {
+		return err
+	}
+
+	if book.LocalInfo != nil {
+		return nil
+	}
+
+	volumes, err := scanVolumeDirs(book.RawDir)
+	if err != nil {
+		return err
+	}
+
+	fileEntries := []data_model.FileEntry{}
+	if err := db.Find(&fileEntries, "book = ?", book.Title).Error; err != nil {
+		return fmt.Errorf("failed to read file records: %s", err)
+	}
+
+	volumeCnt, chapterCnt := 0, 0
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, volume := range volumes {
+			cnt, err := syncVolume(tx, book, volume, fileEntries)
+			if err != nil {
+				return err
+			}
+
+			volumeCnt++
+			chapterCnt += cnt
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Infof("%s: %d volume(s), %d new chapter(s) recorded", book.Title, volumeCnt, chapterCnt)
+
+	return nil
+}
+
+// volumeDir is a volume directory found in raw directory of book.
+type volumeDir struct {
+	partIndex int
+	partName  string // name of part directory, empty if volume belongs to no part
+	volIndex  int
+	name      string
+	relPath   string // path relative to raw directory
+}
+
+// scanVolumeDirs finds all volume directories in raw directory, directories
+// without index in their name are ignored.
+func scanVolumeDirs(rawDir string) ([]volumeDir, error) {
+	entryList, err := os.ReadDir(rawDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read raw directory %s: %s", rawDir, err)
+	}
+
+	volumes := []volumeDir{}
+	for _, entry := range entryList {
+		name := entry.Name()
+		if !entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		index, ok := getNameIndex(name)
+		if !ok {
+			log.Warnf("skip directory with no index: %s", filepath.Join(rawDir, name))
+			continue
+		}
+
+		if !page_collect.IsPartDirName(name) {
+			volumes = append(volumes, volumeDir{
+				volIndex: index,
+				name:     name,
+				relPath:  name,
+			})
+			continue
+		}
+
+		childList, err := os.ReadDir(filepath.Join(rawDir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read directory %s: %s", name, err)
+		}
+
+		for _, child := range childList {
+			if !child.IsDir() {
+				continue
+			}
+
+			volIndex, ok := getNameIndex(child.Name())
+			if !ok {
+				continue
+			}
+
+			volumes = append(volumes, volumeDir{
+				partIndex: index,
+				partName:  name,
+				volIndex:  volIndex,
+				name:      child.Name(),
+				relPath:   name + "/" + child.Name(),
+			})
+		}
+	}
+
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].volIndex < volumes[j].volIndex
+	})
+
+	return volumes, nil
+}
+
+// syncVolume records volume and its chapter files, returns number of new
+// chapters recorded.
+func syncVolume(tx *gorm.DB, book book_mgr.BookInfo, dir volumeDir, fileEntries []data_model.FileEntry) (int, error) {
+	volume := data_model.Volume{
+		BookID:    book.ID,
+		PartIndex: dir.partIndex,
+		PartTitle: titleFromName(dir.partName),
+		VolIndex:  dir.volIndex,
+		Title:     titleFromName(dir.name),
+		DirPath:   dir.relPath,
+	}
+
+	volumeDirPath := filepath.Join(book.RawDir, filepath.FromSlash(dir.relPath))
+	entryList, err := os.ReadDir(volumeDirPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read volume directory %s: %s", volumeDirPath, err)
+	}
+
+	type chapterFile struct {
+		index int
+		name  string
+		entry *data_model.FileEntry
+	}
+
+	chapters := []chapterFile{}
+	hasOriginalTitle := false
+	for _, child := range entryList {
+		name := child.Name()
+		if child.IsDir() || strings.HasPrefix(name, ".") || !isChapterFileName(name) {
+			continue
+		}
+
+		index, ok := getNameIndex(name)
+		if !ok {
+			continue
+		}
+
+		chapter := chapterFile{index: index, name: name}
+
+		key := chapterKeyOf(name)
+		for i := range fileEntries {
+			entry := &fileEntries[i]
+			if common.InvalidPathCharReplace(entry.FileName) != key || !matchDirName(dir.name, entry.Volume) {
+				continue
+			}
+
+			if entry.Part == "" || matchDirName(dir.partName, entry.Part) {
+				chapter.entry = entry
+				break
+			}
+		}
+
+		// original volume title is kept in file record, while directory name
+		// has invalid path characters replaced.
+		if chapter.entry != nil && chapter.entry.Volume != "" {
+			hasOriginalTitle = true
+			volume.Title = chapter.entry.Volume
+			if chapter.entry.Part != "" {
+				volume.PartTitle = chapter.entry.Part
+			}
+		}
+
+		chapters = append(chapters, chapter)
+	}
+
+	if !hasOriginalTitle {
+		// keep titles recorded by downloader or earlier sync
+		existing := data_model.Volume{}
+		tx.Limit(1).Find(&existing, "book_id = ? AND vol_index = ?", book.ID, volume.VolIndex)
+		if existing.ID != 0 {
+			volume.Title = existing.Title
+			volume.PartTitle = existing.PartTitle
+		}
+	}
+
+	if err := database.SaveVolume(tx, &volume); err != nil {
+		return 0, err
+	}
+
+	cnt := 0
+	for _, chapter := range chapters {
+		var existing int64
+		err := tx.Model(&data_model.Chapter{}).Where("volume_id = ? AND chap_index = ?", volume.ID, chapter.index).Count(&existing).Error
+		if err != nil {
+			return cnt, fmt.Errorf("failed to read chapter record: %s", err)
+		}
+		if existing > 0 {
+			continue
+		}
+
+		fileName := filepath.Join(volumeDirPath, chapter.name)
+		hash, err := database.HashFile(fileName)
+		if err != nil {
+			return cnt, fmt.Errorf("failed to hash chapter file %s: %s", fileName, err)
+		}
+
+		record := data_model.Chapter{
+			BookID:      book.ID,
+			VolumeID:    volume.ID,
+			ChapIndex:   chapter.index,
+			Title:       chapterKeyOf(chapter.name),
+			FilePath:    dir.relPath + "/" + chapter.name,
+			ContentHash: hash,
+		}
+		if chapter.entry != nil {
+			record.Title = chapter.entry.FileName
+			record.URL = chapter.entry.URL
+		}
+
+		if err := database.SaveChapter(tx, &record); err != nil {
+			return cnt, err
+		}
+		cnt++
+	}
+
+	return cnt, nil
+}
+
+// getNameIndex returns index number in directory or file name generated by
+// downloader.
+func getNameIndex(name string) (int, bool) {
+	match := patternNameIndex.FindStringSubmatch(name)
+	if match == nil {
+		return 0, false
+	}
+
+	index, err := strconv.Atoi(match[1] + match[2])
+	if err != nil {
+		return 0, false
+	}
+
+	return index, true
+}
@@ -1,7 +1,6 @@
 package gelbooru
 
 import (
-	"bufio"
 	"context"
 	"fmt"
 	urlmod "net/url"
@@ -67,6 +66,8 @@ func Cmd() *cli.Command {
 }
 
 type options struct {
+	ctx context.Context // download stops waiting for retry once it gets canceled
+
 	proxyURL string
 	jobCnt   int
 	retryCnt int
@@ -166,10 +167,12 @@ func subCmdDownloadTag() *cli.Command {
 				Max:         1,
 			},
 		},
-		Action: func(_ context.Context, cmd *cli.Command) error {
+		Action: func(ctx context.Context, cmd *cli.Command) error {
 			isUpdate := cmd.Bool("update")
 
 			options := options{
+				ctx: ctx,
+
 				proxyURL: cmd.String("proxy"),
 				jobCnt:   int(cmd.Int("job")),
 				retryCnt: int(cmd.Int("retry")),
@@ -301,10 +304,12 @@ func subCmdDownloadLib() *cli.Command {
 				Max:         1,
 			},
 		},
-		Action: func(_ context.Context, cmd *cli.Command) error {
+		Action: func(ctx context.Context, cmd *cli.Command) error {
 			isUpdate := cmd.Bool("update")
 
 			options := options{
+				ctx: ctx,
+
 				proxyURL: cmd.String("proxy"),
 				retryCnt: int(cmd.Int("retry")),
 				timeout:  cmd.Duration("timeout"),
@@ -414,10 +419,12 @@ func subCmdRetryFailed() *cli.Command {
 				Max:         1,
 			},
 		},
-		Action: func(_ context.Context, cmd *cli.Command) error {
+		Action: func(ctx context.Context, cmd *cli.Command) error {
 			isUpdate := cmd.Bool("update")
 
 			options := options{
+				ctx: ctx,
+
 				proxyURL: cmd.String("proxy"),
 				retryCnt: int(cmd.Int("retry")),
 				timeout:  cmd.Duration("timeout"),
@@ -480,10 +487,13 @@ func downloadPosts(target tagInfo) error {
 		return fmt.Errorf("failed to crate output directory %s: %s", target.outputDir, err)
 	}
 
-	collector, _ := makeCollector(&target)
+	collector, _, err := makeCollector(&target)
+	if err != nil {
+		return fmt.Errorf("failed to create collector: %s", err)
+	}
 	setupCollectorCallback(collector)
 
-	err := visitPostPage(collector, target.tagName, target.fromPage, target.options.retryCnt)
+	err = visitPostPage(collector, target.tagName, target.fromPage, target.options.retryCnt)
 	if err != nil {
 		return fmt.Errorf("can't start collecting: %s", err)
 	}
@@ -540,16 +550,7 @@ func setPageNumDescription(bar *progressbar.ProgressBar, pageNum int) {
 	bar.Describe(fmt.Sprintf("page: %d |", pageNum))
 }
 
-func makeCollector(target *tagInfo) (*colly.Collector, *ctxGlobal) {
-	c := colly.NewCollector(
-		colly.Async(true),
-	)
-	c.SetRequestTimeout(target.options.timeout)
-
-	if len(target.options.limitRule) > 0 {
-		c.Limits(target.options.limitRule)
-	}
-
+func makeCollector(target *tagInfo) (*colly.Collector, *ctxGlobal, error) {
 	bar := progressbar.NewOptions64(
 		0,
 		progressbar.OptionSetWriter(os.Stderr),
@@ -562,6 +563,20 @@ func makeCollector(target *tagInfo) (*colly.Collector, *ctxGlobal) {
 		progressbar.OptionSetRenderBlankState(true),
 	)
 
+	c, err := network.NewCollector(network.ClientOptions{
+		HTTPProxy:  target.options.proxyURL,
+		HTTPSProxy: target.options.proxyURL,
+		Timeout:    target.options.timeout,
+		LimitRules: target.options.limitRule,
+		Async:      true,
+		ErrorLogger: func(msg string) {
+			bar.Describe(msg + "\n")
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
 	global := &ctxGlobal{
 		collector: c,
 		target:    target,
@@ -571,29 +586,8 @@ func makeCollector(target *tagInfo) (*colly.Collector, *ctxGlobal) {
 	c.OnRequest(func(r *colly.Request) {
 		r.Ctx.Put("global", global)
 	})
-	c.OnResponse(func(r *colly.Response) {
-		if data, err := network.DecompressResponseBody(r); err == nil {
-			r.Body = data
-		} else {
-			bar.Describe(err.Error() + "\n")
-		}
-
-		ctx := r.Ctx
-
-		if onResponse, ok := ctx.GetAny("onResponse").(colly.ResponseCallback); ok {
-			onResponse(r)
-		}
-	})
-	c.OnError(func(r *colly.Response, err error) {
-		ctx := r.Ctx
-		if onError, ok := ctx.GetAny("onError").(colly.ErrorCallback); ok {
-			onError(r, err)
-		} else {
-			bar.Describe(fmt.Sprintf("error requesting %s: %s\n", r.Request.URL, err))
-		}
-	})
 
-	return c, global
+	return c, global, nil
 }
 
 // setupCollectorCallback registers callbacks for collecting web page elements.
@@ -633,7 +627,14 @@ func visitPostPage(collector *colly.Collector, tagName string, pageNum, retryCnt
 			return
 		}
 
+		global := resp.Ctx.GetAny("global").(*ctxGlobal)
+
 		resp.Ctx.Put("leftRetryCnt", leftRetryCnt-1)
+		if network.Backoff(global.target.options.ctx, retryCnt-leftRetryCnt+1, network.DefaultRetryDelay) != nil {
+			log.Warnf("failed to advance post at page %d: download canceled", atPage)
+			return
+		}
+
 		if err = resp.Request.Retry(); err != nil {
 			log.Warnf("failed to advance post at page %d", atPage)
 		}
@@ -872,11 +873,13 @@ func sendImageDownloadRequest(r *colly.Response) {
 		return
 	}
 
+	// entry stays marked as failed until image file is completely written.
 	entry := &data_model.TaggedPostEntry{
 		ThumbnailURL: thumbnailURL,
 		ContentURL:   contentUrl,
 		FileName:     basename,
 		Tag:          ctx.Get("tagName"),
+		DlFailed:     true,
 	}
 	global.target.db.Save(entry)
 
@@ -931,6 +934,12 @@ func makeImageDownloadContext(parentCtx *colly.Context, global *ctxGlobal, pageN
 		}
 
 		resp.Ctx.Put("leftRetryCnt", leftRetryCnt-1)
+		if err := network.Backoff(global.target.options.ctx, global.target.options.retryCnt-leftRetryCnt+1, network.DefaultRetryDelay); err != nil {
+			bar.Describe(fmt.Sprintf("failed to retry %s:\n\t%s\n", contentUrl, err))
+			onFinished(false)
+			return
+		}
+
 		if err = resp.Request.Retry(); err != nil {
 			bar.Describe(fmt.Sprintf("failed to retry %s:\n\t%s\n", contentUrl, err))
 			onFinished(false)
@@ -946,21 +955,7 @@ func saveImageDownloadResult(data []byte, outputName string) error {
 		return common.SaveImageAs(data, outputName, imageOutputFormat)
 	}
 
-	file, err := os.Create(outputName)
-	if err != nil {
-		return fmt.Errorf("failed to create output file %s: %s", outputName, err)
-	}
-	defer file.Close()
-
-	writer := bufio.NewWriter(file)
-	defer writer.Flush()
-
-	_, err = writer.Write(data)
-	if err != nil {
-		return fmt.Errorf("failed to write data to file: %s", err)
-	}
-
-	return nil
+	return common.WriteBytesAtomic(outputName, data, 0o644)
 }
 
 func tagMigrantDummyImageDownload(r *colly.Response) {
@@ -1066,11 +1061,14 @@ func retryAllFailedDownloadForTarget(target tagInfo) error {
 		return err
 	}
 
+	collector, ctxGlobal, err := makeCollector(&target)
+	if err != nil {
+		return fmt.Errorf("failed to create collector: %s", err)
+	}
+
 	taskChan := make(chan retryTask, 100)
 	go findAllFailedDownloads(target, taskChan)
 
-	collector, ctxGlobal := makeCollector(&target)
-
 	bar := ctxGlobal.bar
 	onTaskFinished := func(_ok bool) {
 		bar.Add(1)
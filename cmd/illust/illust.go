@@ -11,6 +11,7 @@ func Cmd() *cli.Command {
 		Usage: "dealing with illustration in novels",
 		Commands: []*cli.Command{
 			download.Cmd(),
+			download.VerifyCmd(),
 		},
 	}
 
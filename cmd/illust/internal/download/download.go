@@ -60,11 +60,12 @@ func Cmd() *cli.Command {
 				Max:         1,
 			},
 		},
-		Action: func(_ context.Context, cmd *cli.Command) error {
+		Action: func(ctx context.Context, cmd *cli.Command) error {
 			options, targets, err := getOptionsFromCmd(cmd, rawKeyword)
 			if err != nil {
 				return err
 			}
+			options.ctx = ctx
 
 			return cmdMain(options, targets)
 		},
@@ -74,13 +75,13 @@ func Cmd() *cli.Command {
 }
 
 type options struct {
+	ctx context.Context // pending retries are given up once it gets canceled
+
 	timeout    time.Duration
 	retry      int
 	limitRules []*colly.LimitRule
 }
 
-type headerMaker func(hostname string) http.Header
-
 type outputNameMaker func(ctx context.Context, srcURL *url.URL, pageIndex int, format string) string
 
 type target struct {
@@ -103,7 +104,7 @@ type workload struct {
 }
 
 type hostInfo struct {
-	headerMaker        headerMaker
+	header             *network.HeaderTemplate
 	imageFormat        string
 	imageBasenameMaker outputNameMaker
 }
@@ -187,7 +188,7 @@ func cmdMain(options options, targets []target) error {
 			continue
 		}
 
-		ctx := context.WithValue(context.Background(), "maxRetryCnt", options.retry)
+		ctx := context.WithValue(options.ctx, "maxRetryCnt", options.retry)
 
 		handlingBook(ctx, target, collector)
 	}
@@ -219,88 +220,66 @@ func initTocHostInfoMap() {
 		tocHostInfoMap = map[string]hostInfo{
 			"bilinovel.com": {
 				imageFormat: common.ImageFormatPng,
-				headerMaker: makeCopyHeaderMaker(map[string][]string{
-					"Referer": {"https://www.bilinovel.com"},
-				}),
+				header: network.NewHeaderTemplate([]network.HeaderValue{
+					{Name: "Referer", Value: "https://www.bilinovel.com"},
+				}, nil),
 				imageBasenameMaker: getSrcURLBasename,
 			},
 			"linovelib.com": {
 				imageFormat: common.ImageFormatPng,
-				headerMaker: makeCopyHeaderMaker(map[string][]string{
-					"Referer": {"https://www.linovelib.com/"},
-				}),
+				header: network.NewHeaderTemplate([]network.HeaderValue{
+					{Name: "Referer", Value: "https://www.linovelib.com/"},
+				}, nil),
 				imageBasenameMaker: getSrcURLBasename,
 			},
 			"syosetu.com": {
 				imageFormat: common.ImageFormatPng,
-				headerMaker: makeCopyHeaderMaker(map[string][]string{
-					"Referer": {"https://ncode.syosetu.com/"},
-				}),
+				header: network.NewHeaderTemplate([]network.HeaderValue{
+					{Name: "Referer", Value: "https://ncode.syosetu.com/"},
+				}, nil),
 				imageBasenameMaker: getSrcURLBasename,
 			},
 			"bilimanga.net": {
-				imageFormat: common.ImageFormatAvif,
-				headerMaker: func(hostname string) http.Header {
-					return map[string][]string{
-						"Accept":          {"image/avif,image/webp,image/png,image/svg+xml,image/*;q=0.8,*/*;q=0.5"},
-						"Accept-Encoding": {"deflate, br, zstd"},
-						"Accept-Language": {"zh-CN,zh;q=0.8,zh-TW;q=0.7,zh-HK;q=0.5,en-US;q=0.3,en;q=0.2"},
-						"Connection":      {"keep-alive"},
-						"Host":            {hostname},
-						"Priority":        {"u=5, i"},
-						"Referer":         {"https://www.bilimanga.net/"},
-						"Sec-Fetch-Dest":  {"image"},
-						"Sec-Fetch-Mode":  {"no-cors"},
-						"Sec-Fetch-Site":  {"cross-site"},
-					}
-				},
+				imageFormat:        common.ImageFormatAvif,
+				header:             makeMangaImageHeader("https://www.bilimanga.net/", true),
 				imageBasenameMaker: getMangaBasename,
 			},
 			"bilicomic.net": {
-				imageFormat: common.ImageFormatAvif,
-				headerMaker: func(hostname string) http.Header {
-					return map[string][]string{
-						"Accept":          {"image/avif,image/webp,image/png,image/svg+xml,image/*;q=0.8,*/*;q=0.5"},
-						"Accept-Encoding": {"deflate, br, zstd"},
-						"Accept-Language": {"zh-CN,zh;q=0.8,zh-TW;q=0.7,zh-HK;q=0.5,en-US;q=0.3,en;q=0.2"},
-						"Connection":      {"keep-alive"},
-						"Host":            {hostname},
-						"Priority":        {"u=5, i"},
-						"Referer":         {"https://www.bilicomic.net/"},
-						"Sec-Fetch-Dest":  {"image"},
-						"Sec-Fetch-Mode":  {"no-cors"},
-						"Sec-Fetch-Site":  {"cross-site"},
-					}
-				},
+				imageFormat:        common.ImageFormatAvif,
+				header:             makeMangaImageHeader("https://www.bilicomic.net/", true),
 				imageBasenameMaker: getMangaBasename,
 			},
 			"senmanga.com": {
-				imageFormat: common.ImageFormatAvif,
-				headerMaker: func(hostname string) http.Header {
-					return map[string][]string{
-						"Accept":          {"image/avif,image/webp,image/png,image/svg+xml,image/*;q=0.8,*/*;q=0.5"},
-						"Accept-Encoding": {"deflate, br, zstd"},
-						"Connection":      {"keep-alive"},
-						"Host":            {hostname},
-						"Priority":        {"u=5, i"},
-						"Referer":         {"https://raw.senmanga.com/"},
-					}
-				},
+				imageFormat:        common.ImageFormatAvif,
+				header:             makeMangaImageHeader("https://raw.senmanga.com/", false),
 				imageBasenameMaker: getMangaBasename,
 			},
 		}
 	})
 }
 
-func makeCopyHeaderMaker(header http.Header) headerMaker {
-	return func(_ string) http.Header {
-		result := http.Header(map[string][]string{})
-		for k, v := range header {
-			result[k] = v
-		}
+// makeMangaImageHeader returns header template for requesting manga image
+// hosted on CDN, `referer` is site page that refers to image.
+func makeMangaImageHeader(referer string, isCrossSite bool) *network.HeaderTemplate {
+	headers := []network.HeaderValue{
+		{Name: "Accept", Value: "image/avif,image/webp,image/png,image/svg+xml,image/*;q=0.8,*/*;q=0.5"},
+		{Name: "Accept-Encoding", Value: "deflate, br, zstd"},
+		{Name: "Connection", Value: "keep-alive"},
+		{Name: "Host", Value: "{{hostname}}"},
+		{Name: "Priority", Value: "u=5, i"},
+		{Name: "Referer", Value: referer},
+	}
 
-		return result
+	if isCrossSite {
+		headers = append(headers,
+			network.HeaderValue{Name: "Accept-Language", Value: "zh-CN,zh;q=0.8,zh-TW;q=0.7,zh-HK;q=0.5,en-US;q=0.3,en;q=0.2"},
+			network.HeaderValue{Name: "Sec-Fetch-Dest", Value: "image"},
+			network.HeaderValue{Name: "Sec-Fetch-Mode", Value: "no-cors"},
+			network.HeaderValue{Name: "Sec-Fetch-Site", Value: "cross-site"},
+		)
 	}
+
+	return network.NewHeaderTemplate(headers, nil)
 }
 
 func getSrcURLBasename(_ context.Context, srcURL *url.URL, pageIndex int, format string) string {
@@ -329,14 +308,9 @@ func getHostInfo(hostname string) *hostInfo {
 
 // Returns collector used for novel downloading.
 func makeCollector(options options) (*colly.Collector, error) {
-	c := colly.NewCollector(
-		colly.Async(true),
-	)
-
-	if len(options.limitRules) > 0 {
-		c.Limits(options.limitRules)
-	} else {
-		c.Limits([]*colly.LimitRule{
+	limitRules := options.limitRules
+	if len(limitRules) <= 0 {
+		limitRules = []*colly.LimitRule{
 			{
 				DomainGlob:  "img3.readpai.com",
 				Parallelism: 3,
@@ -349,32 +323,14 @@ func makeCollector(options options) (*colly.Collector, error) {
 				DomainGlob:  "*.kumacdn.club",
 				Parallelism: 5,
 			},
-		})
-	}
-
-	// c.OnRequest(func(r *colly.Request) { })
-	c.OnResponse(func(r *colly.Response) {
-		if data, err := network.DecompressResponseBody(r); err == nil {
-			r.Body = data
-		} else {
-			log.Error(err)
-		}
-
-		if onResponse, ok := r.Ctx.GetAny("onResponse").(colly.ResponseCallback); ok {
-			onResponse(r)
 		}
-	})
-	c.OnError(func(r *colly.Response, err error) {
-		ctx := r.Ctx
+	}
 
-		if onError, ok := ctx.GetAny("onError").(colly.ErrorCallback); ok {
-			onError(r, err)
-		} else {
-			log.Errorf("error requesting %s: %s", r.Request.URL, err)
-		}
+	return network.NewCollector(network.ClientOptions{
+		Timeout:    options.timeout,
+		LimitRules: limitRules,
+		Async:      true,
 	})
-
-	return c, nil
 }
 
 func handlingBook(ctx context.Context, target target, collector *colly.Collector) error {
@@ -511,21 +467,13 @@ func handlingRawTextFile(ctx context.Context, volumeName, basename string) (map[
 		dlContext.Put("volumeName", volumeName)
 		dlContext.Put("db", db)
 		dlContext.Put("maxRetryCnt", maxRetryCnt)
+		dlContext.Put("ctx", ctx)
 		dlContext.Put("onResponse", colly.ResponseCallback(saveResponseAsImage))
-		dlContext.Put("onError", colly.ErrorCallback(func(resp *colly.Response, err error) {
-			retryCnt, retryErr := network.RetryRequest(resp.Request)
-			if retryErr == nil {
-				log.Warnf("retry(%d) %s: %s", retryCnt, resp.Request.URL, err)
-			} else if errors.Is(retryErr, network.ErrMaxRetry) {
-				log.Errorf("request failed after %d time(s) of retry %s: %s", retryCnt, resp.Request.URL, err)
-			} else {
-				log.Errorf("failed to retry request %s: %s", resp.Request.URL, retryErr)
-			}
-		}))
+		dlContext.Put("onError", colly.ErrorCallback(retryImageRequest))
 
 		var header http.Header
-		if hostInfo.headerMaker != nil {
-			header = hostInfo.headerMaker(parsedSrc.Hostname())
+		if hostInfo.header != nil {
+			header = hostInfo.header.Render(parsedSrc)
 		}
 
 		collector.Request("GET", src, nil, dlContext, header)
@@ -547,19 +495,23 @@ func checkShouldSkipImage(db *gorm.DB, url, basename, outputName string) bool {
 	return false
 }
 
-func saveResponseAsImage(resp *colly.Response) {
-	ctx := resp.Ctx
-
-	data, err := network.DecompressResponseBody(resp)
-	if err != nil {
-		if retryCnt, err := network.RetryRequest(resp.Request); err == nil {
-			// pass
-		} else if errors.Is(err, network.ErrMaxRetry) {
-			log.Errorf("failed to decode response body after %d time(s) of retry %s: %s", retryCnt, resp.Request.URL, err)
-		}
+// retryImageRequest is error callback for image request, failed request gets
+// retried until max retry count in request context is reached.
+func retryImageRequest(resp *colly.Response, err error) {
+	ctx, _ := resp.Ctx.GetAny("ctx").(context.Context)
 
-		return
+	retryCnt, retryErr := network.RetryRequest(ctx, resp.Request)
+	if retryErr == nil {
+		log.Warnf("retry(%d) %s: %s", retryCnt, resp.Request.URL, err)
+	} else if errors.Is(retryErr, network.ErrMaxRetry) {
+		log.Errorf("request failed after %d time(s) of retry %s: %s", retryCnt, resp.Request.URL, err)
+	} else {
+		log.Errorf("failed to retry request %s: %s", resp.Request.URL, retryErr)
 	}
+}
+
+func saveResponseAsImage(resp *colly.Response) {
+	ctx := resp.Ctx
 
 	outputName := ctx.Get("outputName")
 	if outputName == "" {
@@ -573,8 +525,12 @@ func saveResponseAsImage(resp *colly.Response) {
 		outputFormat = common.ImageFormatPng
 	}
 
-	err = common.SaveImageAs(data, outputName, outputFormat)
-	if err != nil {
+	goCtx, _ := ctx.GetAny("ctx").(context.Context)
+
+	err := network.SaveImageResponse(goCtx, resp, outputName, outputFormat)
+	if errors.Is(err, network.ErrRetrying) {
+		return
+	} else if err != nil {
 		log.Warnf("failed to save image with format %s, %s: %s", outputFormat, outputName, err)
 		return
 	}
@@ -583,6 +539,7 @@ func saveResponseAsImage(resp *colly.Response) {
 		entry := data_model.FileEntry{
 			URL:      resp.Request.URL.String(),
 			Book:     ctx.Get("bookName"),
+			Part:     ctx.Get("partName"),
 			Volume:   ctx.Get("volumeName"),
 			FileName: filepath.Base(outputName),
 		}
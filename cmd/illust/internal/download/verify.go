@@ -0,0 +1,266 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/SirZenith/delite/common"
+	"github.com/SirZenith/delite/database"
+	"github.com/SirZenith/delite/database/data_model"
+	"github.com/charmbracelet/log"
+	"github.com/gocolly/colly/v2"
+	"github.com/urfave/cli/v3"
+	"gorm.io/gorm"
+)
+
+// Maps image file extension to image format used for re-downloading.
+var verifyImageExtMap = map[string]string{
+	".avif": common.ImageFormatAvif,
+	".bmp":  common.ImageFormatBmp,
+	".gif":  common.ImageFormatGif,
+	".jpeg": common.ImageFormatJpeg,
+	".jpg":  common.ImageFormatJpeg,
+	".png":  common.ImageFormatPng,
+	".tif":  common.ImageFormatTiff,
+	".tiff": common.ImageFormatTiff,
+	".webp": common.ImageFormatWebp,
+}
+
+func VerifyCmd() *cli.Command {
+	var rawKeyword string
+
+	cmd := &cli.Command{
+		Name:  "verify",
+		Usage: "scan image directories of books for broken image files, and re-download them with URL recorded in database",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "only report broken image files",
+			},
+			&cli.StringFlag{
+				Name:  "library",
+				Usage: "path to library info JSON file",
+				Value: "./library.json",
+			},
+			&cli.IntFlag{
+				Name:  "retry",
+				Usage: "retry count for image download request",
+				Value: defaultRetryCnt,
+			},
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "request timeout for image in milisecond",
+				Value: -1,
+			},
+		},
+		Arguments: []cli.Argument{
+			&cli.StringArg{
+				Name:        "book-keyword",
+				UsageText:   " <keyword>",
+				Destination: &rawKeyword,
+				Max:         1,
+			},
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			options, targets, err := getOptionsFromCmd(cmd, rawKeyword)
+			if err != nil {
+				return err
+			}
+			options.ctx = ctx
+
+			return verifyMain(options, targets, cmd.Bool("dry-run"))
+		},
+	}
+
+	return cmd
+}
+
+// brokenImage is an image file that fails to decode.
+type brokenImage struct {
+	fileName string // path to image file
+	relDir   string // directory of image file relative to image directory of book
+	err      error
+}
+
+func verifyMain(options options, targets []target, dryRun bool) error {
+	if len(targets) <= 0 {
+		return fmt.Errorf("no target book found")
+	}
+
+	var collector *colly.Collector
+	if !dryRun {
+		var err error
+		collector, err = makeCollector(options)
+		if err != nil {
+			return fmt.Errorf("failed to create collector: %s", err)
+		}
+	}
+
+	dbList := []*gorm.DB{}
+	totalCnt := 0
+	for _, target := range targets {
+		if target.imageDir == "" {
+			continue
+		}
+
+		brokenList := findBrokenImages(target.imageDir)
+		if len(brokenList) == 0 {
+			continue
+		}
+
+		totalCnt += len(brokenList)
+		log.Warnf("%d broken image(s) found in %s", len(brokenList), target.title)
+		for _, broken := range brokenList {
+			log.Warnf("    %s: %s", broken.fileName, broken.err)
+		}
+
+		if dryRun {
+			continue
+		}
+
+		db, err := requeueBrokenImages(options, target, collector, brokenList)
+		if err != nil {
+			log.Errorf("%s", err)
+		} else {
+			dbList = append(dbList, db)
+		}
+	}
+
+	if collector != nil {
+		collector.Wait()
+	}
+
+	for _, db := range dbList {
+		if err := database.Close(db); err != nil {
+			log.Warnf("%s", err)
+		}
+	}
+
+	log.Infof("%d broken image(s) found in total", totalCnt)
+
+	return nil
+}
+
+// findBrokenImages walks through image directory and returns all image files
+// that can not be decoded.
+func findBrokenImages(imageDir string) []brokenImage {
+	brokenList := []brokenImage{}
+
+	filepath.WalkDir(imageDir, func(fileName string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Warnf("failed to access %s: %s", fileName, err)
+			return nil
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		ext := strings.ToLower(filepath.Ext(fileName))
+		if _, ok := verifyImageExtMap[ext]; !ok {
+			return nil
+		}
+
+		if err := common.VerifyImageFile(fileName); err != nil {
+			relDir, _ := filepath.Rel(imageDir, filepath.Dir(fileName))
+			brokenList = append(brokenList, brokenImage{
+				fileName: fileName,
+				relDir:   relDir,
+				err:      err,
+			})
+		}
+
+		return nil
+	})
+
+	return brokenList
+}
+
+// requeueBrokenImages looks up download record of broken images in database,
+// and sends download request for each of them. Returned database handle is
+// used by download callbacks, and should be closed after collector finishes.
+func requeueBrokenImages(options options, target target, collector *colly.Collector, brokenList []brokenImage) (*gorm.DB, error) {
+	if target.dbPath == "" {
+		return nil, fmt.Errorf("no database provided for %s, can't find image URL", target.title)
+	}
+
+	db, err := database.Open(target.dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open book database %s: %s", target.dbPath, err)
+	}
+
+	var info *hostInfo
+	if parsedURL, err := url.Parse(target.targetURL); err == nil {
+		info = getHostInfo(parsedURL.Hostname())
+	}
+
+	for _, broken := range brokenList {
+		entry := findImageEntry(db, target.title, broken)
+		if entry == nil {
+			log.Warnf("no download record found for %s", broken.fileName)
+			continue
+		}
+
+		ext := strings.ToLower(filepath.Ext(broken.fileName))
+
+		dlContext := colly.NewContext()
+		dlContext.Put("outputName", broken.fileName)
+		dlContext.Put("outputFormat", verifyImageExtMap[ext])
+		dlContext.Put("bookName", entry.Book)
+		dlContext.Put("partName", entry.Part)
+		dlContext.Put("volumeName", entry.Volume)
+		dlContext.Put("db", db)
+		dlContext.Put("maxRetryCnt", options.retry)
+		dlContext.Put("ctx", options.ctx)
+		dlContext.Put("onResponse", colly.ResponseCallback(saveResponseAsImage))
+		dlContext.Put("onError", colly.ErrorCallback(retryImageRequest))
+
+		var header http.Header
+		if info != nil && info.header != nil {
+			if parsedSrc, err := url.Parse(entry.URL); err == nil {
+				header = info.header.Render(parsedSrc)
+			}
+		}
+
+		log.Infof("re-download: %s", entry.URL)
+		if err := collector.Request("GET", entry.URL, nil, dlContext, header); err != nil {
+			log.Warnf("failed to request %s: %s", entry.URL, err)
+		}
+	}
+
+	return db, nil
+}
+
+// findImageEntry returns download record of broken image. When multiple records
+// share the same file name, the one whose volume title appears in directory
+// path of image is chosen.
+func findImageEntry(db *gorm.DB, book string, broken brokenImage) *data_model.FileEntry {
+	entries := []data_model.FileEntry{}
+	db.Find(&entries, "book = ? AND file_name = ?", book, filepath.Base(broken.fileName))
+
+	switch len(entries) {
+	case 0:
+		return nil
+	case 1:
+		return &entries[0]
+	}
+
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Volume == "" {
+			continue
+		}
+
+		volume := common.InvalidPathCharReplace(entry.Volume)
+		if strings.Contains(broken.relDir, volume) {
+			return entry
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,334 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	book_mgr "github.com/SirZenith/delite/book_management"
+	"github.com/SirZenith/delite/page_collect"
+	"github.com/charmbracelet/log"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	lintLevelError   = "error"
+	lintLevelWarning = "warning"
+)
+
+func subCmdCheck() *cli.Command {
+	return &cli.Command{
+		Name:  "check",
+		Usage: "validate library.json and book directories, exits with non-zero code when any error is found",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "library",
+				Usage: "path of library.json file to be checked",
+				Value: "./library.json",
+			},
+			&cli.BoolFlag{
+				Name:  "strict",
+				Usage: "treat warnings as errors",
+			},
+		},
+		Action: func(_ context.Context, cmd *cli.Command) error {
+			filePath := cmd.String("library")
+
+			info, err := book_mgr.ReadLibraryInfo(filePath)
+			if err != nil {
+				fmt.Printf("%s: %s: %s\n", filePath, lintLevelError, err)
+				return fmt.Errorf("library check failed")
+			}
+
+			checker := &libraryChecker{info: info}
+			checker.checkHeaderFiles()
+			checker.checkLimitRules()
+			checker.checkBooks()
+
+			errCnt, warnCnt := 0, 0
+			for _, problem := range checker.problems {
+				fmt.Printf("%s: %s: %s: %s\n", filePath, problem.level, problem.subject, problem.message)
+
+				if problem.level == lintLevelError {
+					errCnt++
+				} else {
+					warnCnt++
+				}
+			}
+
+			if errCnt > 0 || (warnCnt > 0 && cmd.Bool("strict")) {
+				return fmt.Errorf("%d error(s), %d warning(s) found", errCnt, warnCnt)
+			}
+
+			log.Infof("%d error(s), %d warning(s) found", errCnt, warnCnt)
+
+			return nil
+		},
+	}
+}
+
+// lintProblem is a problem found in library.
+type lintProblem struct {
+	level   string
+	subject string // part of library the problem is found in
+	message string
+}
+
+// libraryChecker collects problems in library info and book directories.
+type libraryChecker struct {
+	info     *book_mgr.LibraryInfo
+	problems []lintProblem
+}
+
+func (c *libraryChecker) addError(subject, format string, args ...any) {
+	c.problems = append(c.problems, lintProblem{
+		level:   lintLevelError,
+		subject: subject,
+		message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (c *libraryChecker) addWarning(subject, format string, args ...any) {
+	c.problems = append(c.problems, lintProblem{
+		level:   lintLevelWarning,
+		subject: subject,
+		message: fmt.Sprintf(format, args...),
+	})
+}
+
+// checkHeaderFiles checks header file patterns and existence of header files
+// provided by library.
+func (c *libraryChecker) checkHeaderFiles() {
+	for i, entry := range c.info.HeaderFileList {
+		subject := fmt.Sprintf("header file %d (%s)", i+1, entry.Pattern)
+
+		if _, err := path.Match(entry.Pattern, ""); err != nil {
+			c.addError(subject, "invalid domain pattern: %s", err)
+		}
+
+		if entry.Path == "" {
+			c.addError(subject, "no header file path provided")
+		} else if _, err := os.Stat(entry.Path); err != nil {
+			c.addError(subject, "header file not found: %s", entry.Path)
+		}
+	}
+}
+
+// checkLimitRules checks if limit rules can be used by collector.
+func (c *libraryChecker) checkLimitRules() {
+	for i, rule := range c.info.LimitRules {
+		subject := fmt.Sprintf("limit rule %d", i+1)
+
+		if err := rule.ToCollyLimitRule().Init(); err != nil {
+			c.addError(subject, "%s", err)
+		}
+
+		if rule.Delay < 0 || rule.RandomDelay < 0 {
+			c.addError(subject, "delay must not be negative")
+		}
+
+		if rule.Parallelism < 0 {
+			c.addError(subject, "parallelism must not be negative")
+		}
+	}
+}
+
+// bookDir is an output directory of book.
+type bookDir struct {
+	kind string // usage of directory, e.g. raw, text
+	path string
+}
+
+// dirOwner is the book directory that first takes a path.
+type dirOwner struct {
+	bookIndex int
+	kind      string
+}
+
+// checkBooks checks every book entry and its directories.
+func (c *libraryChecker) checkBooks() {
+	headerFiles := map[string]bool{}
+	for _, entry := range c.info.HeaderFileList {
+		headerFiles[entry.Path] = true
+	}
+
+	titleSet := map[string]int{}
+	tocURLSet := map[string]int{}
+	dirSet := map[string]dirOwner{}
+	collided := map[[2]int]bool{}
+
+	for i, book := range c.info.Books {
+		subject := fmt.Sprintf("book %d (%s)", i+1, book.Title)
+
+		if index, ok := titleSet[book.Title]; ok {
+			c.addError(subject, "duplicate title, same as book %d", index+1)
+		} else {
+			titleSet[book.Title] = i
+		}
+
+		if book.TocURL != "" {
+			if index, ok := tocURLSet[book.TocURL]; ok {
+				c.addError(subject, "duplicate TOC URL, same as book %d", index+1)
+			} else {
+				tocURLSet[book.TocURL] = i
+			}
+		}
+
+		isTakenDown := book.Meta != nil && book.Meta.IsTakenDown
+		if book.LocalInfo != nil {
+			if !slices.Contains(book_mgr.AllLocalBookType, book.LocalInfo.Type) {
+				c.addError(subject, "unknown local book type %q, available types are: %s", book.LocalInfo.Type, strings.Join(book_mgr.AllLocalBookType, ", "))
+			}
+		} else if book.TocURL == "" {
+			c.addWarning(subject, "no TOC URL provided, book can't be downloaded")
+		} else if !isTakenDown {
+			if err := book_mgr.CheckTocURL(book.TocURL); err != nil {
+				c.addError(subject, "%s", err)
+			}
+		}
+
+		if book.HeaderFile != "" && !headerFiles[book.HeaderFile] {
+			if _, err := os.Stat(book.HeaderFile); err != nil {
+				c.addError(subject, "header file not found: %s", book.HeaderFile)
+			}
+		}
+
+		for _, dir := range getBookDirs(book) {
+			absPath, err := filepath.Abs(dir.path)
+			if err != nil {
+				absPath = dir.path
+			}
+
+			owner, ok := dirSet[absPath]
+			if !ok {
+				dirSet[absPath] = dirOwner{bookIndex: i, kind: dir.kind}
+				continue
+			}
+
+			// reports only the first collided directory between two books
+			pair := [2]int{owner.bookIndex, i}
+			if collided[pair] {
+				continue
+			}
+			collided[pair] = true
+
+			if owner.bookIndex == i {
+				c.addError(subject, "%s directory is the same as its %s directory: %s", dir.kind, owner.kind, dir.path)
+			} else {
+				c.addError(subject, "%s directory collides with %s directory of book %d: %s", dir.kind, owner.kind, owner.bookIndex+1, dir.path)
+			}
+		}
+
+		if book.LocalInfo == nil {
+			c.checkStaleText(subject, book)
+		}
+	}
+}
+
+// getBookDirs returns all output directories of book along with their kind.
+// Directories left unset in both book and library info are skipped.
+func getBookDirs(book book_mgr.BookInfo) []bookDir {
+	dirs := []bookDir{
+		{"raw", book.RawDir},
+		{"text", book.TextDir},
+		{"image", book.ImgDir},
+		{"epub", book.EpubDir},
+		{"latex", book.LatexDir},
+		{"markdown", book.MarkdownDir},
+		{"pdf", book.PdfDir},
+		{"typst", book.TypstDir},
+		{"zip", book.ZipDir},
+	}
+
+	return slices.DeleteFunc(dirs, func(dir bookDir) bool {
+		return dir.path == ""
+	})
+}
+
+// checkStaleText reports volumes in raw directory of book, whose chapter files
+// are not decyphered into text directory yet, or are modified after last
+// decypher. Chapters failed to download are reported separately.
+func (c *libraryChecker) checkStaleText(subject string, book book_mgr.BookInfo) {
+	if _, err := os.Stat(book.RawDir); err != nil {
+		return
+	}
+
+	total := map[string]int{}
+	stale := map[string]int{}
+	failed := map[string]int{}
+	err := filepath.WalkDir(book.RawDir, func(fileName string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() && fileName != book.RawDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(book.RawDir, fileName)
+		if err != nil {
+			return err
+		}
+
+		// files at top level are not part of any volume
+		volume, _, found := strings.Cut(filepath.ToSlash(relPath), "/")
+		if !found {
+			return nil
+		}
+
+		if page_collect.IsFailedMarkName(d.Name()) {
+			failed[volume]++
+			return nil
+		}
+
+		total[volume]++
+
+		rawInfo, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		textInfo, err := os.Stat(filepath.Join(book.TextDir, relPath))
+		if err != nil || textInfo.ModTime().Before(rawInfo.ModTime()) {
+			stale[volume]++
+		}
+
+		return nil
+	})
+	if err != nil {
+		c.addWarning(subject, "failed to scan raw directory: %s", err)
+		return
+	}
+
+	for _, volume := range getSortedKeys(failed) {
+		c.addWarning(subject, "volume %q has %d chapter(s) failed to download", volume, failed[volume])
+	}
+
+	for _, volume := range getSortedKeys(stale) {
+		c.addWarning(subject, "text of volume %q is stale, %d of %d file(s) not decyphered or outdated", volume, stale[volume], total[volume])
+	}
+}
+
+// getSortedKeys returns keys of map in ascending order.
+func getSortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,71 @@
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	book_mgr "github.com/SirZenith/delite/book_management"
+)
+
+func TestCheckBooksDirectories(t *testing.T) {
+	cases := []struct {
+		name       string
+		content    string
+		wantErrCnt int
+	}{
+		{
+			name: "unset directory names",
+			content: `{
+				"root": "books",
+				"raw_name": "raw",
+				"text_name": "text",
+				"books": [
+					{"title": "A", "toc_url": "https://ncode.syosetu.com/n0001a/"},
+					{"title": "B", "toc_url": "https://ncode.syosetu.com/n0002b/"}
+				]
+			}`,
+		},
+		{
+			name: "collided directories",
+			content: `{
+				"root": "books",
+				"raw_name": "raw",
+				"text_name": "raw",
+				"books": [
+					{"title": "A", "toc_url": "https://ncode.syosetu.com/n0001a/"},
+					{"title": "B", "toc_url": "https://ncode.syosetu.com/n0002b/", "root": "A"}
+				]
+			}`,
+			wantErrCnt: 2,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			infoPath := filepath.Join(t.TempDir(), "library.json")
+			if err := os.WriteFile(infoPath, []byte(c.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := book_mgr.ReadLibraryInfo(infoPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checker := &libraryChecker{info: info}
+			checker.checkBooks()
+
+			errCnt := 0
+			for _, problem := range checker.problems {
+				if problem.level == lintLevelError {
+					errCnt++
+				}
+			}
+
+			if errCnt != c.wantErrCnt {
+				t.Errorf("got %d error(s), want %d: %v", errCnt, c.wantErrCnt, checker.problems)
+			}
+		})
+	}
+}
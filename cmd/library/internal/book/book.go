@@ -10,6 +10,7 @@ import (
 
 	book_mgr "github.com/SirZenith/delite/book_management"
 	"github.com/SirZenith/delite/common"
+	"github.com/SirZenith/delite/database"
 	"github.com/charmbracelet/log"
 	"github.com/jeandeaual/go-locale"
 	"github.com/urfave/cli/v3"
@@ -27,6 +28,8 @@ func Cmd() *cli.Command {
 			subCmdList(),
 			subCmdListVolume(),
 			subCmdMarkRead(),
+			subCmdNextUnread(),
+			subCmdProgress(),
 			subCmdRate(),
 			subCmdSort(),
 		},
@@ -111,6 +114,7 @@ func subCmdAdd() *cli.Command {
 			}
 
 			book := book_mgr.BookInfo{
+				ID:     book_mgr.NewBookID(),
 				Title:  title,
 				Author: author,
 				Artist: artist,
@@ -164,7 +168,9 @@ func subCmdAddEmpty() *cli.Command {
 				return fmt.Errorf("failed to parse info file %s: %s", filePath, err)
 			}
 
-			book := book_mgr.BookInfo{}
+			book := book_mgr.BookInfo{
+				ID: book_mgr.NewBookID(),
+			}
 
 			localType := cmd.String("local")
 			if localType != "" {
@@ -224,9 +230,9 @@ func subCmdList() *cli.Command {
 			case cmd.Bool("json"):
 				printBooksJSON(books, keyword)
 			case cmd.Bool("verbose"):
-				printBooksVerbose(books, keyword)
+				printBooksVerbose(books, keyword, loadReadProgress(info, keyword))
 			default:
-				printBooksSimple(books, keyword)
+				printBooksSimple(books, keyword, loadReadProgress(info, keyword))
 			}
 
 			return nil
@@ -234,17 +240,22 @@ func subCmdList() *cli.Command {
 	}
 }
 
-func printBooksSimple(books []book_mgr.BookInfo, keyword *book_mgr.SearchKeyword) {
+func printBooksSimple(books []book_mgr.BookInfo, keyword *book_mgr.SearchKeyword, progressMap map[string]database.ReadProgress) {
 	for index, book := range books {
 		if !keyword.MatchBook(index, book) {
 			continue
 		}
 
-		fmt.Printf("%d. %s\n", index+1, common.GetStrOr(book.Title, "no-title"))
+		title := common.GetStrOr(book.Title, "no-title")
+		if progress := formatProgress(progressMap, book.ID); progress != "" {
+			fmt.Printf("%d. %s (%s)\n", index+1, title, progress)
+		} else {
+			fmt.Printf("%d. %s\n", index+1, title)
+		}
 	}
 }
 
-func printBooksVerbose(books []book_mgr.BookInfo, keyword *book_mgr.SearchKeyword) {
+func printBooksVerbose(books []book_mgr.BookInfo, keyword *book_mgr.SearchKeyword, progressMap map[string]database.ReadProgress) {
 	for index, book := range books {
 		if !keyword.MatchBook(index, book) {
 			continue
@@ -258,6 +269,9 @@ func printBooksVerbose(books []book_mgr.BookInfo, keyword *book_mgr.SearchKeywor
 		fmt.Println("  text output :", book.TextDir)
 		fmt.Println("  image output:", book.ImgDir)
 		fmt.Println("  header      :", book.HeaderFile)
+		if progress := formatProgress(progressMap, book.ID); progress != "" {
+			fmt.Println("  progress    :", progress)
+		}
 	}
 }
 
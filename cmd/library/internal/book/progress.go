@@ -0,0 +1,279 @@
+package book
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	book_mgr "github.com/SirZenith/delite/book_management"
+	"github.com/SirZenith/delite/database"
+	"github.com/SirZenith/delite/database/data_model"
+	"github.com/charmbracelet/log"
+	"github.com/urfave/cli/v3"
+	"gorm.io/gorm"
+)
+
+func subCmdProgress() *cli.Command {
+	var rawKeyword string
+	var volIndex int64
+	var chapIndex int64
+
+	return &cli.Command{
+		Name:  "progress",
+		Usage: "print read progress of books, or set read progress of a book when volume index is given, volume index 0 resets progress",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "library",
+				Usage: "path of library.json file to be modified",
+				Value: "./library.json",
+			},
+		},
+		Arguments: []cli.Argument{
+			&cli.StringArg{
+				Name:        "book-keyword",
+				UsageText:   "<book>",
+				Destination: &rawKeyword,
+				Min:         1,
+				Max:         1,
+			},
+			&cli.IntArg{
+				Name:        "volume",
+				UsageText:   " [volume]",
+				Destination: &volIndex,
+				Value:       -1,
+				Max:         1,
+			},
+			&cli.IntArg{
+				Name:        "chapter",
+				UsageText:   " [chapter]",
+				Destination: &chapIndex,
+				Max:         1,
+			},
+		},
+		Action: func(_ context.Context, cmd *cli.Command) error {
+			info, db, err := openLibraryDB(cmd.String("library"))
+			if err != nil {
+				return err
+			}
+			defer database.Close(db)
+
+			keyword := book_mgr.NewSearchKeyword(rawKeyword)
+			books := []book_mgr.BookInfo{}
+			for i, book := range info.Books {
+				if keyword.MatchBook(i, book) {
+					books = append(books, book)
+				}
+			}
+
+			if volIndex < 0 {
+				for _, book := range books {
+					printVolumeProgress(db, book)
+				}
+				return nil
+			}
+
+			if len(books) != 1 {
+				return fmt.Errorf("keyword %q matches %d books, exactly one is required for setting progress", rawKeyword, len(books))
+			}
+
+			book := books[0]
+			if book.ID == "" {
+				return fmt.Errorf("book has no ID, run `database sync-books` first")
+			}
+
+			if err := database.SetReadProgress(db, book.ID, int(volIndex), int(chapIndex)); err != nil {
+				return fmt.Errorf("%s: %s", book.Title, err)
+			}
+
+			printVolumeProgress(db, book)
+
+			return nil
+		},
+	}
+}
+
+func subCmdNextUnread() *cli.Command {
+	var rawKeyword string
+
+	return &cli.Command{
+		Name:  "next-unread",
+		Usage: "print the first unread volume and chapter of books",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "library",
+				Usage: "path of library.json file to be modified",
+				Value: "./library.json",
+			},
+		},
+		Arguments: []cli.Argument{
+			&cli.StringArg{
+				Name:        "book-keyword",
+				UsageText:   "<book>",
+				Destination: &rawKeyword,
+				Max:         1,
+			},
+		},
+		Action: func(_ context.Context, cmd *cli.Command) error {
+			info, db, err := openLibraryDB(cmd.String("library"))
+			if err != nil {
+				return err
+			}
+			defer database.Close(db)
+
+			keyword := book_mgr.NewSearchKeyword(rawKeyword)
+			for i, book := range info.Books {
+				if !keyword.MatchBook(i, book) || book.ID == "" {
+					continue
+				}
+
+				if book.Meta != nil && book.Meta.IsRead {
+					continue
+				}
+
+				volume, chapter, err := database.FindNextUnread(db, book.ID)
+				if err != nil {
+					log.Errorf("%s: %s", book.Title, err)
+					continue
+				} else if volume == nil {
+					continue
+				}
+
+				fmt.Printf("%d. %s\n", i+1, book.Title)
+				fmt.Printf("  volume : %d. %s (%s)\n", volume.VolIndex, volume.Title, volume.DirPath)
+				if chapter != nil {
+					fmt.Printf("  chapter: %d. %s\n", chapter.ChapIndex, chapter.Title)
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+// openLibraryDB reads library info and opens its database, database must have
+// been migrated to support read progress.
+func openLibraryDB(libFilePath string) (*book_mgr.LibraryInfo, *gorm.DB, error) {
+	info, err := book_mgr.ReadLibraryInfo(libFilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if info.DatabasePath == "" {
+		return nil, nil, fmt.Errorf("no database path provided by library")
+	}
+
+	if _, err := os.Stat(info.DatabasePath); err != nil {
+		return nil, nil, fmt.Errorf("failed to access database %s: %s", info.DatabasePath, err)
+	}
+
+	db, err := database.Open(info.DatabasePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !database.HasReadProgress(db) {
+		database.Close(db)
+		return nil, nil, fmt.Errorf("no read progress column in database, run `database migrate` first")
+	}
+
+	return info, db, nil
+}
+
+// loadReadProgress returns read progress of books matching keyword, mapping
+// book ID to its progress. Books without volume record are omitted. Nil is
+// returned when library database doesn't support read progress.
+func loadReadProgress(info *book_mgr.LibraryInfo, keyword *book_mgr.SearchKeyword) map[string]database.ReadProgress {
+	if info.DatabasePath == "" {
+		return nil
+	}
+
+	if _, err := os.Stat(info.DatabasePath); err != nil {
+		return nil
+	}
+
+	db, err := database.Open(info.DatabasePath)
+	if err != nil {
+		return nil
+	}
+	defer database.Close(db)
+
+	if !database.HasReadProgress(db) {
+		return nil
+	}
+
+	result := map[string]database.ReadProgress{}
+	for i, book := range info.Books {
+		if book.ID == "" || !keyword.MatchBook(i, book) {
+			continue
+		}
+
+		progress, err := database.GetReadProgress(db, book.ID)
+		if err != nil {
+			log.Warnf("%s: %s", book.Title, err)
+			continue
+		}
+
+		if progress.TotalVolumes > 0 {
+			result[book.ID] = progress
+		}
+	}
+
+	return result
+}
+
+// formatProgress returns progress text of book, empty string is returned if
+// book has no progress record.
+func formatProgress(progressMap map[string]database.ReadProgress, bookID string) string {
+	progress, ok := progressMap[bookID]
+	if !ok {
+		return ""
+	}
+
+	return fmt.Sprintf("vol %d/%d", progress.ReadVolumes, progress.TotalVolumes)
+}
+
+// printVolumeProgress prints read state of all volumes of book.
+func printVolumeProgress(db *gorm.DB, book book_mgr.BookInfo) {
+	fmt.Println("title:", book.Title)
+
+	if book.ID == "" {
+		fmt.Println("  no volume record")
+		return
+	}
+
+	volumes, err := database.GetVolumes(db, book.ID)
+	if err != nil {
+		log.Errorf("%s", err)
+		return
+	} else if len(volumes) == 0 {
+		fmt.Println("  no volume record")
+		return
+	}
+
+	for _, volume := range volumes {
+		mark := "▢"
+		if volume.ReadAt != nil {
+			mark = "■"
+		}
+
+		fmt.Printf("%s %d. %s%s\n", mark, volume.VolIndex, volume.Title, formatChapterProgress(db, volume))
+	}
+}
+
+// formatChapterProgress returns chapter progress text of partially read volume.
+func formatChapterProgress(db *gorm.DB, volume data_model.Volume) string {
+	if volume.ReadAt != nil {
+		return ""
+	}
+
+	var total, read int64
+	chapters := db.Model(&data_model.Chapter{}).Where("volume_id = ?", volume.ID)
+	chapters.Session(&gorm.Session{}).Count(&total)
+	chapters.Session(&gorm.Session{}).Where("read_at IS NOT NULL").Count(&read)
+
+	if read == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(" (chapter %d/%d)", read, total)
+}
@@ -0,0 +1,131 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	book_mgr "github.com/SirZenith/delite/book_management"
+	"github.com/SirZenith/delite/database"
+	"github.com/SirZenith/delite/database/data_model"
+	"github.com/urfave/cli/v3"
+)
+
+func Cmd() *cli.Command {
+	var rawKeyword string
+
+	return &cli.Command{
+		Name:  "history",
+		Usage: "show recent download, decypher and bundle activity of books",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "library",
+				Usage: "path to library info JSON file",
+				Value: "./library.json",
+			},
+			&cli.IntFlag{
+				Name:    "limit",
+				Aliases: []string{"n"},
+				Usage:   "maximum number of records to show, 0 for no limit",
+				Value:   20,
+			},
+			&cli.BoolFlag{
+				Name:    "verbose",
+				Aliases: []string{"v"},
+				Usage:   "print files changed by each operation",
+			},
+		},
+		Arguments: []cli.Argument{
+			&cli.StringArg{
+				Name:        "book-keyword",
+				UsageText:   "[book]",
+				Destination: &rawKeyword,
+				Max:         1,
+			},
+		},
+		Action: func(_ context.Context, cmd *cli.Command) error {
+			info, err := book_mgr.ReadLibraryInfo(cmd.String("library"))
+			if err != nil {
+				return err
+			}
+
+			if info.DatabasePath == "" {
+				return fmt.Errorf("no database path provided by library")
+			}
+
+			if _, err := os.Stat(info.DatabasePath); err != nil {
+				return fmt.Errorf("failed to access database %s: %s", info.DatabasePath, err)
+			}
+
+			db, err := database.Open(info.DatabasePath)
+			if err != nil {
+				return err
+			}
+			defer database.Close(db)
+
+			if !database.HasHistoryTable(db) {
+				return fmt.Errorf("no history table in database, run `database migrate` first")
+			}
+
+			var bookIDs, titles []string
+			if rawKeyword != "" {
+				keyword := book_mgr.NewSearchKeyword(rawKeyword)
+				for i, book := range info.Books {
+					if !keyword.MatchBook(i, book) {
+						continue
+					}
+
+					if book.ID != "" {
+						bookIDs = append(bookIDs, book.ID)
+					}
+					titles = append(titles, book.Title)
+				}
+
+				if len(titles) == 0 {
+					return fmt.Errorf("no book matches keyword %q", rawKeyword)
+				}
+			}
+
+			entries, err := database.GetHistory(db, bookIDs, titles, int(cmd.Int("limit")))
+			if err != nil {
+				return err
+			}
+
+			verbose := cmd.Bool("verbose")
+			for _, entry := range entries {
+				printEntry(entry, verbose)
+			}
+
+			return nil
+		},
+	}
+}
+
+// printEntry prints one history record in a single line, followed by changed
+// files when `verbose` is true.
+func printEntry(entry data_model.HistoryEntry, verbose bool) {
+	target := entry.Book
+	if entry.Volume != "" {
+		target += " vol " + entry.Volume
+	}
+
+	fmt.Printf(
+		"%s  %-11s %s (ok %d, failed %d, %s)\n",
+		entry.CreatedAt.Local().Format(time.DateTime),
+		entry.Command,
+		target,
+		entry.Processed,
+		entry.Failed,
+		entry.Duration.Round(time.Millisecond),
+	)
+
+	if !verbose || entry.Changes == "" {
+		return
+	}
+
+	for _, file := range strings.Split(entry.Changes, "\n") {
+		fmt.Println("    " + file)
+	}
+}
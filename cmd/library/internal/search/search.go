@@ -0,0 +1,174 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	book_mgr "github.com/SirZenith/delite/book_management"
+	"github.com/SirZenith/delite/database"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/log"
+	"github.com/urfave/cli/v3"
+	"gorm.io/gorm"
+)
+
+func Cmd() *cli.Command {
+	var query string
+
+	return &cli.Command{
+		Name:  "search",
+		Usage: "full-text search in decyphered chapter texts",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "library",
+				Usage: "path to library info JSON file",
+				Value: "./library.json",
+			},
+			&cli.StringFlag{
+				Name:    "book",
+				Aliases: []string{"b"},
+				Usage:   "only search in books matching given keyword",
+			},
+			&cli.IntFlag{
+				Name:    "limit",
+				Aliases: []string{"n"},
+				Usage:   "maximum number of results to show",
+				Value:   20,
+			},
+			&cli.BoolFlag{
+				Name:  "reindex",
+				Usage: "rebuild index from text directory of books before searching",
+			},
+		},
+		Arguments: []cli.Argument{
+			&cli.StringArg{
+				Name:        "query",
+				UsageText:   "<query>",
+				Destination: &query,
+				Max:         1,
+			},
+		},
+		Action: func(_ context.Context, cmd *cli.Command) error {
+			libFilePath := cmd.String("library")
+			reindex := cmd.Bool("reindex")
+
+			if query == "" && !reindex {
+				return fmt.Errorf("no query given")
+			}
+
+			info, err := book_mgr.ReadLibraryInfo(libFilePath)
+			if err != nil {
+				return err
+			}
+
+			if info.DatabasePath == "" {
+				return fmt.Errorf("no database path provided by library")
+			}
+
+			db, err := database.Open(info.DatabasePath)
+			if err != nil {
+				return err
+			}
+			defer database.Close(db)
+
+			if !database.HasTextIndex(db) {
+				return fmt.Errorf("no full-text index table in database, run `database migrate` first")
+			}
+
+			books := []book_mgr.BookInfo{}
+			bookKeyword := cmd.String("book")
+			keyword := book_mgr.NewSearchKeyword(bookKeyword)
+			for i, book := range info.Books {
+				if keyword.MatchBook(i, book) {
+					books = append(books, book)
+				}
+			}
+
+			if reindex {
+				reindexBooks(db, books)
+			}
+
+			if query == "" {
+				return nil
+			}
+
+			highlightStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("3"))
+			options := database.TextSearchOptions{
+				Limit: int(cmd.Int("limit")),
+				Highlight: func(text string) string {
+					return highlightStyle.Render(text)
+				},
+			}
+
+			if bookKeyword != "" {
+				if len(books) == 0 {
+					return fmt.Errorf("no book matches keyword %q", bookKeyword)
+				}
+
+				for _, book := range books {
+					options.BookIDs = append(options.BookIDs, book.ID)
+				}
+			}
+
+			results, err := database.SearchText(db, query, options)
+			if err != nil {
+				return err
+			}
+
+			printResults(results)
+
+			return nil
+		},
+	}
+}
+
+// reindexBooks updates full-text index with all chapter files in text directory
+// of given books.
+func reindexBooks(db *gorm.DB, books []book_mgr.BookInfo) {
+	for _, book := range books {
+		if book.TextDir == "" {
+			continue
+		}
+
+		if book.ID == "" {
+			log.Warnf("%s: book has no ID, run `database sync-books` to assign one before indexing", book.Title)
+			continue
+		}
+
+		updateCnt, removeCnt, err := database.IndexBookTexts(db, book.ID, book.Title, book.TextDir)
+		if err != nil {
+			log.Errorf("%s: %s", book.Title, err)
+			continue
+		}
+
+		if updateCnt > 0 || removeCnt > 0 {
+			log.Infof("%s: %d file(s) indexed, %d removed", book.Title, updateCnt, removeCnt)
+		}
+	}
+}
+
+func printResults(results []database.TextSearchResult) {
+	if len(results) == 0 {
+		fmt.Println("no match found")
+		return
+	}
+
+	titleStyle := lipgloss.NewStyle().Bold(true)
+	pathStyle := lipgloss.NewStyle().Faint(true)
+
+	for i, result := range results {
+		if i > 0 {
+			fmt.Println()
+		}
+
+		volume, chapter := path.Split(result.FilePath)
+		volume = path.Clean(volume)
+		if volume == "." {
+			volume = ""
+		}
+
+		fmt.Printf("%s %s\n", titleStyle.Render(result.Book), pathStyle.Render("/ "+path.Join(volume, chapter)))
+		fmt.Printf("    %s\n", result.Snippet)
+	}
+}
@@ -11,6 +11,8 @@ import (
 	book_mgr "github.com/SirZenith/delite/book_management"
 	"github.com/SirZenith/delite/cmd/library/internal/book"
 	"github.com/SirZenith/delite/cmd/library/internal/config"
+	"github.com/SirZenith/delite/cmd/library/internal/history"
+	"github.com/SirZenith/delite/cmd/library/internal/search"
 	"github.com/SirZenith/delite/cmd/library/internal/tag"
 	"github.com/SirZenith/delite/common"
 	"github.com/charmbracelet/log"
@@ -25,9 +27,13 @@ func Cmd() *cli.Command {
 			subCmdInit(),
 			subCmdAddHeaderFile(),
 			subCmdAddLimitRule(),
+			subCmdCheck(),
+			subCmdMerge(),
 
 			book.Cmd(),
 			config.Cmd(),
+			history.Cmd(),
+			search.Cmd(),
 			tag.Cmd(),
 		},
 	}
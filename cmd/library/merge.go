@@ -0,0 +1,472 @@
+package library
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	book_mgr "github.com/SirZenith/delite/book_management"
+	"github.com/SirZenith/delite/common"
+	"github.com/SirZenith/delite/database"
+	"github.com/SirZenith/delite/database/data_model"
+	"github.com/charmbracelet/log"
+	"github.com/urfave/cli/v3"
+)
+
+func subCmdMerge() *cli.Command {
+	var otherFilePath string
+
+	return &cli.Command{
+		Name:  "merge",
+		Usage: "merge books, tagged posts, header files and database records of another library into current library",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "library",
+				Usage: "path of library.json file to merge into",
+				Value: "./library.json",
+			},
+			&cli.StringFlag{
+				Name:  "on-conflict",
+				Usage: "how to handle database record conflicting with existing one, one of " + strings.Join(database.AllConflictPolicies, ", "),
+				Value: database.ConflictSkip,
+			},
+			&cli.BoolFlag{
+				Name:  "no-database",
+				Usage: "only merge library info file, leave database untouched",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "print changes to be made without writing anything",
+			},
+		},
+		Arguments: []cli.Argument{
+			&cli.StringArg{
+				Name:        "other-library",
+				UsageText:   "<other-library.json>",
+				Destination: &otherFilePath,
+				Min:         1,
+				Max:         1,
+			},
+		},
+		Action: func(_ context.Context, cmd *cli.Command) error {
+			filePath := cmd.String("library")
+			isDryRun := cmd.Bool("dry-run")
+
+			if absTarget, absOther := getAbsPath(filePath), getAbsPath(otherFilePath); absTarget == absOther {
+				return fmt.Errorf("can't merge library into itself")
+			}
+
+			target, err := readRawLibraryInfo(filePath)
+			if err != nil {
+				return err
+			}
+
+			other, err := readRawLibraryInfo(otherFilePath)
+			if err != nil {
+				return err
+			}
+
+			merger := newLibraryMerger(target, filepath.Dir(filePath), other, filepath.Dir(otherFilePath))
+			merger.mergeHeaderFiles()
+			merger.mergeBooks()
+			merger.mergeTaggedPosts()
+
+			for _, msg := range merger.conflicts {
+				log.Warnf("conflict: %s", msg)
+			}
+
+			log.Infof(
+				"books: %d added, %d merged; tagged posts: %d added, %d merged; header files: %d added; %d conflict(s)",
+				merger.addedBooks, merger.mergedBooks, merger.addedPosts, merger.mergedPosts, merger.addedHeaders, len(merger.conflicts),
+			)
+
+			if !isDryRun {
+				if err := target.SaveFile(filePath); err != nil {
+					return err
+				}
+			}
+
+			if cmd.Bool("no-database") {
+				return nil
+			}
+
+			options := database.ImportOptions{
+				OnConflict: cmd.String("on-conflict"),
+				DryRun:     isDryRun,
+			}
+
+			return mergeLibraryDatabase(filePath, otherFilePath, merger.titleMap, options)
+		},
+	}
+}
+
+// readRawLibraryInfo reads library info file without resolving paths and
+// filling default values.
+func readRawLibraryInfo(filePath string) (*book_mgr.LibraryInfo, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read info file %s: %s", filePath, err)
+	}
+
+	info := &book_mgr.LibraryInfo{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil, fmt.Errorf("failed to parse info file %s: %s", filePath, err)
+	}
+
+	return info, nil
+}
+
+func getAbsPath(path string) string {
+	if absPath, err := filepath.Abs(path); err == nil {
+		return absPath
+	}
+	return filepath.Clean(path)
+}
+
+// libraryMerger merges content of one library info into another.
+type libraryMerger struct {
+	target     *book_mgr.LibraryInfo
+	targetRoot string // absolute root directory of target library
+	other      *book_mgr.LibraryInfo
+	otherRoot  string // absolute root directory of other library
+
+	titleMap  map[string]string // maps title of book in other library to title of merged book
+	conflicts []string
+
+	addedBooks, mergedBooks int
+	addedPosts, mergedPosts int
+	addedHeaders            int
+}
+
+func newLibraryMerger(target *book_mgr.LibraryInfo, targetDir string, other *book_mgr.LibraryInfo, otherDir string) *libraryMerger {
+	return &libraryMerger{
+		target:     target,
+		targetRoot: getAbsPath(common.ResolveRelativePath(common.GetStrOr(target.RootDir, "./"), targetDir)),
+		other:      other,
+		otherRoot:  getAbsPath(common.ResolveRelativePath(common.GetStrOr(other.RootDir, "./"), otherDir)),
+		titleMap:   map[string]string{},
+	}
+}
+
+func (m *libraryMerger) addConflict(format string, args ...any) {
+	m.conflicts = append(m.conflicts, fmt.Sprintf(format, args...))
+}
+
+// rebasePath converts path relative to root of other library into path
+// relative to root of target library. Absolute path is returned as is.
+func (m *libraryMerger) rebasePath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	absPath := filepath.Join(m.otherRoot, path)
+	if relPath, err := filepath.Rel(m.targetRoot, absPath); err == nil {
+		return relPath
+	}
+
+	return absPath
+}
+
+// mergeHeaderFiles adds header file patterns of other library to target
+// library, patterns already in target library with different header file are
+// kept and reported as conflicts.
+func (m *libraryMerger) mergeHeaderFiles() {
+	for _, entry := range m.other.HeaderFileList {
+		if entry.Path != "" {
+			entry.Path = m.rebasePath(entry.Path)
+		}
+
+		index := slices.IndexFunc(m.target.HeaderFileList, func(existing book_mgr.HeaderFilePattern) bool {
+			return existing.Pattern == entry.Pattern
+		})
+
+		if index < 0 {
+			m.target.HeaderFileList = append(m.target.HeaderFileList, entry)
+			m.addedHeaders++
+			log.Infof("add header file: %s", entry.Pattern)
+			continue
+		}
+
+		existing := m.target.HeaderFileList[index]
+		if filepath.Clean(existing.Path) != filepath.Clean(entry.Path) {
+			m.addConflict("header file %s: path differs, keep %q over %q", entry.Pattern, existing.Path, entry.Path)
+		}
+	}
+}
+
+// findBook returns index of book in target library with the same TOC URL or
+// title as given book, -1 is returned if no such book is found.
+func (m *libraryMerger) findBook(book book_mgr.BookInfo) int {
+	if book.TocURL != "" {
+		for i, existing := range m.target.Books {
+			if existing.TocURL == book.TocURL {
+				return i
+			}
+		}
+	}
+
+	for i, existing := range m.target.Books {
+		if existing.Title == book.Title {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (m *libraryMerger) mergeBooks() {
+	idSet := map[string]bool{}
+	for _, book := range m.target.Books {
+		if book.ID != "" {
+			idSet[book.ID] = true
+		}
+	}
+
+	for _, book := range m.other.Books {
+		index := m.findBook(book)
+		if index < 0 {
+			m.rewriteBookPaths(&book)
+			if book.ID == "" || idSet[book.ID] {
+				book.ID = book_mgr.NewBookID()
+			}
+			idSet[book.ID] = true
+
+			m.target.Books = append(m.target.Books, book)
+			m.titleMap[book.Title] = book.Title
+			m.addedBooks++
+			log.Infof("add book: %s", book.Title)
+			continue
+		}
+
+		existing := &m.target.Books[index]
+		m.mergeBookInfo(existing, book)
+		m.titleMap[book.Title] = existing.Title
+		m.mergedBooks++
+		log.Infof("merge book: %s", existing.Title)
+	}
+}
+
+// mergeBookInfo fills empty fields of existing book with values from other
+// book, fields set in both books with different values are kept and reported
+// as conflicts.
+func (m *libraryMerger) mergeBookInfo(existing *book_mgr.BookInfo, book book_mgr.BookInfo) {
+	mergeString := func(name string, dst *string, src string) {
+		if *dst == "" {
+			*dst = src
+		} else if src != "" && *dst != src {
+			m.addConflict("%s: %s differs, keep %q over %q", existing.Title, name, *dst, src)
+		}
+	}
+
+	mergeString("title", &existing.Title, book.Title)
+	mergeString("author", &existing.Author, book.Author)
+	mergeString("artist", &existing.Artist, book.Artist)
+	mergeString("TOC URL", &existing.TocURL, book.TocURL)
+
+	if existing.LocalInfo == nil && book.LocalInfo != nil && existing.TocURL == "" {
+		existing.LocalInfo = book.LocalInfo
+	}
+
+	if book.Meta == nil {
+		return
+	}
+
+	if existing.Meta == nil {
+		meta := *book.Meta
+		existing.Meta = &meta
+		return
+	}
+
+	meta := existing.Meta
+	if meta.Rating == 0 {
+		meta.Rating = book.Meta.Rating
+	} else if book.Meta.Rating != 0 && meta.Rating != book.Meta.Rating {
+		m.addConflict("%s: rating differs, keep %.2f over %.2f", existing.Title, meta.Rating, book.Meta.Rating)
+	}
+
+	if meta.Status == 0 {
+		meta.Status = book.Meta.Status
+	}
+
+	if len(meta.Description) == 0 {
+		meta.Description = book.Meta.Description
+	}
+
+	for _, genre := range book.Meta.Genre {
+		if !slices.Contains(meta.Genre, genre) {
+			meta.Genre = append(meta.Genre, genre)
+		}
+	}
+
+	meta.IsRead = meta.IsRead || book.Meta.IsRead
+	meta.IsTakenDown = meta.IsTakenDown || book.Meta.IsTakenDown
+	meta.IsHasLocalVersion = meta.IsHasLocalVersion || book.Meta.IsHasLocalVersion
+	meta.IsPreferLocalVersion = meta.IsPreferLocalVersion || book.Meta.IsPreferLocalVersion
+	meta.NoTranster = meta.NoTranster || book.Meta.NoTranster
+}
+
+// rewriteBookPaths updates paths of a book from other library, so that they
+// point to the same directories when book is put in target library.
+func (m *libraryMerger) rewriteBookPaths(book *book_mgr.BookInfo) {
+	rootDir := m.rebasePath(common.GetStrOr(book.RootDir, book.Title))
+
+	if rootDir == book.Title {
+		rootDir = ""
+	}
+	book.RootDir = rootDir
+
+	// directories relative to book root stay valid, only default directory
+	// names of libraries need to be taken care of.
+	dirs := []struct {
+		field      *string
+		otherName  string
+		targetName string
+	}{
+		{&book.RawDir, m.other.RawDirName, m.target.RawDirName},
+		{&book.TextDir, m.other.TextDirName, m.target.TextDirName},
+		{&book.ImgDir, m.other.ImgDirName, m.target.ImgDirName},
+		{&book.EpubDir, m.other.EpubDirName, m.target.EpubDirName},
+		{&book.LatexDir, m.other.LatexDirName, m.target.LatexDirName},
+		{&book.MarkdownDir, m.other.MarkdownDirName, m.target.MarkdownDirName},
+		{&book.PdfDir, m.other.PdfDirName, m.target.PdfDirName},
+		{&book.TypstDir, m.other.TypstDirName, m.target.TypstDirName},
+		{&book.ZipDir, m.other.ZipDirName, m.target.ZipDirName},
+	}
+
+	for _, dir := range dirs {
+		if *dir.field == "" && dir.otherName != dir.targetName {
+			*dir.field = dir.otherName
+		}
+	}
+}
+
+func (m *libraryMerger) mergeTaggedPosts() {
+	for _, post := range m.other.TaggedPosts {
+		index := slices.IndexFunc(m.target.TaggedPosts, func(existing book_mgr.TaggedPostInfo) bool {
+			return existing.Tag == post.Tag || (post.Title != "" && existing.Title == post.Title)
+		})
+
+		if index < 0 {
+			m.target.TaggedPosts = append(m.target.TaggedPosts, post)
+			m.addedPosts++
+			log.Infof("add tagged post: %s", common.GetStrOr(post.Title, post.Tag))
+			continue
+		}
+
+		existing := &m.target.TaggedPosts[index]
+		if existing.Tag != post.Tag {
+			m.addConflict("tagged post %s: tag differs, keep %q over %q", existing.Title, existing.Tag, post.Tag)
+		}
+
+		if existing.Title == "" {
+			existing.Title = post.Title
+		}
+
+		existing.PageCnt = max(existing.PageCnt, post.PageCnt)
+		m.mergedPosts++
+	}
+}
+
+// mergeLibraryDatabase copies file and tagged post records from database of
+// other library into database of target library. Book titles in file records
+// are changed according to `titleMap`.
+func mergeLibraryDatabase(filePath, otherFilePath string, titleMap map[string]string, options database.ImportOptions) error {
+	target, err := book_mgr.ReadLibraryInfo(filePath)
+	if err != nil {
+		return err
+	}
+
+	other, err := book_mgr.ReadLibraryInfo(otherFilePath)
+	if err != nil {
+		return err
+	}
+
+	if other.DatabasePath == "" {
+		return nil
+	} else if _, err := os.Stat(other.DatabasePath); err != nil {
+		log.Infof("skip database merging, other database is not accessible: %s", err)
+		return nil
+	}
+
+	if target.DatabasePath == "" {
+		return fmt.Errorf("no database path provided by library")
+	} else if getAbsPath(target.DatabasePath) == getAbsPath(other.DatabasePath) {
+		return nil
+	}
+
+	dst, err := database.Open(target.DatabasePath)
+	if err != nil {
+		return err
+	}
+	defer database.Close(dst)
+
+	src, err := database.Open(other.DatabasePath)
+	if err != nil {
+		return err
+	}
+	defer database.Close(src)
+
+	tables := []struct {
+		name      string
+		model     data_model.DataModel
+		transform func(record data_model.DataModel)
+	}{
+		{
+			name:  "file_entries",
+			model: &data_model.FileEntry{},
+			transform: func(record data_model.DataModel) {
+				entry := record.(*data_model.FileEntry)
+				if title, ok := titleMap[entry.Book]; ok {
+					entry.Book = title
+				}
+			},
+		},
+		{
+			name:  "tagged_post_entries",
+			model: &data_model.TaggedPostEntry{},
+		},
+	}
+
+	for _, table := range tables {
+		if !src.Migrator().HasTable(table.model) {
+			continue
+		}
+
+		if !dst.Migrator().HasTable(table.model) {
+			return fmt.Errorf("no table %s in database, run `database migrate` first", table.name)
+		}
+
+		report, err := database.MergeTable(dst, src, table.model, options, table.transform)
+		if err != nil {
+			return fmt.Errorf("failed to merge table %s: %s", table.name, err)
+		}
+
+		for _, diff := range report.Diffs {
+			if diff.IsNew {
+				continue
+			}
+
+			changes := make([]string, 0, len(diff.Changes))
+			for _, change := range diff.Changes {
+				changes = append(changes, fmt.Sprintf("%s: %q -> %q", change.Column, change.Old, change.New))
+			}
+
+			if diff.IsSkipped {
+				log.Warnf("conflict: %s %s skipped, %s", table.name, diff.Key, strings.Join(changes, ", "))
+			} else {
+				log.Infof("update: %s %s, %s", table.name, diff.Key, strings.Join(changes, ", "))
+			}
+		}
+
+		log.Infof(
+			"%s: inserted %d, updated %d, unchanged %d, skipped %d",
+			table.name, report.Inserted, report.Updated, report.Unchanged, report.Skipped,
+		)
+	}
+
+	return nil
+}
@@ -1,9 +1,11 @@
 package nhentai
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"path/filepath"
 	"sync"
@@ -11,6 +13,7 @@ import (
 	"github.com/SirZenith/delite/cmd/nhentai/internal/nhenapi"
 	protodef "github.com/SirZenith/delite/cmd/nhentai/internal/nhenapi/proto_def"
 	"github.com/SirZenith/delite/common"
+	"github.com/SirZenith/delite/network"
 	"github.com/charmbracelet/log"
 	"github.com/schollz/progressbar/v3"
 )
@@ -20,6 +23,7 @@ const imageOutputFormat = common.ImageFormatAvif
 // ----------------------------------------------------------------------------
 
 type Downloader struct {
+	ctx        context.Context
 	client     *nhenapi.NhenClient
 	jobCount   int
 	retryCount int
@@ -35,19 +39,32 @@ type Downloader struct {
 
 func NewDownloader(jobCnt, retryCount int) *Downloader {
 	return &Downloader{
-		client:           nhenapi.NewNhenClient(),
 		jobCount:         jobCnt,
 		retryCount:       retryCount,
 		preferedUrlIndex: -1,
 	}
 }
 
-func (d *Downloader) InitClient(headers map[string]string, httpProxy, httpsProxy string) {
-	d.client.SetHeaders(headers)
-
-	if httpProxy != "" || httpsProxy != "" {
-		d.client.SetProxy(httpProxy, httpsProxy)
+// InitClient creates HTTP client used by downloader. Image download is retried
+// by downloader itself for retry count given to downloader, so client makes no
+// retry on its own.
+// Headers of each request are rendered with given header template, which can be
+// nil.
+func (d *Downloader) InitClient(ctx context.Context, headerTemplate *network.HeaderTemplate, httpProxy, httpsProxy string) error {
+	client, err := nhenapi.NewNhenClient(network.ClientOptions{
+		Ctx:            ctx,
+		HeaderTemplate: headerTemplate,
+		HTTPProxy:      httpProxy,
+		HTTPSProxy:     httpsProxy,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP client: %s", err)
 	}
+
+	d.ctx = ctx
+	d.client = client
+
+	return nil
 }
 
 // -----------------------------------------------------------------------------
@@ -168,34 +185,46 @@ func (d *Downloader) dlSingleImg(outputDir string, job workLoad) error {
 	d.lockPreferedUrlIndex.RUnlock()
 
 	if preferedIndex >= 0 && preferedIndex < len(job.urlList) {
-		url := job.urlList[preferedIndex]
-
-		for cnt := 0; cnt < d.retryCount; cnt++ {
-			err = d.tryDl(url, filename)
-			if err == nil {
-				return nil
-			}
+		err = d.tryDlWithRetry(job.urlList[preferedIndex], filename)
+		if err == nil {
+			return nil
 		}
 	}
 
-outter:
 	for index, url := range job.urlList {
 		if index == preferedIndex {
 			// already tried above, skip
 			continue
 		}
 
-		for cnt := 0; cnt < d.retryCount; cnt++ {
-			err = d.tryDl(url, filename)
+		err = d.tryDlWithRetry(url, filename)
+		if err == nil {
+			d.lockPreferedUrlIndex.Lock()
+			d.preferedUrlIndex = index
+			d.lockPreferedUrlIndex.Unlock()
 
-			if err == nil {
-				d.lockPreferedUrlIndex.Lock()
-				d.preferedUrlIndex = index
-				d.lockPreferedUrlIndex.Unlock()
+			break
+		}
+	}
 
-				break outter
+	return err
+}
+
+// tryDlWithRetry tries downloading image from given URL for at most retry count
+// of downloader times, waiting with backoff between attempts. Any failure,
+// including bad response status and broken image data, gets retried.
+func (d *Downloader) tryDlWithRetry(url, filename string) error {
+	var err error
+	for cnt := 0; cnt < max(d.retryCount, 1); cnt++ {
+		if cnt > 0 {
+			if backoffErr := network.Backoff(d.ctx, cnt, network.DefaultRetryDelay); backoffErr != nil {
+				return backoffErr
 			}
 		}
+
+		if err = d.tryDl(url, filename); err == nil {
+			return nil
+		}
 	}
 
 	return err
@@ -216,19 +245,12 @@ func (d *Downloader) tryDl(url, filename string) error {
 	}
 	defer resp.Body.Close()
 
-	file, err := os.Create(filename)
-	if err != nil {
-		return fmt.Errorf("failed to create output file %s: %s", filename, err)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad response status: %s", resp.Status)
 	}
-	defer file.Close()
 
-	writer := bufio.NewWriter(file)
-	defer writer.Flush()
-
-	_, err = common.ConvertImageTo(resp.Body, writer, imageOutputFormat)
-	if err != nil {
+	return common.WriteFileAtomic(filename, 0o644, func(w io.Writer) error {
+		_, err := common.ConvertImageTo(resp.Body, w, imageOutputFormat)
 		return err
-	}
-
-	return nil
+	})
 }
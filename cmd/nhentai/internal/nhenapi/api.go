@@ -4,12 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
-	"net/http/cookiejar"
-	"net/url"
 	"path"
 	"reflect"
 	"strings"
+
+	"github.com/SirZenith/delite/network"
 )
 
 const MetaInfoFieldName = "Info"
@@ -66,102 +65,19 @@ func parseRequest(arg any) (string, string, error) {
 	return targetPath, method, nil
 }
 
+// NhenClient is HTTP client with API request support.
 type NhenClient struct {
-	*http.Client
-	headers map[string]string
+	*network.Client
 }
 
-func NewNhenClient() *NhenClient {
-	client := &NhenClient{
-		Client: new(http.Client),
-	}
-
-	client.Transport = &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-	}
-
-	return client
-}
-
-// SetProxy updates proxy URL used by client.
-func (c *NhenClient) SetProxy(httpProxy, httpsProxy string) {
-	transport, ok := c.Transport.(*http.Transport)
-	if !ok {
-		transport = new(http.Transport)
-		c.Transport = transport
-	}
-
-	transport.Proxy = func(req *http.Request) (*url.URL, error) {
-		switch req.URL.Scheme {
-		case "http":
-			if httpProxy == "" {
-				return nil, nil
-			} else {
-				return url.Parse(httpProxy)
-			}
-		case "https":
-			if httpsProxy == "" {
-				return nil, nil
-			} else {
-				return url.Parse(httpsProxy)
-			}
-		default:
-			return http.ProxyFromEnvironment(req)
-		}
-	}
-}
-
-// SetCookie update cookie jar used by client.
-func (c *NhenClient) SetCookie(urlStr string, cookies map[string]string) error {
-	target, err := url.Parse(urlStr)
+// NewNhenClient creates API client with given HTTP client options.
+func NewNhenClient(options network.ClientOptions) (*NhenClient, error) {
+	client, err := network.NewClient(options)
 	if err != nil {
-		return fmt.Errorf("failed to parse URL %s: %s", urlStr, err)
-	}
-
-	if c.Jar == nil {
-		jar, err := cookiejar.New(nil)
-		if err != nil {
-			return fmt.Errorf("failed to create cookie jar for new client: %s", err)
-		}
-		c.Jar = jar
-	}
-
-	list := []*http.Cookie{}
-	for name, value := range cookies {
-		list = append(list, &http.Cookie{
-			Name: name, Value: value,
-		})
-	}
-
-	c.Jar.SetCookies(target, list)
-
-	return nil
-}
-
-// SetHeaders updates default headers used by all request.
-func (c *NhenClient) SetHeaders(headers map[string]string) {
-	result := map[string]string{}
-	for k, v := range headers {
-		result[k] = v
-	}
-	c.headers = result
-}
-
-// Do sends a new request with given method to `url`.
-func (c *NhenClient) Do(method, url string, body io.Reader) (*http.Response, error) {
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create new request: %s", err)
-	}
-
-	if c.headers != nil {
-		for name, value := range c.headers {
-			req.Header.Set(name, value)
-		}
+		return nil, err
 	}
 
-	resp, err := c.Client.Do(req)
-	return resp, err
+	return &NhenClient{Client: client}, nil
 }
 
 func (c *NhenClient) ApiRequest(arg any, result any) error {
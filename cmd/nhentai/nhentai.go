@@ -3,7 +3,6 @@ package nhentai
 import (
 	"bufio"
 	"context"
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -14,6 +13,7 @@ import (
 	book_mgr "github.com/SirZenith/delite/book_management"
 	nhentai "github.com/SirZenith/delite/cmd/nhentai/internal"
 	"github.com/SirZenith/delite/common"
+	"github.com/SirZenith/delite/network"
 	"github.com/charmbracelet/log"
 	"github.com/urfave/cli/v3"
 )
@@ -73,13 +73,13 @@ func Cmd() *cli.Command {
 			subCmdParseTitle(),
 			subCmdBookInfo(),
 		},
-		Action: func(_ context.Context, cmd *cli.Command) error {
+		Action: func(ctx context.Context, cmd *cli.Command) error {
 			options, err := getOptionsFromCmd(cmd)
 			if err != nil {
 				return err
 			}
 
-			return cmdMain(options)
+			return cmdMain(ctx, options)
 		},
 	}
 }
@@ -95,8 +95,8 @@ type options struct {
 	jobCount   int64  // goroutine amount used by downloader
 	retryCount int64  // retry count for each manga page if any error is encountered
 
-	headerFile string // path to header json file
-	headers    map[string]string
+	headerFile     string // path to header json file
+	headerTemplate *network.HeaderTemplate
 
 	outputDir string // directory to download manga pages to
 	task      *DlTask
@@ -113,7 +113,6 @@ func getOptionsFromCmd(cmd *cli.Command) (options, error) {
 		retryCount: cmd.Int("retry"),
 
 		headerFile: cmd.String("header"),
-		headers:    map[string]string{},
 
 		outputDir: cmd.String("output"),
 		listFile:  cmd.String("list-file"),
@@ -130,9 +129,11 @@ func getOptionsFromCmd(cmd *cli.Command) (options, error) {
 	}
 
 	if options.headerFile != "" {
-		err := readHeaderFile(options.headerFile, options.headers)
+		template, err := network.ReadHeaderFile(options.headerFile)
 		if err != nil {
 			log.Warnf("failed to read header file: %s", err)
+		} else {
+			options.headerTemplate = template
 		}
 	}
 
@@ -184,9 +185,11 @@ func setupDefualtOptioinValue(options *options) {
 	}
 }
 
-func cmdMain(options options) error {
+func cmdMain(ctx context.Context, options options) error {
 	downloader := nhentai.NewDownloader(int(options.jobCount), int(options.retryCount))
-	downloader.InitClient(options.headers, options.httpProxy, options.httpsProxy)
+	if err := downloader.InitClient(ctx, options.headerTemplate, options.httpProxy, options.httpsProxy); err != nil {
+		return err
+	}
 
 	if options.task != nil {
 		if err := dlBook(downloader, options, *options.task); err != nil {
@@ -201,34 +204,6 @@ func cmdMain(options options) error {
 	return nil
 }
 
-type headerValue struct {
-	Name  string `json:"name"`
-	Value string `json:"value"`
-}
-
-// Reads header value from file and stores them into the map passed as argument.
-// Header file should a JSON containing array of header objects. Each header
-// objects should be object with tow string field `name` and `value`.
-func readHeaderFile(path string, result map[string]string) error {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return fmt.Errorf("failed to read header file %s: %s", path, err)
-	}
-
-	list := []headerValue{}
-	err = json.Unmarshal(data, &list)
-	if err != nil {
-		return fmt.Errorf("failed to parse header %s: %s", path, err)
-	}
-
-	for _, entry := range list {
-		key := strings.ToLower(entry.Name)
-		result[key] = entry.Value
-	}
-
-	return nil
-}
-
 func dlBook(downloader *nhentai.Downloader, options options, task DlTask) error {
 	err := downloader.GetBook(task.ID)
 	if err != nil {
@@ -252,7 +227,7 @@ func dlBook(downloader *nhentai.Downloader, options options, task DlTask) error
 	if options.dumpInfo {
 		infoPath := filepath.Join(outputDir, "info.json")
 		if err = downloader.DumpBookInfo(infoPath); err != nil {
-			log.Warnf("failed to save book info for %s: %s", downloader.CurBookId, err)
+			log.Warnf("failed to save book info for %d: %s", downloader.CurBookId, err)
 		}
 	}
 
@@ -469,18 +444,27 @@ func subCmdBookInfo() *cli.Command {
 				Max:         1,
 			},
 		},
-		Action: func(_ context.Context, cmd *cli.Command) error {
+		Action: func(ctx context.Context, cmd *cli.Command) error {
 			options := options{
 				httpProxy:  cmd.String("proxy"),
 				httpsProxy: cmd.String("proxy"),
 				retryCount: cmd.Int("retry"),
 
 				headerFile: cmd.String("header"),
-				headers:    map[string]string{},
+			}
+
+			if options.headerFile != "" {
+				template, err := network.ReadHeaderFile(options.headerFile)
+				if err != nil {
+					return err
+				}
+				options.headerTemplate = template
 			}
 
 			downloader := nhentai.NewDownloader(1, int(options.retryCount))
-			downloader.InitClient(options.headers, options.httpProxy, options.httpsProxy)
+			if err := downloader.InitClient(ctx, options.headerTemplate, options.httpProxy, options.httpsProxy); err != nil {
+				return err
+			}
 
 			err := downloader.GetBook(int(bookId))
 			if err != nil {
@@ -8,13 +8,18 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 
 	book_mgr "github.com/SirZenith/delite/book_management"
 	"github.com/SirZenith/delite/common"
+	"github.com/SirZenith/delite/database"
+	"github.com/SirZenith/delite/page_collect"
 	"github.com/charmbracelet/log"
 	"github.com/urfave/cli/v3"
 	"golang.org/x/net/html"
+	"gorm.io/gorm"
 )
 
 const (
@@ -89,13 +94,20 @@ type decypherTarget struct {
 	Target        string
 	Output        string
 
+	BookID    string
+	BookTitle string
+
 	targetVolume  int
 	IsUnsupported bool
 }
 
 type options struct {
 	jobCnt  int
+	dbPath  string // path to library database, used for full-text index and history records
 	targets []decypherTarget
+
+	db           *gorm.DB
+	hasTextIndex bool // database has full-text index tables
 }
 
 type translateContext struct {
@@ -110,22 +122,24 @@ func getOptionsFromCmd(cmd *cli.Command, rawKeyword string, volumeIndex int) (op
 	}
 
 	libFilePath := cmd.String("library")
-	targets, err := loadLibraryTargets(libFilePath, rawKeyword, volumeIndex)
+	dbPath, targets, err := loadLibraryTargets(libFilePath, rawKeyword, volumeIndex)
 	if err != nil {
 		return options, err
 	}
 
+	options.dbPath = dbPath
 	options.targets = targets
 
 	return options, nil
 }
 
 // loadLibraryTargets reads book list from library info JSON and returns them
-// as a list of DecypherTarget.
-func loadLibraryTargets(libInfoPath string, rawKeyword string, volumeIndex int) ([]decypherTarget, error) {
+// as a list of DecypherTarget. Database path of library is returned along with
+// targets.
+func loadLibraryTargets(libInfoPath string, rawKeyword string, volumeIndex int) (string, []decypherTarget, error) {
 	info, err := book_mgr.ReadLibraryInfo(libInfoPath)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 
 	keyword := book_mgr.NewSearchKeyword(rawKeyword)
@@ -140,12 +154,15 @@ func loadLibraryTargets(libInfoPath string, rawKeyword string, volumeIndex int)
 			Output:        book.TextDir,
 			TranslateType: getTranslateTypeByURL(book.TocURL),
 
+			BookID:    book.ID,
+			BookTitle: book.Title,
+
 			targetVolume:  volumeIndex,
 			IsUnsupported: book.LocalInfo != nil,
 		})
 	}
 
-	return targets, nil
+	return info.DatabasePath, targets, nil
 }
 
 // Guess translate type from a TOC URL. If translate can not be settle, this
@@ -197,6 +214,20 @@ func getTranslateMap(translateType string) translateContext {
 }
 
 func cmdMain(options options) error {
+	if options.dbPath != "" {
+		db, err := database.Open(options.dbPath)
+		if err != nil {
+			return err
+		}
+		defer database.Close(db)
+
+		options.db = db
+		options.hasTextIndex = database.HasTextIndex(db)
+		if !options.hasTextIndex {
+			log.Warnf("no full-text index table in database, run `database migrate` to enable text indexing")
+		}
+	}
+
 	for _, target := range options.targets {
 		logWorkBeginBanner(target)
 
@@ -205,6 +236,10 @@ func cmdMain(options options) error {
 			continue
 		}
 
+		if options.hasTextIndex && target.BookID == "" {
+			log.Warnf("book has no ID, run `database sync-books` to assign one and enable text indexing")
+		}
+
 		ctx := getTranslateMap(target.TranslateType)
 		if target.TranslateType != decypherTypeNone && (ctx.runeRemap == nil || ctx.fontReMap == nil) {
 			log.Warnf("no translate map for type: %q", target.TranslateType)
@@ -216,15 +251,26 @@ func cmdMain(options options) error {
 			continue
 		}
 
+		volume := ""
+		if target.targetVolume > 0 {
+			volume = strconv.Itoa(target.targetVolume)
+		}
+		history := database.NewHistoryRun(database.HistoryCommandDecypher, target.BookID, target.BookTitle, volume)
+
 		if info.IsDir() {
-			err = decypherDirectory(ctx, &options, &target)
-		} else {
-			err = decypherSingleFile(ctx, target.Target, target.Output)
+			err = decypherDirectory(ctx, &options, &target, history)
+		} else if err = decypherSingleFile(ctx, target.Target, target.Output); err == nil {
+			history.AddProcessed(target.Output)
 		}
 
 		if err != nil {
+			history.AddFailed()
 			log.Errorf("%s", err)
 		}
+
+		if err := history.Save(options.db); err != nil {
+			log.Warnf("%s", err)
+		}
 	}
 
 	return nil
@@ -273,7 +319,7 @@ func decypherSingleFile(ctx translateContext, srcFile string, outputFile string)
 }
 
 // Recursively decypher all files under given directory.
-func decypherDirectory(ctx translateContext, options *options, target *decypherTarget) error {
+func decypherDirectory(ctx translateContext, options *options, target *decypherTarget, history *database.HistoryRun) error {
 	jobCnt := options.jobCnt
 	task := make(chan string, jobCnt)
 	result := make(chan Result, jobCnt)
@@ -291,9 +337,14 @@ func decypherDirectory(ctx translateContext, options *options, target *decypherT
 	for endedCnt < jobCnt {
 		r := <-result
 		if r.err != nil {
+			history.AddFailed()
 			log.Error(r.err)
 		} else if r.childPath != "" {
 			log.Debugf("ok: %s", r.childPath)
+			history.AddProcessed(filepath.ToSlash(r.childPath))
+			if options.hasTextIndex {
+				updateTextIndex(options.db, target, r.childPath)
+			}
 		} else {
 			endedCnt++
 		}
@@ -302,6 +353,27 @@ func decypherDirectory(ctx translateContext, options *options, target *decypherT
 	return nil
 }
 
+// updateTextIndex adds decyphered file to full-text index, does nothing when
+// no database is available.
+func updateTextIndex(db *gorm.DB, target *decypherTarget, childPath string) {
+	if db == nil || target.BookID == "" || !database.IsTextFileName(childPath) {
+		return
+	}
+
+	if _, err := database.IndexTextFile(db, target.BookID, target.BookTitle, target.Output, childPath); err != nil {
+		log.Warnf("%s", err)
+	}
+}
+
+// isSkippedEntryName reports if file or directory with given name is not
+// downloaded content, such as hidden files, partial chapter files and their
+// progress files, and marks of chapters failed to download.
+func isSkippedEntryName(name string) bool {
+	return strings.HasPrefix(name, ".") ||
+		strings.HasSuffix(name, ".partial") ||
+		page_collect.IsFailedMarkName(name)
+}
+
 func decypherBoss(taskChan chan string, target *decypherTarget, childPath string, nestedLevel int) error {
 	fullPath := filepath.Join(target.Target, childPath)
 	info, err := os.Stat(fullPath)
@@ -329,6 +401,10 @@ func decypherBoss(taskChan chan string, target *decypherTarget, childPath string
 		return fmt.Errorf("unable to read directory %s: %s", fullPath, err)
 	}
 
+	entryList = slices.DeleteFunc(entryList, func(entry os.DirEntry) bool {
+		return isSkippedEntryName(entry.Name())
+	})
+
 	for index, entry := range entryList {
 		if nestedLevel == 0 && target.targetVolume > 0 && target.targetVolume != index+1 {
 			continue
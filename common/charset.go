@@ -0,0 +1,140 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
+)
+
+// Encodings tried when a page declares no charset and is not valid UTF-8.
+var sniffCandidates = []string{"shift_jis", "euc-jp", "gbk", "big5", "euc-kr"}
+
+// Matches charset declaration in `<meta charset="...">` and
+// `<meta http-equiv="Content-Type" content="text/html; charset=...">`.
+var patternMetaCharset = regexp.MustCompile(`(?i)(<meta\s[^>]*?charset\s*=\s*["']?)\s*([\w.:-]+)`)
+
+// DecodeHTMLToUTF8 detects charset of HTML content and transcodes it to UTF-8.
+// Charset is looked up in order of byte order mark, `contentType`, UTF-8
+// validity, meta tag in document, and finally by sniffing content. Charset declared by meta tag gets
+// rewritten to `utf-8` after transcoding.
+// Returns transcoded content and name of detected charset.
+func DecodeHTMLToUTF8(content []byte, contentType string) ([]byte, string, error) {
+	enc, name := detectHTMLCharset(content, contentType)
+	if enc == nil || name == "utf-8" {
+		return content, "utf-8", nil
+	}
+
+	data, err := decodeBytes(content, enc)
+	if err != nil {
+		return nil, name, fmt.Errorf("failed to decode content as %s: %s", name, err)
+	}
+
+	data = patternMetaCharset.ReplaceAll(data, []byte("${1}utf-8"))
+
+	return data, name, nil
+}
+
+// detectHTMLCharset returns encoding of given content, nil encoding will be
+// returned if no charset can be determined.
+func detectHTMLCharset(content []byte, contentType string) (encoding.Encoding, string) {
+	if enc, name, certain := charset.DetermineEncoding(content, contentType); certain {
+		return enc, name
+	}
+
+	// valid UTF-8 content is kept as is even if meta tag says otherwise, e.g.
+	// page saved by browser with original meta tag untouched.
+	if utf8.Valid(content) {
+		return nil, "utf-8"
+	}
+
+	head := content
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	if match := patternMetaCharset.FindSubmatch(head); match != nil {
+		if enc, name := charset.Lookup(string(match[2])); enc != nil {
+			return enc, name
+		}
+	}
+
+	return sniffCharset(content)
+}
+
+// sniffCharset decodes content with each candidate encoding, and picks the one
+// giving the most plausible text.
+func sniffCharset(content []byte) (encoding.Encoding, string) {
+	var bestEnc encoding.Encoding
+	bestName := ""
+	bestScore := 0
+
+	for _, candidate := range sniffCandidates {
+		enc, name := charset.Lookup(candidate)
+		if enc == nil {
+			continue
+		}
+
+		data, err := decodeBytes(content, enc)
+		if err != nil {
+			continue
+		}
+
+		score := scoreDecodedText(data)
+		if bestEnc == nil || score > bestScore {
+			bestEnc, bestName, bestScore = enc, name, score
+		}
+	}
+
+	return bestEnc, bestName
+}
+
+// scoreDecodedText rates how likely given text is a correct decoding. Common
+// characters in CJK text add to score, while replacement characters and
+// unusual characters are penalized.
+func scoreDecodedText(data []byte) int {
+	score := 0
+
+	for _, r := range string(data) {
+		switch {
+		case r == utf8.RuneError:
+			score -= 20
+		case r < utf8.RuneSelf:
+			// ASCII makes no difference between candidates
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			score += 2
+		case unicode.In(r, unicode.Han, unicode.Hangul):
+			score += 1
+		case unicode.IsPunct(r) || unicode.IsSpace(r),
+			r >= 0x3000 && r <= 0x30ff, // CJK symbols and kana block
+			r >= 0xff00 && r <= 0xffef: // fullwidth forms
+			// neutral
+		default:
+			score -= 5
+		}
+	}
+
+	return score
+}
+
+func decodeBytes(content []byte, enc encoding.Encoding) ([]byte, error) {
+	reader := transform.NewReader(bytes.NewReader(content), enc.NewDecoder())
+
+	buffer := bytes.NewBuffer(make([]byte, 0, len(content)*3/2))
+	if _, err := buffer.ReadFrom(reader); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// IsHTMLContentType checks if given `Content-Type` value denotes a HTML document.
+func IsHTMLContentType(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	return strings.Contains(contentType, "text/html") || strings.Contains(contentType, "application/xhtml")
+}
@@ -1,8 +1,10 @@
 package common
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -80,3 +82,65 @@ func ReplaceFileExt(name string, newExt string) string {
 	stem := name[:len(name)-len(ext)]
 	return stem + newExt
 }
+
+// WriteFileAtomic writes content produced by `writeFunc` to a temporary file
+// in the same directory as `fileName`, syncs it to disk, and then renames it to
+// `fileName`. Target file is left untouched if any of these steps fails, so
+// an existing file at `fileName` is always complete.
+func WriteFileAtomic(fileName string, perm os.FileMode, writeFunc func(w io.Writer) error) error {
+	dir, base := filepath.Split(fileName)
+
+	tmpFile, err := os.CreateTemp(dir, "."+base+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %s: %s", fileName, err)
+	}
+
+	tmpName := tmpFile.Name()
+	isOk := false
+	defer func() {
+		if !isOk {
+			tmpFile.Close()
+			os.Remove(tmpName)
+		}
+	}()
+
+	writer := bufio.NewWriter(tmpFile)
+	if err = writeFunc(writer); err != nil {
+		return err
+	}
+
+	if err = writer.Flush(); err != nil {
+		return fmt.Errorf("failed to flush data of %s: %s", fileName, err)
+	}
+
+	if err = tmpFile.Sync(); err != nil {
+		return fmt.Errorf("failed to sync data of %s: %s", fileName, err)
+	}
+
+	if err = tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file %s: %s", tmpName, err)
+	}
+
+	if err = os.Chmod(tmpName, perm); err != nil {
+		return fmt.Errorf("failed to set permission of %s: %s", tmpName, err)
+	}
+
+	if err = os.Rename(tmpName, fileName); err != nil {
+		return fmt.Errorf("failed to move temporary file to %s: %s", fileName, err)
+	}
+
+	isOk = true
+
+	return nil
+}
+
+// WriteBytesAtomic is a shorthand of WriteFileAtomic for writing a byte slice
+// to file.
+func WriteBytesAtomic(fileName string, data []byte, perm os.FileMode) error {
+	return WriteFileAtomic(fileName, perm, func(w io.Writer) error {
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("failed to write data to %s: %s", fileName, err)
+		}
+		return nil
+	})
+}
@@ -1,15 +1,15 @@
 package common
 
 import (
-	"bufio"
 	"bytes"
 	"container/list"
 	"fmt"
 	"image"
-	_ "image/gif"
+	"image/gif"
 	"image/jpeg"
 	"image/png"
 	"io"
+	"net/http"
 	"net/url"
 	"os"
 	"strings"
@@ -66,9 +66,13 @@ func LogBannerMsg(msgs []string, paddingLen int) {
 const (
 	ImageFormatAvif = "avif"
 	ImageFormatBmp  = "bmp"
+	ImageFormatGif  = "gif"
 	ImageFormatJpeg = "jpeg"
 	ImageFormatPng  = "png"
 	ImageFormatTiff = "tiff"
+	// There is no WebP encoder, image data of this format can only be saved as
+	// it is downloaded, see SaveImageAs.
+	ImageFormatWebp = "webp"
 )
 
 var AllImageFormats = []string{
@@ -85,6 +89,13 @@ func ConvertImageTo(input io.Reader, output io.Writer, outputFormat string) (str
 		return "", fmt.Errorf("image decoding failed: %s", err)
 	}
 
+	return EncodeImage(img, output, outputFormat)
+}
+
+// EncodeImage writes image to output in given format, PNG is used for unknown
+// format. Returns extension name of format actually used.
+func EncodeImage(img image.Image, output io.Writer, outputFormat string) (string, error) {
+	var err error
 	var outputExt string
 	switch outputFormat {
 	case ImageFormatAvif:
@@ -93,6 +104,9 @@ func ConvertImageTo(input io.Reader, output io.Writer, outputFormat string) (str
 	case ImageFormatBmp:
 		err = bmp.Encode(output, img)
 		outputExt = ImageFormatBmp
+	case ImageFormatGif:
+		err = gif.Encode(output, img, nil)
+		outputExt = ImageFormatGif
 	case ImageFormatJpeg:
 		err = jpeg.Encode(output, img, nil)
 		outputExt = ImageFormatJpeg
@@ -114,25 +128,93 @@ func ConvertImageTo(input io.Reader, output io.Writer, outputFormat string) (str
 	return outputExt, nil
 }
 
+// DecodeImageData checks if given data is a complete image and decodes it.
+// If `expectedSize` is non-negative, data length must match it, this catches
+// truncated response with `Content-Length` header. Text content such as HTML
+// error page is rejected before decoding.
+func DecodeImageData(data []byte, expectedSize int64) (image.Image, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty image data")
+	}
+
+	if expectedSize >= 0 && int64(len(data)) != expectedSize {
+		return nil, fmt.Errorf("size mismatch, expecting %d bytes, got %d", expectedSize, len(data))
+	}
+
+	if contentType := http.DetectContentType(data); strings.HasPrefix(contentType, "text/") {
+		return nil, fmt.Errorf("data is %s instead of image", contentType)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("image decoding failed: %s", err)
+	}
+
+	return img, nil
+}
+
+// VerifyImageFile checks if given file can be decoded as a complete image.
+func VerifyImageFile(fileName string) error {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	_, err = DecodeImageData(data, -1)
+
+	return err
+}
+
+// SaveImage writes image to disk with given format.
+// Output file is written atomically, see WriteFileAtomic.
+func SaveImage(img image.Image, outputName string, outputFormat string) error {
+	return WriteFileAtomic(outputName, 0o644, func(w io.Writer) error {
+		_, err := EncodeImage(img, w, outputFormat)
+		if err != nil {
+			return fmt.Errorf("failed to save image as %s %s: %s", outputFormat, outputName, err)
+		}
+
+		return nil
+	})
+}
+
 // SaveImageAs treats given byte slice as raw image data, and convert it to given
-// format then saves it to disk.
+// format then saves it to disk. Data is verified with DecodeImageData before
+// anything is written.
+// Output file is written atomically, see WriteFileAtomic.
 func SaveImageAs(data []byte, outputName string, outputFormat string) error {
-	file, err := os.Create(outputName)
+	img, err := DecodeImageData(data, -1)
 	if err != nil {
-		return fmt.Errorf("failed to create output image file %s: %s", outputName, err)
+		return fmt.Errorf("invalid image data for %s: %s", outputName, err)
 	}
-	defer file.Close()
 
-	bufWriter := bufio.NewWriter(file)
-	defer bufWriter.Flush()
+	return SaveDecodedImage(img, data, outputName, outputFormat)
+}
 
-	reader := bytes.NewReader(data)
-	_, err = ConvertImageTo(reader, bufWriter, outputFormat)
-	if err != nil {
-		return fmt.Errorf("failed to save image as %s %s: %s", outputFormat, outputName, err)
+// Maps image format to MIME type of data that should be saved as downloaded
+// instead of being re-encoded. WebP has no encoder, and re-encoding GIF drops
+// all frames but the first one.
+var keepAsIsImageFormats = map[string]string{
+	ImageFormatGif:  "image/gif",
+	ImageFormatWebp: "image/webp",
+}
+
+// SaveDecodedImage saves image decoded from `data` in given format. If `data`
+// is already in GIF or WebP format requested, it's written as is. WebP image
+// can't be saved from data of other formats.
+func SaveDecodedImage(img image.Image, data []byte, outputName string, outputFormat string) error {
+	mimeType, ok := keepAsIsImageFormats[outputFormat]
+	if !ok {
+		return SaveImage(img, outputName, outputFormat)
+	}
+
+	if contentType := http.DetectContentType(data); contentType == mimeType {
+		return WriteBytesAtomic(outputName, data, 0o644)
+	} else if outputFormat == ImageFormatWebp {
+		return fmt.Errorf("can't save %s data as webp %s, no WebP encoder available", contentType, outputName)
 	}
 
-	return nil
+	return SaveImage(img, outputName, outputFormat)
 }
 
 func ConvertBookSrcURLToAbs(tocURL *url.URL, src string) (*url.URL, error) {
@@ -0,0 +1,329 @@
+package database
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/SirZenith/delite/common"
+	"github.com/charmbracelet/log"
+	"github.com/klauspost/compress/zstd"
+	"gorm.io/gorm"
+)
+
+// backupFormatName is written into backup header for identifying backup file.
+const backupFormatName = "delite-database-backup"
+
+// backupFormatVersion is version of backup archive layout.
+const backupFormatVersion = 1
+
+// BackupInfo is header of backup archive.
+//
+// Backup archive is a zstd compressed stream of JSON values. It starts with a
+// BackupInfo object, followed by each table in order of `Tables`. A table
+// begins with a BackupTable object, followed by `Count` records, each record is
+// a JSON object keyed by column names.
+type BackupInfo struct {
+	Format        string        `json:"format"`
+	FormatVersion int           `json:"format_version"`
+	SchemaVersion int           `json:"schema_version"`
+	CreatedAt     time.Time     `json:"created_at"`
+	Tables        []BackupTable `json:"tables"`
+}
+
+// BackupTable is header of one table in backup archive.
+type BackupTable struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// Backup dumps all tables listed by GetTableNames into a compressed archive.
+// Tables are read in a single read transaction, so backup is consistent even
+// if database is being written by other process at the same time.
+// Full-text index of chapter texts is not included, it can be rebuilt from
+// text files after restoring.
+func Backup(db *gorm.DB, outputPath string) (*BackupInfo, error) {
+	version, err := GetSchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &BackupInfo{
+		Format:        backupFormatName,
+		FormatVersion: backupFormatVersion,
+		SchemaVersion: version,
+		CreatedAt:     time.Now(),
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		tableNames := []string{}
+		for _, name := range GetTableNames() {
+			if !tx.Migrator().HasTable(name) {
+				continue
+			}
+
+			var count int64
+			if err := tx.Unscoped().Model(GetModel(name)).Count(&count).Error; err != nil {
+				return fmt.Errorf("failed to count records in %s: %s", name, err)
+			}
+
+			tableNames = append(tableNames, name)
+			info.Tables = append(info.Tables, BackupTable{Name: name, Count: count})
+		}
+
+		return common.WriteFileAtomic(outputPath, 0o644, func(w io.Writer) error {
+			encoder, err := zstd.NewWriter(w)
+			if err != nil {
+				return err
+			}
+
+			writer := bufio.NewWriter(encoder)
+			if err := writeBackupContent(tx, writer, info); err != nil {
+				encoder.Close()
+				return err
+			}
+
+			if err := writer.Flush(); err != nil {
+				encoder.Close()
+				return err
+			}
+
+			return encoder.Close()
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to backup database: %s", err)
+	}
+
+	return info, nil
+}
+
+// writeBackupContent writes backup header and all table records to writer.
+func writeBackupContent(tx *gorm.DB, writer *bufio.Writer, info *BackupInfo) error {
+	header, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	writer.Write(header)
+	writer.WriteString("\n")
+
+	for _, table := range info.Tables {
+		model := GetModel(table.Name)
+		modelSchema, err := parseModelSchema(tx, model)
+		if err != nil {
+			return err
+		}
+
+		tableHeader, _ := json.Marshal(table)
+		writer.Write(tableHeader)
+		writer.WriteString("\n")
+
+		rows, err := tx.Unscoped().Model(model).Rows()
+		if err != nil {
+			return fmt.Errorf("failed to read table %s: %s", table.Name, err)
+		}
+
+		rType := reflect.TypeOf(model).Elem()
+		var count int64
+		for rows.Next() {
+			rValue := reflect.New(rType)
+			if err := tx.ScanRows(rows, rValue.Interface()); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to read record in %s: %s", table.Name, err)
+			}
+
+			data, err := marshalRecord(modelSchema, rValue.Elem())
+			if err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to marshal record in %s: %s", table.Name, err)
+			}
+
+			writer.Write(data)
+			writer.WriteString("\n")
+			count++
+		}
+		rows.Close()
+
+		if count != table.Count {
+			return fmt.Errorf("table %s changed during backup, expecting %d records, got %d", table.Name, table.Count, count)
+		}
+	}
+
+	return nil
+}
+
+// ReadBackupInfo reads header of backup archive.
+func ReadBackupInfo(archivePath string) (*BackupInfo, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup %s: %s", archivePath, err)
+	}
+	defer file.Close()
+
+	decoder, err := zstd.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup %s: %s", archivePath, err)
+	}
+	defer decoder.Close()
+
+	return readBackupHeader(json.NewDecoder(decoder))
+}
+
+func readBackupHeader(decoder *json.Decoder) (*BackupInfo, error) {
+	info := &BackupInfo{}
+	if err := decoder.Decode(info); err != nil {
+		return nil, fmt.Errorf("failed to read backup header: %s", err)
+	}
+
+	if info.Format != backupFormatName {
+		return nil, fmt.Errorf("not a database backup file")
+	}
+
+	if info.FormatVersion > backupFormatVersion {
+		return nil, fmt.Errorf("unsupported backup format version %d", info.FormatVersion)
+	}
+
+	return info, nil
+}
+
+// Restore creates a new database at `dbPath` with content of backup archive.
+// Database file must not exist before restoring. After restoring, record count
+// of each table and database integrity are checked, database file is removed if
+// restoring fails.
+func Restore(archivePath string, dbPath string) (*BackupInfo, error) {
+	if _, err := os.Stat(dbPath); err == nil {
+		return nil, fmt.Errorf("target database %s already exists", dbPath)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to access %s: %s", dbPath, err)
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup %s: %s", archivePath, err)
+	}
+	defer file.Close()
+
+	zstdDecoder, err := zstd.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup %s: %s", archivePath, err)
+	}
+	defer zstdDecoder.Close()
+
+	decoder := json.NewDecoder(zstdDecoder)
+	info, err := readBackupHeader(decoder)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := OpenNoMigrate(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = restoreContent(db, decoder, info)
+	if err == nil {
+		err = checkRestoredDatabase(db, info)
+	}
+
+	closeErr := Close(db)
+
+	if err != nil {
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			os.Remove(dbPath + suffix)
+		}
+		return nil, fmt.Errorf("failed to restore database: %s", err)
+	}
+
+	if closeErr != nil {
+		return nil, closeErr
+	}
+
+	return info, nil
+}
+
+// restoreContent creates schema of backup's version, and inserts all records in
+// backup into database.
+func restoreContent(db *gorm.DB, decoder *json.Decoder, info *BackupInfo) error {
+	targetVersion := info.SchemaVersion
+	if targetVersion == 0 {
+		log.Warnf("backup has no schema version, restoring with latest schema")
+		targetVersion = LatestVersion()
+	}
+
+	if err := MigrateTo(db, targetVersion); err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, table := range info.Tables {
+			header := BackupTable{}
+			if err := decoder.Decode(&header); err != nil {
+				return fmt.Errorf("failed to read table header of %s: %s", table.Name, err)
+			}
+
+			if header.Name != table.Name {
+				return fmt.Errorf("expecting table %s, found %s", table.Name, header.Name)
+			}
+
+			model := GetModel(table.Name)
+			if model == nil {
+				return fmt.Errorf("unknown table %s", table.Name)
+			}
+
+			modelSchema, err := parseModelSchema(tx, model)
+			if err != nil {
+				return err
+			}
+
+			rType := reflect.TypeOf(model).Elem()
+			for i := int64(0); i < header.Count; i++ {
+				raw := json.RawMessage{}
+				if err := decoder.Decode(&raw); err != nil {
+					return fmt.Errorf("failed to read record %d of %s: %s", i+1, table.Name, err)
+				}
+
+				rValue := reflect.New(rType)
+				if err := unmarshalRecord(modelSchema, rValue.Elem(), raw); err != nil {
+					return fmt.Errorf("failed to unmarshal record %d of %s: %s", i+1, table.Name, err)
+				}
+
+				if err := tx.Create(rValue.Interface()).Error; err != nil {
+					return fmt.Errorf("failed to insert record %d of %s: %s", i+1, table.Name, err)
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// checkRestoredDatabase compares record count of each table with backup header,
+// and runs SQLite integrity check.
+func checkRestoredDatabase(db *gorm.DB, info *BackupInfo) error {
+	for _, table := range info.Tables {
+		var count int64
+		if err := db.Unscoped().Model(GetModel(table.Name)).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to count records in %s: %s", table.Name, err)
+		}
+
+		if count != table.Count {
+			return fmt.Errorf("record count mismatch in %s, expecting %d, got %d", table.Name, table.Count, count)
+		}
+	}
+
+	var result string
+	if err := db.Raw("PRAGMA integrity_check").Scan(&result).Error; err != nil {
+		return fmt.Errorf("failed to run integrity check: %s", err)
+	}
+
+	if result != "ok" {
+		return fmt.Errorf("integrity check failed: %s", result)
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/SirZenith/delite/database/data_model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// HasBookTables checks if book, volume and chapter tables exist in database.
+// These tables are created by migration, records are only written when
+// database has been migrated.
+func HasBookTables(db *gorm.DB) bool {
+	migrator := db.Migrator()
+	return migrator.HasTable(&data_model.Book{}) &&
+		migrator.HasTable(&data_model.Volume{}) &&
+		migrator.HasTable(&data_model.Chapter{})
+}
+
+// SaveBook creates book record, or updates title, author and TOC URL of existing
+// record with the same ID.
+func SaveBook(db *gorm.DB, book *data_model.Book) error {
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"updated_at", "title", "author", "toc_url"}),
+	}).Create(book).Error
+	if err != nil {
+		return fmt.Errorf("failed to save book %s: %s", book.ID, err)
+	}
+
+	return nil
+}
+
+// SaveVolume creates volume record, or updates existing record with the same
+// book ID and volume index. ID of saved record is written back to `volume`.
+// Read state of volume is left untouched.
+func SaveVolume(db *gorm.DB, volume *data_model.Volume) error {
+	err := db.Omit("ReadAt").Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "book_id"}, {Name: "vol_index"}},
+		DoUpdates: clause.AssignmentColumns([]string{"updated_at", "part_index", "part_title", "title", "dir_path"}),
+	}).Create(volume).Error
+	if err != nil {
+		return fmt.Errorf("failed to save volume %d of book %s: %s", volume.VolIndex, volume.BookID, err)
+	}
+
+	// ID is not filled when existing record is updated.
+	err = db.Select("id", "created_at").Where("book_id = ? AND vol_index = ?", volume.BookID, volume.VolIndex).Take(volume).Error
+	if err != nil {
+		return fmt.Errorf("failed to read volume %d of book %s: %s", volume.VolIndex, volume.BookID, err)
+	}
+
+	return nil
+}
+
+// SaveChapter creates chapter record, or updates existing record with the same
+// volume ID and chapter index. Read state of chapter is left untouched.
+func SaveChapter(db *gorm.DB, chapter *data_model.Chapter) error {
+	err := db.Omit("ReadAt").Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "volume_id"}, {Name: "chap_index"}},
+		DoUpdates: clause.AssignmentColumns([]string{"updated_at", "book_id", "title", "url", "file_path", "content_hash"}),
+	}).Create(chapter).Error
+	if err != nil {
+		return fmt.Errorf("failed to save chapter %d of volume %d: %s", chapter.ChapIndex, chapter.VolumeID, err)
+	}
+
+	return nil
+}
+
+// FindChapterByURL returns chapter of book with given URL, nil is returned if
+// no record is found.
+func FindChapterByURL(db *gorm.DB, bookID, url string) *data_model.Chapter {
+	chapter := &data_model.Chapter{}
+	db.Limit(1).Find(chapter, "book_id = ? AND url = ?", bookID, url)
+	if chapter.ID == 0 {
+		return nil
+	}
+
+	return chapter
+}
+
+// GetVolumeTitle returns title of volume whose directory is at given path
+// relative to book's raw directory.
+func GetVolumeTitle(db *gorm.DB, bookID, dirPath string) (string, bool) {
+	volume := data_model.Volume{}
+	db.Limit(1).Find(&volume, "book_id = ? AND dir_path = ?", bookID, filepath.ToSlash(dirPath))
+	if volume.ID == 0 {
+		return "", false
+	}
+
+	return volume.Title, true
+}
+
+// GetChapterTitle returns title of chapter whose file is at given path relative
+// to book's raw directory.
+func GetChapterTitle(db *gorm.DB, bookID, filePath string) (string, bool) {
+	chapter := data_model.Chapter{}
+	db.Limit(1).Find(&chapter, "book_id = ? AND file_path = ?", bookID, filepath.ToSlash(filePath))
+	if chapter.ID == 0 {
+		return "", false
+	}
+
+	return chapter.Title, true
+}
+
+// HashFile returns SHA-256 of file content in hex.
+func HashFile(fileName string) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -3,6 +3,7 @@ package database
 import (
 	"encoding/csv"
 	"fmt"
+	"io"
 	"os"
 	"reflect"
 	"strconv"
@@ -239,7 +240,9 @@ func ExportCSV(db *gorm.DB, model any, csvFilePath string) error {
 	return nil
 }
 
-func ImportCSV(db *gorm.DB, model data_model.DataModel, csvFilePath string) error {
+// readCSVRecords reads records from CSV file, and calls `handle` with each of
+// them. Record passed to `handle` is a newly allocated value of model type.
+func readCSVRecords(model data_model.DataModel, csvFilePath string, handle func(index int, record data_model.DataModel) error) error {
 	file, err := os.Open(csvFilePath)
 	if err != nil {
 		return fmt.Errorf("failed to open CSV file %s: %s", csvFilePath, err)
@@ -270,6 +273,8 @@ func ImportCSV(db *gorm.DB, model data_model.DataModel, csvFilePath string) erro
 		targetFields = append(targetFields, field)
 	}
 
+	modelValue := reflect.ValueOf(model).Elem()
+
 	index := 2
 	line, err := csvReader.Read()
 	for err == nil {
@@ -280,11 +285,19 @@ func ImportCSV(db *gorm.DB, model data_model.DataModel, csvFilePath string) erro
 			}
 		}
 
-		model.Upsert(db)
+		record := reflect.New(modelValue.Type())
+		record.Elem().Set(modelValue)
+		if err := handle(index, record.Interface().(data_model.DataModel)); err != nil {
+			return fmt.Errorf("line %d: %s", index, err)
+		}
 
 		line, err = csvReader.Read()
 		index++
 	}
 
+	if err != io.EOF {
+		return fmt.Errorf("failed to read line %d: %s", index, err)
+	}
+
 	return nil
 }
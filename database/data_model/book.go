@@ -0,0 +1,85 @@
+package data_model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Book is a book in library, linked to entry in library info file by ID.
+type Book struct {
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	ID     string `gorm:"primaryKey"` // book ID in library info file
+	Title  string
+	Author string
+	TocURL string
+}
+
+func (entry *Book) Upsert(db *gorm.DB) {
+	db.Clauses(
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "id"}},
+			UpdateAll: true,
+		},
+	).Create(entry)
+}
+
+// Volume is a downloaded volume of book. Volume is identified by book ID and
+// volume index.
+type Volume struct {
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	ID     uint   `gorm:"primaryKey"`
+	BookID string `gorm:"uniqueIndex:idx_volume_book_index;not null"`
+
+	PartIndex int // 0 when book has no part level
+	PartTitle string
+	VolIndex  int `gorm:"uniqueIndex:idx_volume_book_index"` // volume index counted across the whole book
+	Title     string
+
+	DirPath string // path of volume directory relative to book's raw directory
+
+	ReadAt *time.Time // time when volume is marked as read, nil if unread
+}
+
+func (entry *Volume) Upsert(db *gorm.DB) {
+	db.Clauses(
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "id"}},
+			UpdateAll: true,
+		},
+	).Create(entry)
+}
+
+// Chapter is a downloaded chapter of volume. Chapter is identified by volume ID
+// and chapter index.
+type Chapter struct {
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	ID       uint   `gorm:"primaryKey"`
+	BookID   string `gorm:"index;not null"`
+	VolumeID uint   `gorm:"uniqueIndex:idx_chapter_volume_index;not null"`
+
+	ChapIndex int `gorm:"uniqueIndex:idx_chapter_volume_index"`
+	Title     string
+	URL       string `gorm:"index"` // URL of the first page of chapter
+
+	FilePath    string `gorm:"index"` // path of chapter file relative to book's raw directory
+	ContentHash string // SHA-256 of chapter file content, in hex
+
+	ReadAt *time.Time // time when chapter is marked as read, nil if unread
+}
+
+func (entry *Chapter) Upsert(db *gorm.DB) {
+	db.Clauses(
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "id"}},
+			UpdateAll: true,
+		},
+	).Create(entry)
+}
@@ -0,0 +1,32 @@
+package data_model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ChapterText is plain text of a decyphered chapter file, used by full-text
+// search. Its ID is used as row ID in full-text index table.
+type ChapterText struct {
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	ID       uint   `gorm:"primaryKey"`
+	BookID   string `gorm:"uniqueIndex:idx_chapter_text_file;not null"`
+	FilePath string `gorm:"uniqueIndex:idx_chapter_text_file"` // path of chapter file relative to book's text directory
+
+	Book        string // book title at the time of indexing
+	ContentHash string // SHA-256 of chapter file content, in hex
+	Content     string
+}
+
+func (entry *ChapterText) Upsert(db *gorm.DB) {
+	db.Clauses(
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "id"}},
+			UpdateAll: true,
+		},
+	).Create(entry)
+}
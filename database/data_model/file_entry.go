@@ -14,6 +14,7 @@ type FileEntry struct {
 
 	URL      string `gorm:"primaryKey"`
 	Book     string
+	Part     string // title of part volume belongs to, empty if book has no part level
 	Volume   string
 	FileName string
 }
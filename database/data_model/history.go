@@ -0,0 +1,37 @@
+package data_model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// HistoryEntry is a record of one download, decypher or bundle run on a book.
+type HistoryEntry struct {
+	ID        uint      `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"index"` // time when operation started
+
+	Command string
+	BookID  string `gorm:"index"`
+	Book    string // book title at the time of operation
+	Volume  string // volume selected by operation, empty for the whole book
+
+	Processed int // number of items successfully processed
+	Failed    int // number of failures
+	Duration  time.Duration
+	Changes   string // paths of files written by operation, one per line
+}
+
+func (HistoryEntry) TableName() string {
+	return "history"
+}
+
+func (entry *HistoryEntry) Upsert(db *gorm.DB) {
+	db.Clauses(
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "id"}},
+			UpdateAll: true,
+		},
+	).Create(entry)
+}
@@ -0,0 +1,33 @@
+package data_model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// HTTPValidator records cache validators returned by server for a URL, they
+// are sent back with later request to the same URL as conditional request.
+type HTTPValidator struct {
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	URL          string `gorm:"primaryKey"`
+	ETag         string // value of `ETag` header
+	LastModified string // value of `Last-Modified` header
+}
+
+func (entry *HTTPValidator) Upsert(db *gorm.DB) {
+	db.Clauses(
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "url"}},
+			UpdateAll: true,
+		},
+	).Create(entry)
+}
+
+// IsEmpty checks if there is no validator recorded in this entry.
+func (entry *HTTPValidator) IsEmpty() bool {
+	return entry.ETag == "" && entry.LastModified == ""
+}
@@ -12,7 +12,27 @@ import (
 	"gorm.io/gorm/logger"
 )
 
+// Open connects to database file and brings its schema up to date. Pending
+// migrations are applied automatically unless one of them is destructive, in
+// which case an error asking user to run `database migrate` is returned.
 func Open(filePath string) (*gorm.DB, error) {
+	db, err := OpenNoMigrate(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := MigrateNonDestructive(db); err != nil {
+		Close(db)
+		return nil, fmt.Errorf("failed to open database %s: %s", filePath, err)
+	}
+
+	return db, nil
+}
+
+// OpenNoMigrate connects to database file without touching its schema. This is
+// meant for tools that manage schema themselves, such as migration, backup and
+// restore.
+func OpenNoMigrate(filePath string) (*gorm.DB, error) {
 	newLogger := logger.New(
 		log.New(os.Stderr, "\r\n", log.LstdFlags),
 		logger.Config{
@@ -56,19 +76,35 @@ func LimitConnection(db *gorm.DB, num int) error {
 	return nil
 }
 
-func Migrate(db *gorm.DB) error {
-	return db.AutoMigrate(
-		&data_model.FileEntry{},
-		&data_model.TaggedPostEntry{},
-	)
+// GetTableNames returns names of all tables that can be accessed with GetModel.
+func GetTableNames() []string {
+	return []string{
+		"books",
+		"chapters",
+		"file_entries",
+		"history",
+		"http_validators",
+		"tagged_post_entries",
+		"volumes",
+	}
 }
 
 func GetModel(tableName string) data_model.DataModel {
 	switch tableName {
+	case "books":
+		return &data_model.Book{}
+	case "chapters":
+		return &data_model.Chapter{}
 	case "file_entries":
 		return &data_model.FileEntry{}
+	case "history":
+		return &data_model.HistoryEntry{}
+	case "http_validators":
+		return &data_model.HTTPValidator{}
 	case "tagged_post_entries":
 		return &data_model.TaggedPostEntry{}
+	case "volumes":
+		return &data_model.Volume{}
 	default:
 		return nil
 	}
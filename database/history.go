@@ -0,0 +1,117 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SirZenith/delite/database/data_model"
+	"gorm.io/gorm"
+)
+
+const (
+	HistoryCommandDownload   = "download"
+	HistoryCommandDecypher   = "decypher"
+	HistoryCommandBundleEpub = "bundle-epub"
+	HistoryCommandBundleHTML = "bundle-html"
+	HistoryCommandBundleZip  = "bundle-zip"
+)
+
+// HasHistoryTable checks if history table exists in database. The table is
+// created by migration.
+func HasHistoryTable(db *gorm.DB) bool {
+	return db.Migrator().HasTable(&data_model.HistoryEntry{})
+}
+
+// HistoryRun collects statistics of one operation on a book, and writes them to
+// history table when operation finishes. All methods are safe to be called
+// concurrently, and do nothing on nil value.
+type HistoryRun struct {
+	lock    sync.Mutex
+	entry   data_model.HistoryEntry
+	changes []string
+}
+
+// NewHistoryRun starts recording an operation. `volume` is the volume selected
+// by operation, empty for the whole book.
+func NewHistoryRun(command, bookID, book, volume string) *HistoryRun {
+	return &HistoryRun{
+		entry: data_model.HistoryEntry{
+			CreatedAt: time.Now(),
+			Command:   command,
+			BookID:    bookID,
+			Book:      book,
+			Volume:    volume,
+		},
+	}
+}
+
+// AddProcessed counts a successfully processed item, path of file written for
+// it is recorded when not empty.
+func (r *HistoryRun) AddProcessed(changedFile string) {
+	if r == nil {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.entry.Processed++
+	if changedFile != "" {
+		r.changes = append(r.changes, changedFile)
+	}
+}
+
+// AddFailed counts a failure.
+func (r *HistoryRun) AddFailed() {
+	if r == nil {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.entry.Failed++
+}
+
+// Save writes operation record to history table. Nothing is written if `db` is
+// nil or database has no history table.
+func (r *HistoryRun) Save(db *gorm.DB) error {
+	if r == nil || db == nil || !HasHistoryTable(db) {
+		return nil
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	entry := r.entry
+	entry.Duration = time.Since(entry.CreatedAt)
+	entry.Changes = strings.Join(r.changes, "\n")
+
+	if err := db.Create(&entry).Error; err != nil {
+		return fmt.Errorf("failed to save history record: %s", err)
+	}
+
+	return nil
+}
+
+// GetHistory returns recent history records, newest first. When `bookIDs` or
+// `titles` is not empty, only records of books with given ID or title are
+// returned, titles are used for records written before book gets its ID.
+func GetHistory(db *gorm.DB, bookIDs []string, titles []string, limit int) ([]data_model.HistoryEntry, error) {
+	tx := db.Order("created_at DESC").Order("id DESC")
+	if len(bookIDs) > 0 || len(titles) > 0 {
+		tx = tx.Where(db.Where("book_id IN ?", bookIDs).Or("book_id = '' AND book IN ?", titles))
+	}
+	if limit > 0 {
+		tx = tx.Limit(limit)
+	}
+
+	entries := []data_model.HistoryEntry{}
+	if err := tx.Find(&entries).Error; err != nil {
+		return nil, fmt.Errorf("failed to read history: %s", err)
+	}
+
+	return entries, nil
+}
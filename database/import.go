@@ -0,0 +1,379 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/SirZenith/delite/database/data_model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	ConflictSkip   = "skip"   // keep existing record
+	ConflictUpdate = "update" // overwrite existing record with imported one
+	ConflictError  = "error"  // abort import when imported record differs from existing one
+)
+
+var AllConflictPolicies = []string{ConflictSkip, ConflictUpdate, ConflictError}
+
+// ImportOptions controls how imported records are written to database.
+type ImportOptions struct {
+	OnConflict string // one of conflict policies, defaults to skip
+	DryRun     bool   // when true, nothing is written to database
+}
+
+// FieldChange is the difference of one column between existing record and
+// imported record.
+type FieldChange struct {
+	Column string
+	Old    string
+	New    string
+}
+
+// RecordDiff describes how an imported record changes database.
+type RecordDiff struct {
+	Key       string // primary key of record, in form of `column=value`
+	IsNew     bool
+	IsSkipped bool          // record conflicts with existing one, but is not written
+	Changes   []FieldChange // changed columns of existing record, empty for new record
+}
+
+// ImportReport summarizes result of an import.
+type ImportReport struct {
+	Inserted  int
+	Updated   int
+	Unchanged int
+	Skipped   int // records conflicting with existing ones but left untouched
+
+	Diffs []RecordDiff
+}
+
+// ImportFile reads records in given format from file, and writes them into
+// table of given model according to options. All records are written in a
+// single transaction, so nothing is imported if any record fails.
+func ImportFile(db *gorm.DB, model data_model.DataModel, fileName string, format string, options ImportOptions) (*ImportReport, error) {
+	importer, err := newRecordImporter(db, model, options)
+	if err != nil {
+		return nil, err
+	}
+
+	doImport := func(tx *gorm.DB) error {
+		importer.db = tx
+
+		switch format {
+		case FormatCSV:
+			return readCSVRecords(model, fileName, importer.handle)
+		case FormatJSON, FormatJSONL:
+			return readJSONRecords(tx, model, fileName, importer.handle)
+		default:
+			return fmt.Errorf("unsupported format %q", format)
+		}
+	}
+
+	if options.DryRun {
+		err = doImport(db)
+	} else {
+		err = db.Transaction(doImport)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return importer.report, nil
+}
+
+// MergeTable copies all records in table of given model from `src` database
+// into `dst`, soft deleted records included. Conflicts are handled the same way
+// as ImportFile. `transform` is called on each record before it's written,
+// when not nil.
+func MergeTable(dst, src *gorm.DB, model data_model.DataModel, options ImportOptions, transform func(record data_model.DataModel)) (*ImportReport, error) {
+	importer, err := newRecordImporter(dst, model, options)
+	if err != nil {
+		return nil, err
+	}
+
+	doMerge := func(tx *gorm.DB) error {
+		importer.db = tx
+
+		rows, err := src.Unscoped().Model(model).Rows()
+		if err != nil {
+			return fmt.Errorf("failed to read source table: %s", err)
+		}
+		defer rows.Close()
+
+		rType := reflect.TypeOf(model).Elem()
+		for index := 1; rows.Next(); index++ {
+			rValue := reflect.New(rType)
+			if err := src.ScanRows(rows, rValue.Interface()); err != nil {
+				return fmt.Errorf("failed to read record %d: %s", index, err)
+			}
+
+			record := rValue.Interface().(data_model.DataModel)
+			if transform != nil {
+				transform(record)
+			}
+
+			if err := importer.handle(index, record); err != nil {
+				return fmt.Errorf("record %d: %s", index, err)
+			}
+		}
+
+		return rows.Err()
+	}
+
+	if options.DryRun {
+		err = doMerge(dst)
+	} else {
+		err = dst.Transaction(doMerge)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return importer.report, nil
+}
+
+// recordImporter writes imported records to database one by one.
+type recordImporter struct {
+	db          *gorm.DB
+	modelSchema *schema.Schema
+	onConflict  string
+	dryRun      bool
+	report      *ImportReport
+}
+
+func newRecordImporter(db *gorm.DB, model data_model.DataModel, options ImportOptions) (*recordImporter, error) {
+	onConflict := options.OnConflict
+	switch onConflict {
+	case "":
+		onConflict = ConflictSkip
+	case ConflictSkip, ConflictUpdate, ConflictError:
+	default:
+		return nil, fmt.Errorf("invalid conflict policy %q", onConflict)
+	}
+
+	modelSchema, err := parseModelSchema(db, model)
+	if err != nil {
+		return nil, err
+	}
+
+	return &recordImporter{
+		db:          db,
+		modelSchema: modelSchema,
+		onConflict:  onConflict,
+		dryRun:      options.DryRun,
+		report:      &ImportReport{},
+	}, nil
+}
+
+func (im *recordImporter) handle(_ int, record data_model.DataModel) error {
+	rValue := reflect.ValueOf(record).Elem()
+
+	existing, byPrimaryKey, err := im.findExisting(rValue)
+	if err != nil {
+		return err
+	}
+
+	if existing == nil {
+		im.report.Inserted++
+		im.report.Diffs = append(im.report.Diffs, RecordDiff{
+			Key:   im.formatKey(rValue),
+			IsNew: true,
+		})
+
+		if im.dryRun {
+			return nil
+		}
+
+		if err := im.db.Create(record).Error; err != nil {
+			return fmt.Errorf("failed to insert record %s: %s", im.formatKey(rValue), err)
+		}
+
+		return nil
+	}
+
+	existingValue := existing.Elem()
+	if !byPrimaryKey {
+		// record is matched by unique index, primary key of existing record is
+		// kept so that references to it stay valid.
+		for _, field := range im.modelSchema.PrimaryFields {
+			field.ReflectValueOf(context.Background(), rValue).Set(field.ReflectValueOf(context.Background(), existingValue))
+		}
+	}
+
+	changes := im.diffRecord(existingValue, rValue)
+	if len(changes) == 0 {
+		im.report.Unchanged++
+		return nil
+	}
+
+	switch im.onConflict {
+	case ConflictSkip:
+		im.report.Skipped++
+		im.report.Diffs = append(im.report.Diffs, RecordDiff{
+			Key:       im.formatKey(rValue),
+			IsSkipped: true,
+			Changes:   changes,
+		})
+		return nil
+	case ConflictError:
+		return fmt.Errorf("record %s conflicts with existing one: %s", im.formatKey(rValue), formatChanges(changes))
+	}
+
+	im.report.Updated++
+	im.report.Diffs = append(im.report.Diffs, RecordDiff{
+		Key:     im.formatKey(rValue),
+		Changes: changes,
+	})
+
+	if im.dryRun {
+		return nil
+	}
+
+	columns := make([]string, 0, len(changes))
+	for _, change := range changes {
+		columns = append(columns, change.Column)
+	}
+
+	err = im.db.Unscoped().Model(record).Select(columns).Updates(record).Error
+	if err != nil {
+		return fmt.Errorf("failed to update record %s: %s", im.formatKey(rValue), err)
+	}
+
+	return nil
+}
+
+// findExisting looks up record in database that conflicts with given record,
+// either by primary key or by any unique index. Soft deleted records are also
+// looked up. The second return value tells if record is found by primary key.
+func (im *recordImporter) findExisting(rValue reflect.Value) (*reflect.Value, bool, error) {
+	ctx := context.Background()
+
+	keySets := [][]*schema.Field{}
+	if len(im.modelSchema.PrimaryFields) > 0 {
+		keySets = append(keySets, im.modelSchema.PrimaryFields)
+	}
+
+	indexes := im.modelSchema.ParseIndexes()
+	names := make([]string, 0, len(indexes))
+	for name := range indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		index := indexes[name]
+		if index.Class != "UNIQUE" {
+			continue
+		}
+
+		fields := []*schema.Field{}
+		for _, option := range index.Fields {
+			fields = append(fields, option.Field)
+		}
+		keySets = append(keySets, fields)
+	}
+
+	for i, fields := range keySets {
+		conditions := map[string]any{}
+		isZero := true
+		for _, field := range fields {
+			value, zero := field.ValueOf(ctx, rValue)
+			conditions[field.DBName] = value
+			isZero = isZero && zero
+		}
+
+		// auto increment primary key left empty, record is to be inserted
+		if isZero && i == 0 {
+			continue
+		}
+
+		existing := reflect.New(rValue.Type())
+		result := im.db.Unscoped().Where(conditions).Limit(1).Find(existing.Interface())
+		if result.Error != nil {
+			return nil, false, fmt.Errorf("failed to look up existing record: %s", result.Error)
+		}
+
+		if result.RowsAffected > 0 {
+			return &existing, i == 0 && len(im.modelSchema.PrimaryFields) > 0, nil
+		}
+	}
+
+	return nil, false, nil
+}
+
+// diffRecord returns columns whose value differs between two records. Columns
+// maintained by database automatically are not compared.
+func (im *recordImporter) diffRecord(oldValue, newValue reflect.Value) []FieldChange {
+	ctx := context.Background()
+	changes := []FieldChange{}
+
+	for _, field := range im.modelSchema.Fields {
+		if field.DBName == "" || field.AutoCreateTime > 0 || field.AutoUpdateTime > 0 {
+			continue
+		}
+
+		oldText := formatFieldValue(field.ReflectValueOf(ctx, oldValue).Interface())
+		newText := formatFieldValue(field.ReflectValueOf(ctx, newValue).Interface())
+		if oldText != newText {
+			changes = append(changes, FieldChange{
+				Column: field.DBName,
+				Old:    oldText,
+				New:    newText,
+			})
+		}
+	}
+
+	return changes
+}
+
+// formatKey returns primary key of record in form of `column=value`.
+func (im *recordImporter) formatKey(rValue reflect.Value) string {
+	ctx := context.Background()
+
+	parts := []string{}
+	for _, field := range im.modelSchema.PrimaryFields {
+		parts = append(parts, field.DBName+"="+formatFieldValue(field.ReflectValueOf(ctx, rValue).Interface()))
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// formatFieldValue converts column value into text for comparing and printing.
+func formatFieldValue(value any) string {
+	switch v := value.(type) {
+	case time.Time:
+		if v.IsZero() {
+			return "NULL"
+		}
+		return v.UTC().Format(time.RFC3339Nano)
+	case *time.Time:
+		if v == nil {
+			return "NULL"
+		}
+		return formatFieldValue(*v)
+	case gorm.DeletedAt:
+		if !v.Valid {
+			return "NULL"
+		}
+		return formatFieldValue(v.Time)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func formatChanges(changes []FieldChange) string {
+	parts := make([]string, 0, len(changes))
+	for _, change := range changes {
+		parts = append(parts, fmt.Sprintf("%s: %q -> %q", change.Column, change.Old, change.New))
+	}
+
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,220 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/SirZenith/delite/database/data_model"
+	"gorm.io/gorm"
+)
+
+// writeTestFile writes content to a file in temporary directory, and returns
+// its path.
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	fileName := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(fileName, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return fileName
+}
+
+// formatDiffs converts diffs into text of form `<kind> <key> <changed columns>`
+// for comparing.
+func formatDiffs(diffs []RecordDiff) []string {
+	result := []string{}
+	for _, diff := range diffs {
+		kind := "update"
+		if diff.IsNew {
+			kind = "new"
+		} else if diff.IsSkipped {
+			kind = "skip"
+		}
+
+		text := kind + " " + diff.Key
+		for _, change := range diff.Changes {
+			text += " " + change.Column + ":" + change.Old + "->" + change.New
+		}
+		result = append(result, text)
+	}
+
+	return result
+}
+
+// getFileNames returns file name of each file entry, keyed by URL.
+func getFileNames(t *testing.T, db *gorm.DB) map[string]string {
+	t.Helper()
+
+	entries := []data_model.FileEntry{}
+	if err := db.Find(&entries).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	result := map[string]string{}
+	for _, entry := range entries {
+		result[entry.URL] = entry.FileName
+	}
+
+	return result
+}
+
+func TestImportFile(t *testing.T) {
+	content := `{"url": "a", "book": "A", "file_name": "1.png"}
+{"url": "b", "book": "B", "file_name": "3.png"}
+{"url": "c", "book": "C", "file_name": "4.png"}
+`
+	original := map[string]string{"a": "1.png", "b": "2.png"}
+
+	type counts struct{ inserted, updated, unchanged, skipped int }
+
+	cases := []struct {
+		name       string
+		onConflict string
+		dryRun     bool
+		wantErr    bool
+		wantCounts counts
+		wantDiffs  []string
+		wantFiles  map[string]string
+	}{
+		{
+			name:       "default policy",
+			wantCounts: counts{1, 0, 1, 1},
+			wantDiffs:  []string{"skip url=b file_name:2.png->3.png", "new url=c"},
+			wantFiles:  map[string]string{"a": "1.png", "b": "2.png", "c": "4.png"},
+		},
+		{
+			name:       "skip",
+			onConflict: ConflictSkip,
+			wantCounts: counts{1, 0, 1, 1},
+			wantDiffs:  []string{"skip url=b file_name:2.png->3.png", "new url=c"},
+			wantFiles:  map[string]string{"a": "1.png", "b": "2.png", "c": "4.png"},
+		},
+		{
+			name:       "update",
+			onConflict: ConflictUpdate,
+			wantCounts: counts{1, 1, 1, 0},
+			wantDiffs:  []string{"update url=b file_name:2.png->3.png", "new url=c"},
+			wantFiles:  map[string]string{"a": "1.png", "b": "3.png", "c": "4.png"},
+		},
+		{
+			name:       "error",
+			onConflict: ConflictError,
+			wantErr:    true,
+			wantFiles:  original,
+		},
+		{
+			name:       "invalid policy",
+			onConflict: "overwrite",
+			wantErr:    true,
+			wantFiles:  original,
+		},
+		{
+			name:       "dry-run skip",
+			onConflict: ConflictSkip,
+			dryRun:     true,
+			wantCounts: counts{1, 0, 1, 1},
+			wantDiffs:  []string{"skip url=b file_name:2.png->3.png", "new url=c"},
+			wantFiles:  original,
+		},
+		{
+			name:       "dry-run update",
+			onConflict: ConflictUpdate,
+			dryRun:     true,
+			wantCounts: counts{1, 1, 1, 0},
+			wantDiffs:  []string{"update url=b file_name:2.png->3.png", "new url=c"},
+			wantFiles:  original,
+		},
+		{
+			name:       "dry-run error",
+			onConflict: ConflictError,
+			dryRun:     true,
+			wantErr:    true,
+			wantFiles:  original,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db := openTestDatabase(t)
+			if err := MigrateTo(db, LatestVersion()); err != nil {
+				t.Fatal(err)
+			}
+
+			for url, fileName := range original {
+				if err := db.Create(&data_model.FileEntry{URL: url, Book: strings.ToUpper(url), FileName: fileName}).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			fileName := writeTestFile(t, "records.jsonl", content)
+			report, err := ImportFile(db, &data_model.FileEntry{}, fileName, FormatJSONL, ImportOptions{
+				OnConflict: c.onConflict,
+				DryRun:     c.dryRun,
+			})
+
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, c.wantErr)
+			}
+
+			if err == nil {
+				got := counts{report.Inserted, report.Updated, report.Unchanged, report.Skipped}
+				if got != c.wantCounts {
+					t.Errorf("got counts %+v, want %+v", got, c.wantCounts)
+				}
+
+				if diffs := formatDiffs(report.Diffs); !slices.Equal(diffs, c.wantDiffs) {
+					t.Errorf("got diffs %q, want %q", diffs, c.wantDiffs)
+				}
+			}
+
+			files := getFileNames(t, db)
+			if len(files) != len(c.wantFiles) {
+				t.Errorf("got records %v, want %v", files, c.wantFiles)
+			}
+			for url, want := range c.wantFiles {
+				if files[url] != want {
+					t.Errorf("record %s: got file name %q, want %q", url, files[url], want)
+				}
+			}
+		})
+	}
+}
+
+func TestImportFileMatchesUniqueIndex(t *testing.T) {
+	db := openTestDatabase(t)
+	if err := MigrateTo(db, LatestVersion()); err != nil {
+		t.Fatal(err)
+	}
+
+	volume := data_model.Volume{BookID: "book", VolIndex: 1, Title: "old"}
+	if err := SaveVolume(db, &volume); err != nil {
+		t.Fatal(err)
+	}
+
+	// imported record has no ID, it's matched by book ID and volume index
+	fileName := writeTestFile(t, "volumes.json", `[{"book_id": "book", "vol_index": 1, "title": "new"}]`)
+	report, err := ImportFile(db, &data_model.Volume{}, fileName, FormatJSON, ImportOptions{OnConflict: ConflictUpdate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Inserted != 0 || report.Updated != 1 {
+		t.Errorf("got %d inserted, %d updated, want 0 inserted, 1 updated", report.Inserted, report.Updated)
+	}
+
+	volumes, err := GetVolumes(db, "book")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 1 {
+		t.Fatalf("got %d volumes, want 1", len(volumes))
+	}
+	if volumes[0].ID != volume.ID || volumes[0].Title != "new" {
+		t.Errorf("got volume %d %q, want %d %q", volumes[0].ID, volumes[0].Title, volume.ID, "new")
+	}
+}
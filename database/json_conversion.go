@@ -0,0 +1,241 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/SirZenith/delite/common"
+	"github.com/SirZenith/delite/database/data_model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	FormatCSV   = "csv"
+	FormatJSON  = "json"  // a JSON array of records
+	FormatJSONL = "jsonl" // one JSON record per line
+)
+
+var AllFormats = []string{FormatCSV, FormatJSON, FormatJSONL}
+
+// GetFormatByFileName guesses data format by file extension, CSV is used for
+// unknown extensions.
+func GetFormatByFileName(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".json":
+		return FormatJSON
+	case ".jsonl", ".ndjson":
+		return FormatJSONL
+	default:
+		return FormatCSV
+	}
+}
+
+// ExportFile writes all records in table of given model to file in given
+// format.
+func ExportFile(db *gorm.DB, model data_model.DataModel, fileName string, format string) error {
+	switch format {
+	case FormatCSV:
+		return ExportCSV(db, model, fileName)
+	case FormatJSON, FormatJSONL:
+		return ExportJSON(db, model, fileName, format == FormatJSONL)
+	default:
+		return fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+// parseModelSchema returns gorm schema of given model.
+func parseModelSchema(db *gorm.DB, model any) (*schema.Schema, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, fmt.Errorf("failed to parse model schema: %s", err)
+	}
+
+	return stmt.Schema, nil
+}
+
+// marshalRecord converts a model value to JSON object, with column names as
+// keys in order of schema fields.
+func marshalRecord(modelSchema *schema.Schema, rValue reflect.Value) ([]byte, error) {
+	ctx := context.Background()
+
+	buffer := bytes.NewBuffer(nil)
+	buffer.WriteByte('{')
+
+	isFirst := true
+	for _, field := range modelSchema.Fields {
+		if field.DBName == "" {
+			continue
+		}
+
+		key, _ := json.Marshal(field.DBName)
+		value, err := json.Marshal(field.ReflectValueOf(ctx, rValue).Interface())
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal field %s: %s", field.DBName, err)
+		}
+
+		if !isFirst {
+			buffer.WriteByte(',')
+		}
+		isFirst = false
+
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+	}
+
+	buffer.WriteByte('}')
+
+	return buffer.Bytes(), nil
+}
+
+// unmarshalRecord fills model value with JSON object keyed by column names.
+// Field name of model struct is also accepted as key.
+func unmarshalRecord(modelSchema *schema.Schema, rValue reflect.Value, data []byte) error {
+	ctx := context.Background()
+
+	record := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+
+	for key, raw := range record {
+		field := modelSchema.LookUpField(key)
+		if field == nil {
+			return fmt.Errorf("invalid field name %s", key)
+		}
+
+		target := field.ReflectValueOf(ctx, rValue).Addr().Interface()
+		if err := json.Unmarshal(raw, target); err != nil {
+			return fmt.Errorf("invalid value for field %s: %s", key, err)
+		}
+	}
+
+	return nil
+}
+
+// ExportJSON writes all records in table of given model to file as JSON, soft
+// deleted records are included. If `isLines` is true, each record is written
+// as a single line, else records are written as a JSON array.
+func ExportJSON(db *gorm.DB, model any, fileName string, isLines bool) error {
+	modelSchema, err := parseModelSchema(db, model)
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.Unscoped().Model(model).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	return common.WriteFileAtomic(fileName, 0o644, func(w io.Writer) error {
+		writer := bufio.NewWriter(w)
+
+		if !isLines {
+			writer.WriteString("[")
+		}
+
+		rType := reflect.TypeOf(model).Elem()
+		index := 0
+		for rows.Next() {
+			rValue := reflect.New(rType)
+			if err := db.ScanRows(rows, rValue.Interface()); err != nil {
+				return fmt.Errorf("failed to read record %d: %s", index+1, err)
+			}
+
+			data, err := marshalRecord(modelSchema, rValue.Elem())
+			if err != nil {
+				return fmt.Errorf("failed to marshal record %d: %s", index+1, err)
+			}
+
+			if isLines {
+				writer.Write(data)
+				writer.WriteString("\n")
+			} else {
+				if index > 0 {
+					writer.WriteString(",")
+				}
+				writer.WriteString("\n  ")
+				writer.Write(data)
+			}
+
+			index++
+		}
+
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		if !isLines {
+			writer.WriteString("\n]\n")
+		}
+
+		return writer.Flush()
+	})
+}
+
+// readJSONRecords reads records from JSON file, and calls `handle` with each
+// of them. File content can either be a JSON array of records, or one record
+// per line.
+func readJSONRecords(db *gorm.DB, model data_model.DataModel, fileName string, handle func(index int, record data_model.DataModel) error) error {
+	modelSchema, err := parseModelSchema(db, model)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return fmt.Errorf("failed to open JSON file %s: %s", fileName, err)
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+
+	// records in array are decoded one by one, so does JSONL content
+	isArray := false
+	if token, err := decoder.Token(); err == nil && token == json.Delim('[') {
+		isArray = true
+	} else if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read %s: %s", fileName, err)
+	} else if err == nil {
+		// first token of JSONL content is consumed, re-open file and start over.
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to read %s: %s", fileName, err)
+		}
+		decoder = json.NewDecoder(bufio.NewReader(file))
+	}
+
+	rType := reflect.TypeOf(model).Elem()
+	for index := 1; decoder.More(); index++ {
+		raw := json.RawMessage{}
+		if err := decoder.Decode(&raw); err != nil {
+			return fmt.Errorf("failed to read record %d: %s", index, err)
+		}
+
+		rValue := reflect.New(rType)
+		if err := unmarshalRecord(modelSchema, rValue.Elem(), raw); err != nil {
+			return fmt.Errorf("failed to unmarshal record %d: %s", index, err)
+		}
+
+		if err := handle(index, rValue.Interface().(data_model.DataModel)); err != nil {
+			return fmt.Errorf("record %d: %s", index, err)
+		}
+	}
+
+	if isArray {
+		if _, err := decoder.Token(); err != nil {
+			return fmt.Errorf("failed to read end of array in %s: %s", fileName, err)
+		}
+	}
+
+	return nil
+}
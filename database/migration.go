@@ -0,0 +1,277 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"gorm.io/gorm"
+)
+
+// Migration is one numbered step of schema change. Migrations are applied in
+// order of version, each inside its own transaction.
+type Migration struct {
+	Version int
+	Name    string
+
+	// Destructive marks migration that drops or rewrites existing data. Such
+	// migration is never applied when opening database, user has to run
+	// `database migrate` for it, and a backup of database is taken before
+	// applying it.
+	Destructive bool
+
+	Up func(tx *gorm.DB) error
+}
+
+// SchemaMigration records a migration applied to database.
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// MigrationState is migration info along with its applying state in a database.
+type MigrationState struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// LatestVersion returns version of the newest migration.
+func LatestVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+
+	return migrations[len(migrations)-1].Version
+}
+
+// Migrate applies all pending migrations to database.
+func Migrate(db *gorm.DB) error {
+	return MigrateTo(db, LatestVersion())
+}
+
+// MigrateTo applies pending migrations with version not greater than `target`.
+// Rolling back applied migration is not supported.
+// Before applying the first destructive migration, a backup of database is
+// written next to database file.
+func MigrateTo(db *gorm.DB, target int) error {
+	if target < 0 || target > LatestVersion() {
+		return fmt.Errorf("invalid target version %d, latest version is %d", target, LatestVersion())
+	}
+
+	appliedMap, err := getAppliedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	currentVersion := 0
+	for version := range appliedMap {
+		currentVersion = max(currentVersion, version)
+	}
+
+	if target < currentVersion {
+		return fmt.Errorf("database is at version %d, migrating down to %d is not supported", currentVersion, target)
+	}
+
+	hasBackup := false
+	for _, migration := range migrations {
+		if migration.Version > target {
+			break
+		}
+
+		if _, ok := appliedMap[migration.Version]; ok {
+			continue
+		}
+
+		if migration.Destructive && !hasBackup {
+			backupPath, err := backupBeforeMigration(db, currentVersion)
+			if err != nil {
+				return fmt.Errorf("failed to backup database before migration %d: %s", migration.Version, err)
+			}
+			if backupPath != "" {
+				log.Infof("database backup saved to %s", backupPath)
+			}
+			hasBackup = true
+		}
+
+		if err := applyMigration(db, migration); err != nil {
+			return err
+		}
+
+		currentVersion = migration.Version
+	}
+
+	return nil
+}
+
+// MigrateNonDestructive applies pending migrations in order, stopping before
+// the first destructive one. An error is returned if any migration is left
+// pending, since database without it can't be used by current program.
+func MigrateNonDestructive(db *gorm.DB) error {
+	appliedMap, err := getAppliedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		if _, ok := appliedMap[migration.Version]; ok {
+			continue
+		}
+
+		if migration.Destructive {
+			return fmt.Errorf("pending migration %03d %s may change existing data, run `database migrate` to apply it", migration.Version, migration.Name)
+		}
+
+		if err := applyMigration(db, migration); err != nil {
+			return fmt.Errorf("%s, run `database migrate` to retry", err)
+		}
+	}
+
+	return nil
+}
+
+// applyMigration runs migration and records it inside one transaction.
+func applyMigration(db *gorm.DB, migration Migration) error {
+	log.Infof("applying migration %03d: %s", migration.Version, migration.Name)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := migration.Up(tx); err != nil {
+			return err
+		}
+
+		return tx.Create(&SchemaMigration{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			AppliedAt: time.Now(),
+		}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("migration %03d %s failed: %s", migration.Version, migration.Name, err)
+	}
+
+	return nil
+}
+
+// GetMigrationStates returns all known migrations and whether they have been
+// applied to database.
+func GetMigrationStates(db *gorm.DB) ([]MigrationState, error) {
+	appliedMap, err := getAppliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	states := []MigrationState{}
+	for _, migration := range migrations {
+		state := MigrationState{Migration: migration}
+		if record, ok := appliedMap[migration.Version]; ok {
+			state.Applied = true
+			state.AppliedAt = record.AppliedAt
+		}
+
+		states = append(states, state)
+	}
+
+	return states, nil
+}
+
+// GetSchemaVersion returns version of the newest migration applied to database.
+func GetSchemaVersion(db *gorm.DB) (int, error) {
+	appliedMap, err := getAppliedMigrations(db)
+	if err != nil {
+		return 0, err
+	}
+
+	version := 0
+	for v := range appliedMap {
+		version = max(version, v)
+	}
+
+	return version, nil
+}
+
+// getAppliedMigrations reads migration records from database, migration table
+// is created if it does not exist.
+func getAppliedMigrations(db *gorm.DB) (map[int]SchemaMigration, error) {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, fmt.Errorf("failed to create migration table: %s", err)
+	}
+
+	records := []SchemaMigration{}
+	if err := db.Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to read migration records: %s", err)
+	}
+
+	appliedMap := map[int]SchemaMigration{}
+	for _, record := range records {
+		appliedMap[record.Version] = record
+	}
+
+	return appliedMap, nil
+}
+
+// backupBeforeMigration copies database to a file next to it, named after
+// current schema version and time. Returns path to backup file, empty string
+// will be returned for in-memory database.
+func backupBeforeMigration(db *gorm.DB, version int) (string, error) {
+	dbPath, err := GetDatabaseFilePath(db)
+	if err != nil {
+		return "", err
+	}
+
+	if dbPath == "" {
+		return "", nil
+	}
+
+	backupPath := fmt.Sprintf("%s.v%03d-%s.bak", dbPath, version, time.Now().Format("20060102-150405"))
+	if err := VacuumInto(db, backupPath); err != nil {
+		return "", err
+	}
+
+	return backupPath, nil
+}
+
+// GetDatabaseFilePath returns file path of main database, empty string will be
+// returned for in-memory database.
+func GetDatabaseFilePath(db *gorm.DB) (string, error) {
+	type databaseInfo struct {
+		Seq  int
+		Name string
+		File string
+	}
+
+	list := []databaseInfo{}
+	if err := db.Raw("PRAGMA database_list").Scan(&list).Error; err != nil {
+		return "", fmt.Errorf("failed to read database list: %s", err)
+	}
+
+	for _, info := range list {
+		if info.Name == "main" {
+			return info.File, nil
+		}
+	}
+
+	return "", nil
+}
+
+// VacuumInto writes a consistent copy of database to given path, target file
+// must not exist.
+func VacuumInto(db *gorm.DB, outputPath string) error {
+	outputPath, err := filepath.Abs(outputPath)
+	if err != nil {
+		return fmt.Errorf("invalid output path %s: %s", outputPath, err)
+	}
+
+	quoted := "'" + strings.ReplaceAll(outputPath, "'", "''") + "'"
+	if err := db.Exec("VACUUM INTO " + quoted).Error; err != nil {
+		return fmt.Errorf("failed to copy database to %s: %s", outputPath, err)
+	}
+
+	return nil
+}
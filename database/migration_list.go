@@ -0,0 +1,247 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migrations lists all schema changes in order of version. Applied migrations
+// must never be modified, add a new migration for any further change.
+//
+// Each migration uses table structs frozen at the time it's written, instead of
+// models in `data_model`, so that it creates the same schema no matter how
+// models change later.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&fileEntryV1{}, &taggedPostEntryV1{})
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add http validator table",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&httpValidatorV2{})
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add book, volume and chapter tables",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&bookV3{}, &volumeV3{}, &chapterV3{})
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add chapter full-text index",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&chapterTextV4{}); err != nil {
+				return err
+			}
+
+			return tx.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS chapter_text_fts USING fts5(tokens, tokenize = 'unicode61')").Error
+		},
+	},
+	{
+		Version: 5,
+		Name:    "add read time to volumes and chapters",
+		Up: func(tx *gorm.DB) error {
+			migrator := tx.Migrator()
+			for _, model := range []any{&volumeReadV5{}, &chapterReadV5{}} {
+				if migrator.HasColumn(model, "ReadAt") {
+					continue
+				}
+
+				if err := migrator.AddColumn(model, "ReadAt"); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add history table",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&historyEntryV6{})
+		},
+	},
+}
+
+// ----------------------------------------------------------------------------
+// Version 1
+
+type fileEntryV1 struct {
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	URL      string `gorm:"primaryKey"`
+	Book     string
+	Part     string
+	Volume   string
+	FileName string
+}
+
+func (fileEntryV1) TableName() string {
+	return "file_entries"
+}
+
+type taggedPostEntryV1 struct {
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	ThumbnailURL string `gorm:"primaryKey"`
+	ContentURL   string
+	FileName     string
+
+	Tag         string
+	MarkDeleted bool
+	Rating      int
+
+	DlFailed bool
+}
+
+func (taggedPostEntryV1) TableName() string {
+	return "tagged_post_entries"
+}
+
+// ----------------------------------------------------------------------------
+// Version 2
+
+type httpValidatorV2 struct {
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	URL          string `gorm:"primaryKey"`
+	ETag         string
+	LastModified string
+}
+
+func (httpValidatorV2) TableName() string {
+	return "http_validators"
+}
+
+// ----------------------------------------------------------------------------
+// Version 3
+
+type bookV3 struct {
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	ID     string `gorm:"primaryKey"`
+	Title  string
+	Author string
+	TocURL string
+}
+
+func (bookV3) TableName() string {
+	return "books"
+}
+
+type volumeV3 struct {
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	ID     uint   `gorm:"primaryKey"`
+	BookID string `gorm:"uniqueIndex:idx_volume_book_index;not null"`
+
+	PartIndex int
+	PartTitle string
+	VolIndex  int `gorm:"uniqueIndex:idx_volume_book_index"`
+	Title     string
+
+	DirPath string
+}
+
+func (volumeV3) TableName() string {
+	return "volumes"
+}
+
+type chapterV3 struct {
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	ID       uint   `gorm:"primaryKey"`
+	BookID   string `gorm:"index;not null"`
+	VolumeID uint   `gorm:"uniqueIndex:idx_chapter_volume_index;not null"`
+
+	ChapIndex int `gorm:"uniqueIndex:idx_chapter_volume_index"`
+	Title     string
+	URL       string `gorm:"index"`
+
+	FilePath    string `gorm:"index"`
+	ContentHash string
+}
+
+func (chapterV3) TableName() string {
+	return "chapters"
+}
+
+// ----------------------------------------------------------------------------
+// Version 4
+
+type chapterTextV4 struct {
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	ID       uint   `gorm:"primaryKey"`
+	BookID   string `gorm:"uniqueIndex:idx_chapter_text_file;not null"`
+	FilePath string `gorm:"uniqueIndex:idx_chapter_text_file"`
+
+	Book        string
+	ContentHash string
+	Content     string
+}
+
+func (chapterTextV4) TableName() string {
+	return "chapter_texts"
+}
+
+// ----------------------------------------------------------------------------
+// Version 5
+
+// volumeReadV5 only contains column added to volumes table.
+type volumeReadV5 struct {
+	ReadAt *time.Time
+}
+
+func (volumeReadV5) TableName() string {
+	return "volumes"
+}
+
+// chapterReadV5 only contains column added to chapters table.
+type chapterReadV5 struct {
+	ReadAt *time.Time
+}
+
+func (chapterReadV5) TableName() string {
+	return "chapters"
+}
+
+// ----------------------------------------------------------------------------
+// Version 6
+
+type historyEntryV6 struct {
+	ID        uint      `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"index"`
+
+	Command string
+	BookID  string `gorm:"index"`
+	Book    string
+	Volume  string
+
+	Processed int
+	Failed    int
+	Duration  time.Duration
+	Changes   string
+}
+
+func (historyEntryV6) TableName() string {
+	return "history"
+}
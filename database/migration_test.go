@@ -0,0 +1,203 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func openTestDatabase(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := OpenNoMigrate(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Close(db) })
+
+	return db
+}
+
+// setTestMigrations replaces migration list for the duration of a test.
+func setTestMigrations(t *testing.T, list []Migration) {
+	old := migrations
+	migrations = list
+	t.Cleanup(func() { migrations = old })
+}
+
+func TestMigrationsOrdered(t *testing.T) {
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Errorf("migration %q at index %d has version %d, want %d", migration.Name, i, migration.Version, i+1)
+		}
+		if migration.Name == "" {
+			t.Errorf("migration %d has no name", migration.Version)
+		}
+		if migration.Up == nil {
+			t.Errorf("migration %d has no Up function", migration.Version)
+		}
+	}
+}
+
+func TestMigrateTo(t *testing.T) {
+	cases := []struct {
+		name    string
+		steps   []int // target versions migrated to one after another
+		want    int   // schema version after all steps
+		wantErr bool  // whether the last step fails
+	}{
+		{name: "latest", steps: []int{LatestVersion()}, want: LatestVersion()},
+		{name: "zero", steps: []int{0}, want: 0},
+		{name: "partial", steps: []int{3}, want: 3},
+		{name: "partial then latest", steps: []int{2, LatestVersion()}, want: LatestVersion()},
+		{name: "same version twice", steps: []int{3, 3}, want: 3},
+		{name: "downgrade", steps: []int{4, 2}, want: 4, wantErr: true},
+		{name: "negative", steps: []int{-1}, want: 0, wantErr: true},
+		{name: "beyond latest", steps: []int{LatestVersion() + 1}, want: 0, wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db := openTestDatabase(t)
+
+			var err error
+			for _, target := range c.steps {
+				err = MigrateTo(db, target)
+			}
+
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, c.wantErr)
+			}
+
+			version, err := GetSchemaVersion(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != c.want {
+				t.Errorf("got schema version %d, want %d", version, c.want)
+			}
+
+			states, err := GetMigrationStates(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, state := range states {
+				if state.Applied != (state.Version <= c.want) {
+					t.Errorf("migration %d applied: %v", state.Version, state.Applied)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrateToAppliesInOrder(t *testing.T) {
+	applied := []int{}
+	makeMigration := func(version int) Migration {
+		return Migration{
+			Version: version,
+			Name:    "test",
+			Up: func(_ *gorm.DB) error {
+				applied = append(applied, version)
+				return nil
+			},
+		}
+	}
+
+	setTestMigrations(t, []Migration{makeMigration(1), makeMigration(2), makeMigration(3)})
+
+	db := openTestDatabase(t)
+	if err := MigrateTo(db, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := MigrateTo(db, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []int{1, 2, 3}
+	if len(applied) != len(want) {
+		t.Fatalf("got applied migrations %v, want %v", applied, want)
+	}
+	for i := range want {
+		if applied[i] != want[i] {
+			t.Fatalf("got applied migrations %v, want %v", applied, want)
+		}
+	}
+}
+
+func TestMigrateNonDestructive(t *testing.T) {
+	noop := func(_ *gorm.DB) error { return nil }
+
+	cases := []struct {
+		name    string
+		list    []Migration
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "all non-destructive",
+			list: []Migration{
+				{Version: 1, Name: "a", Up: noop},
+				{Version: 2, Name: "b", Up: noop},
+			},
+			want: 2,
+		},
+		{
+			name: "stops before destructive",
+			list: []Migration{
+				{Version: 1, Name: "a", Up: noop},
+				{Version: 2, Name: "b", Up: noop, Destructive: true},
+				{Version: 3, Name: "c", Up: noop},
+			},
+			want:    1,
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			setTestMigrations(t, c.list)
+
+			db := openTestDatabase(t)
+			err := MigrateNonDestructive(db)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, c.wantErr)
+			}
+
+			version, err := GetSchemaVersion(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != c.want {
+				t.Errorf("got schema version %d, want %d", version, c.want)
+			}
+		})
+	}
+}
+
+func TestOpenAppliesPendingMigrations(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := OpenNoMigrate(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := MigrateTo(db, 2); err != nil {
+		t.Fatal(err)
+	}
+	Close(db)
+
+	db, err = Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Close(db)
+
+	version, err := GetSchemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != LatestVersion() {
+		t.Errorf("got schema version %d, want %d", version, LatestVersion())
+	}
+}
@@ -0,0 +1,163 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// QueryOptions holds filters and paging settings for QueryTable.
+type QueryOptions struct {
+	Book    string // sub-string of book name
+	Volume  string // sub-string of volume name
+	URLLike string // SQL LIKE pattern matched against all URL columns, treated as sub-string if it has no wildcard
+	Failed  bool   // only records marked as download failed
+	Deleted bool   // only soft deleted records
+
+	Sort   string // column to sort by, prefix with `-` for descending order
+	Limit  int    // max record count, 0 for no limit
+	Offset int
+}
+
+// QueryResult is records found by QueryTable.
+type QueryResult struct {
+	Columns []string // column names in order of model fields
+	Rows    [][]any  // field values of each record, in the same order as columns
+	Total   int64    // count of all matching records, ignoring paging
+}
+
+// QueryTable finds records in table with given options.
+func QueryTable(db *gorm.DB, tableName string, options QueryOptions) (*QueryResult, error) {
+	model := GetModel(tableName)
+	if model == nil {
+		return nil, fmt.Errorf("invalid table name %q", tableName)
+	}
+
+	modelSchema, err := parseModelSchema(db, model)
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := buildQuery(db.Model(model), modelSchema, options)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &QueryResult{}
+	if err := query.Count(&result.Total).Error; err != nil {
+		return nil, fmt.Errorf("failed to count records: %s", err)
+	}
+
+	if options.Sort != "" {
+		column, isDesc := strings.CutPrefix(options.Sort, "-")
+		field := modelSchema.LookUpField(column)
+		if field == nil || field.DBName == "" {
+			return nil, fmt.Errorf("table %s has no column %s", tableName, column)
+		}
+
+		order := field.DBName
+		if isDesc {
+			order += " DESC"
+		}
+		query = query.Order(order)
+	}
+
+	if options.Limit > 0 {
+		query = query.Limit(options.Limit)
+	}
+	if options.Offset > 0 {
+		query = query.Offset(options.Offset)
+	}
+
+	records := reflect.New(reflect.SliceOf(reflect.TypeOf(model).Elem()))
+	if err := query.Find(records.Interface()).Error; err != nil {
+		return nil, fmt.Errorf("failed to query records: %s", err)
+	}
+
+	fields := []*schema.Field{}
+	for _, field := range modelSchema.Fields {
+		if field.DBName != "" {
+			fields = append(fields, field)
+			result.Columns = append(result.Columns, field.DBName)
+		}
+	}
+
+	list := records.Elem()
+	for i := 0; i < list.Len(); i++ {
+		record := list.Index(i)
+
+		row := make([]any, 0, len(fields))
+		for _, field := range fields {
+			row = append(row, record.FieldByIndex(field.StructField.Index).Interface())
+		}
+
+		result.Rows = append(result.Rows, row)
+	}
+
+	return result, nil
+}
+
+// buildQuery applies filters in options to query, returns error if table has
+// no column needed by a filter.
+func buildQuery(query *gorm.DB, modelSchema *schema.Schema, options QueryOptions) (*gorm.DB, error) {
+	requireColumn := func(column, flag string) error {
+		if modelSchema.LookUpField(column) == nil {
+			return fmt.Errorf("table %s has no column %s, can't filter by %s", modelSchema.Table, column, flag)
+		}
+		return nil
+	}
+
+	if options.Book != "" {
+		if err := requireColumn("book", "book"); err != nil {
+			return nil, err
+		}
+		query = query.Where("book LIKE ?", "%"+options.Book+"%")
+	}
+
+	if options.Volume != "" {
+		if err := requireColumn("volume", "volume"); err != nil {
+			return nil, err
+		}
+		query = query.Where("volume LIKE ?", "%"+options.Volume+"%")
+	}
+
+	if options.URLLike != "" {
+		pattern := options.URLLike
+		if !strings.ContainsAny(pattern, "%_") {
+			pattern = "%" + pattern + "%"
+		}
+
+		conditions := []string{}
+		args := []any{}
+		for _, field := range modelSchema.Fields {
+			if strings.HasSuffix(field.DBName, "url") {
+				conditions = append(conditions, field.DBName+" LIKE ?")
+				args = append(args, pattern)
+			}
+		}
+
+		if len(conditions) == 0 {
+			return nil, fmt.Errorf("table %s has no URL column", modelSchema.Table)
+		}
+		query = query.Where(strings.Join(conditions, " OR "), args...)
+	}
+
+	if options.Failed {
+		if err := requireColumn("dl_failed", "failed"); err != nil {
+			return nil, err
+		}
+		query = query.Where("dl_failed = ?", true)
+	}
+
+	if options.Deleted {
+		if err := requireColumn("deleted_at", "deleted"); err != nil {
+			return nil, err
+		}
+		query = query.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+
+	return query, nil
+}
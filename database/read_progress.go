@@ -0,0 +1,178 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/SirZenith/delite/database/data_model"
+	"gorm.io/gorm"
+)
+
+// HasReadProgress checks if read state columns exist in book tables. These
+// columns are added by migration.
+func HasReadProgress(db *gorm.DB) bool {
+	if !HasBookTables(db) {
+		return false
+	}
+
+	migrator := db.Migrator()
+	return migrator.HasColumn(&data_model.Volume{}, "ReadAt") &&
+		migrator.HasColumn(&data_model.Chapter{}, "ReadAt")
+}
+
+// ReadProgress is read state summary of a book.
+type ReadProgress struct {
+	ReadVolumes  int
+	TotalVolumes int
+}
+
+// GetReadProgress returns number of read volumes and recorded volumes of book.
+func GetReadProgress(db *gorm.DB, bookID string) (ReadProgress, error) {
+	progress := ReadProgress{}
+
+	var total, read int64
+	err := db.Model(&data_model.Volume{}).Where("book_id = ?", bookID).Count(&total).Error
+	if err != nil {
+		return progress, fmt.Errorf("failed to count volumes of book %s: %s", bookID, err)
+	}
+
+	err = db.Model(&data_model.Volume{}).Where("book_id = ? AND read_at IS NOT NULL", bookID).Count(&read).Error
+	if err != nil {
+		return progress, fmt.Errorf("failed to count read volumes of book %s: %s", bookID, err)
+	}
+
+	progress.ReadVolumes = int(read)
+	progress.TotalVolumes = int(total)
+
+	return progress, nil
+}
+
+// SetReadProgress marks everything of book before given position as read, and
+// everything after as unread.
+//
+// When `chapIndex` is not positive, volume with `volIndex` is marked as read
+// as a whole. Otherwise chapters in that volume are marked up to `chapIndex`,
+// and the volume itself is counted as read only when all its chapters are
+// read. `volIndex` of 0 marks the whole book as unread.
+func SetReadProgress(db *gorm.DB, bookID string, volIndex, chapIndex int) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		readAt := gorm.Expr("COALESCE(read_at, ?)", now)
+
+		var volume *data_model.Volume
+		if volIndex > 0 {
+			volume = &data_model.Volume{}
+			tx.Limit(1).Find(volume, "book_id = ? AND vol_index = ?", bookID, volIndex)
+			if volume.ID == 0 {
+				return fmt.Errorf("no volume with index %d is recorded", volIndex)
+			}
+		}
+
+		// volumes before target
+		err := markVolumes(tx, bookID, "vol_index < ?", volIndex, readAt)
+		if err != nil {
+			return err
+		}
+
+		// volumes after target
+		err = markVolumes(tx, bookID, "vol_index > ?", volIndex, nil)
+		if err != nil {
+			return err
+		}
+
+		if volume == nil {
+			return nil
+		}
+
+		if chapIndex <= 0 {
+			return markVolumes(tx, bookID, "vol_index = ?", volIndex, readAt)
+		}
+
+		chapters := tx.Model(&data_model.Chapter{}).Where("volume_id = ?", volume.ID)
+		if err := chapters.Session(&gorm.Session{}).Where("chap_index <= ?", chapIndex).Update("read_at", readAt).Error; err != nil {
+			return fmt.Errorf("failed to update chapter read state: %s", err)
+		}
+		if err := chapters.Session(&gorm.Session{}).Where("chap_index > ?", chapIndex).Update("read_at", nil).Error; err != nil {
+			return fmt.Errorf("failed to update chapter read state: %s", err)
+		}
+
+		var unreadCnt int64
+		if err := chapters.Session(&gorm.Session{}).Where("read_at IS NULL").Count(&unreadCnt).Error; err != nil {
+			return fmt.Errorf("failed to count unread chapters: %s", err)
+		}
+
+		if unreadCnt == 0 {
+			err = tx.Model(volume).Update("read_at", readAt).Error
+		} else {
+			err = tx.Model(volume).Update("read_at", nil).Error
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update volume read state: %s", err)
+		}
+
+		return nil
+	})
+}
+
+// markVolumes sets read time of volumes of book matching condition, along with
+// all chapters of these volumes.
+func markVolumes(tx *gorm.DB, bookID string, condition string, volIndex int, readAt any) error {
+	volumes := tx.Model(&data_model.Volume{}).Where("book_id = ?", bookID).Where(condition, volIndex)
+
+	err := tx.Model(&data_model.Chapter{}).
+		Where("volume_id IN (?)", volumes.Session(&gorm.Session{}).Select("id")).
+		Update("read_at", readAt).Error
+	if err != nil {
+		return fmt.Errorf("failed to update chapter read state: %s", err)
+	}
+
+	if err := volumes.Session(&gorm.Session{}).Update("read_at", readAt).Error; err != nil {
+		return fmt.Errorf("failed to update volume read state: %s", err)
+	}
+
+	return nil
+}
+
+// GetVolumes returns all recorded volumes of book, sorted by volume index.
+func GetVolumes(db *gorm.DB, bookID string) ([]data_model.Volume, error) {
+	volumes := []data_model.Volume{}
+	if err := db.Order("vol_index").Find(&volumes, "book_id = ?", bookID).Error; err != nil {
+		return nil, fmt.Errorf("failed to read volumes of book %s: %s", bookID, err)
+	}
+
+	return volumes, nil
+}
+
+// FindNextUnread returns the first unread volume of book, along with the first
+// unread chapter in it. Chapter is nil when none of chapters in volume is read
+// yet, or volume has no chapter record. Volume is nil if all volumes are read.
+func FindNextUnread(db *gorm.DB, bookID string) (*data_model.Volume, *data_model.Chapter, error) {
+	volume := &data_model.Volume{}
+	err := db.Order("vol_index").Limit(1).Find(volume, "book_id = ? AND read_at IS NULL", bookID).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find unread volume of book %s: %s", bookID, err)
+	}
+	if volume.ID == 0 {
+		return nil, nil, nil
+	}
+
+	var readCnt int64
+	err = db.Model(&data_model.Chapter{}).Where("volume_id = ? AND read_at IS NOT NULL", volume.ID).Count(&readCnt).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to count read chapters: %s", err)
+	}
+	if readCnt == 0 {
+		return volume, nil, nil
+	}
+
+	chapter := &data_model.Chapter{}
+	err = db.Order("chap_index").Limit(1).Find(chapter, "volume_id = ? AND read_at IS NULL", volume.ID).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find unread chapter: %s", err)
+	}
+	if chapter.ID == 0 {
+		return volume, nil, nil
+	}
+
+	return volume, chapter, nil
+}
@@ -0,0 +1,200 @@
+package database
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/SirZenith/delite/database/data_model"
+	"gorm.io/gorm"
+)
+
+// setupReadProgressBook records a book with given number of volumes, each has
+// `chapCnt` chapters.
+func setupReadProgressBook(t *testing.T, db *gorm.DB, bookID string, volCnt, chapCnt int) {
+	t.Helper()
+
+	for v := 1; v <= volCnt; v++ {
+		volume := data_model.Volume{BookID: bookID, VolIndex: v}
+		if err := SaveVolume(db, &volume); err != nil {
+			t.Fatal(err)
+		}
+
+		for c := 1; c <= chapCnt; c++ {
+			chapter := data_model.Chapter{BookID: bookID, VolumeID: volume.ID, ChapIndex: c}
+			if err := SaveChapter(db, &chapter); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// getReadState returns index of read volumes, and read chapters in form of
+// `<vol>.<chap>`.
+func getReadState(t *testing.T, db *gorm.DB, bookID string) ([]string, []string) {
+	t.Helper()
+
+	volumes, err := GetVolumes(db, bookID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readVolumes := []string{}
+	readChapters := []string{}
+	for _, volume := range volumes {
+		if volume.ReadAt != nil {
+			readVolumes = append(readVolumes, fmt.Sprint(volume.VolIndex))
+		}
+
+		chapters := []data_model.Chapter{}
+		if err := db.Order("chap_index").Find(&chapters, "volume_id = ?", volume.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		for _, chapter := range chapters {
+			if chapter.ReadAt != nil {
+				readChapters = append(readChapters, fmt.Sprintf("%d.%d", volume.VolIndex, chapter.ChapIndex))
+			}
+		}
+	}
+
+	return readVolumes, readChapters
+}
+
+func TestSetReadProgress(t *testing.T) {
+	type position struct{ vol, chap int }
+
+	cases := []struct {
+		name         string
+		before       *position // progress set before the tested one
+		target       position
+		wantErr      bool
+		wantVolumes  []string
+		wantChapters []string
+	}{
+		{
+			name:         "unread",
+			target:       position{0, 0},
+			wantVolumes:  []string{},
+			wantChapters: []string{},
+		},
+		{
+			name:         "whole volume",
+			target:       position{2, 0},
+			wantVolumes:  []string{"1", "2"},
+			wantChapters: []string{"1.1", "1.2", "1.3", "2.1", "2.2", "2.3"},
+		},
+		{
+			name:         "part of volume",
+			target:       position{2, 2},
+			wantVolumes:  []string{"1"},
+			wantChapters: []string{"1.1", "1.2", "1.3", "2.1", "2.2"},
+		},
+		{
+			name:         "last chapter of volume",
+			target:       position{2, 3},
+			wantVolumes:  []string{"1", "2"},
+			wantChapters: []string{"1.1", "1.2", "1.3", "2.1", "2.2", "2.3"},
+		},
+		{
+			name:         "move back",
+			before:       &position{3, 0},
+			target:       position{1, 1},
+			wantVolumes:  []string{},
+			wantChapters: []string{"1.1"},
+		},
+		{
+			name:         "reset",
+			before:       &position{3, 0},
+			target:       position{0, 0},
+			wantVolumes:  []string{},
+			wantChapters: []string{},
+		},
+		{
+			name:         "unknown volume",
+			before:       &position{1, 0},
+			target:       position{4, 0},
+			wantErr:      true,
+			wantVolumes:  []string{"1"},
+			wantChapters: []string{"1.1", "1.2", "1.3"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db := openTestDatabase(t)
+			if err := MigrateTo(db, LatestVersion()); err != nil {
+				t.Fatal(err)
+			}
+
+			setupReadProgressBook(t, db, "book", 3, 3)
+			setupReadProgressBook(t, db, "other", 2, 2)
+
+			if c.before != nil {
+				if err := SetReadProgress(db, "book", c.before.vol, c.before.chap); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := SetReadProgress(db, "book", c.target.vol, c.target.chap)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, c.wantErr)
+			}
+
+			volumes, chapters := getReadState(t, db, "book")
+			if !slices.Equal(volumes, c.wantVolumes) {
+				t.Errorf("got read volumes %v, want %v", volumes, c.wantVolumes)
+			}
+			if !slices.Equal(chapters, c.wantChapters) {
+				t.Errorf("got read chapters %v, want %v", chapters, c.wantChapters)
+			}
+
+			// other books are never touched
+			volumes, chapters = getReadState(t, db, "other")
+			if len(volumes) > 0 || len(chapters) > 0 {
+				t.Errorf("read state of other book changed: %v %v", volumes, chapters)
+			}
+		})
+	}
+}
+
+func TestSetReadProgressKeepsReadTime(t *testing.T) {
+	db := openTestDatabase(t)
+	if err := MigrateTo(db, LatestVersion()); err != nil {
+		t.Fatal(err)
+	}
+
+	setupReadProgressBook(t, db, "book", 2, 1)
+
+	if err := SetReadProgress(db, "book", 1, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	volumes, err := GetVolumes(db, "book")
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstReadAt := *volumes[0].ReadAt
+
+	time.Sleep(10 * time.Millisecond)
+
+	if err := SetReadProgress(db, "book", 2, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	volumes, err = GetVolumes(db, "book")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !volumes[0].ReadAt.Equal(firstReadAt) {
+		t.Errorf("read time of volume 1 changed from %s to %s", firstReadAt, volumes[0].ReadAt)
+	}
+
+	progress, err := GetReadProgress(db, "book")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.ReadVolumes != 2 || progress.TotalVolumes != 2 {
+		t.Errorf("got progress %d/%d, want 2/2", progress.ReadVolumes, progress.TotalVolumes)
+	}
+}
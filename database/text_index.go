@@ -0,0 +1,502 @@
+package database
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/SirZenith/delite/database/data_model"
+	"golang.org/x/net/html"
+	"gorm.io/gorm"
+)
+
+// name of FTS5 table holding tokenized chapter text, row ID of each record is
+// the ID of corresponding ChapterText record.
+const textIndexTableName = "chapter_text_fts"
+
+// number of runes kept before and after first match in search result snippet.
+const (
+	snippetRunesBefore = 30
+	snippetRunesAfter  = 60
+)
+
+// HasTextIndex checks if full-text index tables exist in database. These
+// tables are created by migration.
+func HasTextIndex(db *gorm.DB) bool {
+	migrator := db.Migrator()
+	return migrator.HasTable(&data_model.ChapterText{}) && migrator.HasTable(textIndexTableName)
+}
+
+// IsTextFileName checks if given file is a chapter text file that can be
+// indexed.
+func IsTextFileName(fileName string) bool {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".html", ".xhtml", ".htm":
+		return true
+	default:
+		return false
+	}
+}
+
+// IndexTextFile updates index record of chapter file at `filePath` relative to
+// `textDir`. Files whose content has not changed since last indexing are
+// skipped. Returns true when index record gets written.
+func IndexTextFile(db *gorm.DB, bookID, bookTitle, textDir, filePath string) (bool, error) {
+	fullPath := filepath.Join(textDir, filePath)
+	data, err := os.ReadFile(fullPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %s", fullPath, err)
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	entry := data_model.ChapterText{}
+	db.Limit(1).Find(&entry, "book_id = ? AND file_path = ?", bookID, filepath.ToSlash(filePath))
+	if entry.ID != 0 && entry.ContentHash == hash {
+		if entry.Book != bookTitle {
+			db.Model(&entry).Update("book", bookTitle)
+		}
+		return false, nil
+	}
+
+	content, err := extractHTMLText(data)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse %s: %s", fullPath, err)
+	}
+
+	entry.BookID = bookID
+	entry.FilePath = filepath.ToSlash(filePath)
+	entry.Book = bookTitle
+	entry.ContentHash = hash
+	entry.Content = content
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&entry).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("DELETE FROM "+textIndexTableName+" WHERE rowid = ?", entry.ID).Error; err != nil {
+			return err
+		}
+
+		tokens := strings.Join(TokenizeText(content), " ")
+		return tx.Exec("INSERT INTO "+textIndexTableName+"(rowid, tokens) VALUES (?, ?)", entry.ID, tokens).Error
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to update text index of %s: %s", fullPath, err)
+	}
+
+	return true, nil
+}
+
+// IndexBookTexts indexes all chapter files under text directory of a book, and
+// removes index records of files that no longer exist. Returns number of
+// updated and removed records.
+func IndexBookTexts(db *gorm.DB, bookID, bookTitle, textDir string) (int, int, error) {
+	updateCnt := 0
+	seen := map[string]bool{}
+
+	err := filepath.WalkDir(textDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == textDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+
+		if d.IsDir() || !IsTextFileName(d.Name()) {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(textDir, path)
+		if err != nil {
+			return err
+		}
+		seen[filepath.ToSlash(relPath)] = true
+
+		ok, err := IndexTextFile(db, bookID, bookTitle, textDir, relPath)
+		if err != nil {
+			return err
+		}
+		if ok {
+			updateCnt++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return updateCnt, 0, err
+	}
+
+	entries := []data_model.ChapterText{}
+	if err := db.Select("id", "file_path").Find(&entries, "book_id = ?", bookID).Error; err != nil {
+		return updateCnt, 0, fmt.Errorf("failed to read text index records: %s", err)
+	}
+
+	removeCnt := 0
+	for _, entry := range entries {
+		if seen[entry.FilePath] {
+			continue
+		}
+
+		if err := removeTextIndexEntry(db, entry.ID); err != nil {
+			return updateCnt, removeCnt, err
+		}
+		removeCnt++
+	}
+
+	return updateCnt, removeCnt, nil
+}
+
+// removeTextIndexEntry deletes text record with given ID, along with its
+// full-text index.
+func removeTextIndexEntry(db *gorm.DB, id uint) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM "+textIndexTableName+" WHERE rowid = ?", id).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(&data_model.ChapterText{}, id).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove text index record %d: %s", id, err)
+	}
+
+	return nil
+}
+
+// TextSearchOptions controls how full-text search is done.
+type TextSearchOptions struct {
+	BookIDs []string // limits search to these books, no limit when empty
+	Limit   int
+
+	// Highlight decorates matched text in snippet, matched text is left as
+	// is when it's nil.
+	Highlight func(text string) string
+}
+
+// TextSearchResult is a chapter file matching search query.
+type TextSearchResult struct {
+	BookID   string
+	Book     string
+	FilePath string // path relative to book's text directory
+	Snippet  string
+}
+
+// SearchText finds chapter files matching given query, results are sorted by
+// relevance. Query is split into terms by white spaces, a file matches only if
+// it contains all terms.
+func SearchText(db *gorm.DB, query string, options TextSearchOptions) ([]TextSearchResult, error) {
+	terms := strings.Fields(query)
+	matchExpr := buildMatchExpr(terms)
+	if matchExpr == "" {
+		return nil, fmt.Errorf("no searchable word in query: %q", query)
+	}
+
+	tx := db.Table(textIndexTableName).
+		Select("chapter_texts.*").
+		Joins("JOIN chapter_texts ON chapter_texts.id = "+textIndexTableName+".rowid").
+		Where(textIndexTableName+" MATCH ?", matchExpr)
+
+	if len(options.BookIDs) > 0 {
+		tx = tx.Where("chapter_texts.book_id IN ?", options.BookIDs)
+	}
+
+	tx = tx.Order("bm25(" + textIndexTableName + ")")
+	if options.Limit > 0 {
+		tx = tx.Limit(options.Limit)
+	}
+
+	entries := []data_model.ChapterText{}
+	if err := tx.Scan(&entries).Error; err != nil {
+		return nil, fmt.Errorf("failed to search text: %s", err)
+	}
+
+	results := make([]TextSearchResult, 0, len(entries))
+	for _, entry := range entries {
+		results = append(results, TextSearchResult{
+			BookID:   entry.BookID,
+			Book:     entry.Book,
+			FilePath: entry.FilePath,
+			Snippet:  makeSnippet(entry.Content, terms, options.Highlight),
+		})
+	}
+
+	return results, nil
+}
+
+// ----------------------------------------------------------------------------
+// Tokenizing
+
+// isCJKRune checks if given rune belongs to writing system without spaces
+// between words.
+func isCJKRune(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) || r == 'ー'
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// textRun is a sequence of CJK runes, or a single word of other writing
+// systems.
+type textRun struct {
+	runes []rune
+	isCJK bool
+}
+
+func splitTextRuns(text string) []textRun {
+	runs := []textRun{}
+	var current *textRun
+
+	for _, r := range text {
+		if !isWordRune(r) {
+			current = nil
+			continue
+		}
+
+		isCJK := isCJKRune(r)
+		if current == nil || current.isCJK != isCJK {
+			runs = append(runs, textRun{isCJK: isCJK})
+			current = &runs[len(runs)-1]
+		}
+
+		current.runes = append(current.runes, unicode.ToLower(r))
+	}
+
+	return runs
+}
+
+// TokenizeText splits text into tokens for full-text index. Words of writing
+// systems using spaces are kept as a whole, while runs of CJK characters are
+// split into overlapping bigrams followed by a unigram of the last character,
+// so that text can be matched starting from any character.
+func TokenizeText(text string) []string {
+	tokens := []string{}
+	for _, run := range splitTextRuns(text) {
+		tokens = append(tokens, tokenizeRun(run, true)...)
+	}
+
+	return tokens
+}
+
+// tokenizeRun returns tokens of a run, trailing unigram of CJK run is only
+// added when `complete` is true.
+func tokenizeRun(run textRun, complete bool) []string {
+	if !run.isCJK {
+		return []string{string(run.runes)}
+	}
+
+	tokens := []string{}
+	for i := 0; i+1 < len(run.runes); i++ {
+		tokens = append(tokens, string(run.runes[i:i+2]))
+	}
+
+	if complete || len(run.runes) == 1 {
+		tokens = append(tokens, string(run.runes[len(run.runes)-1]))
+	}
+
+	return tokens
+}
+
+// buildMatchExpr makes FTS5 match expression requiring all terms to appear in
+// text. Each term is matched as a phrase of its tokens.
+func buildMatchExpr(terms []string) string {
+	phrases := []string{}
+
+	for _, term := range terms {
+		runs := splitTextRuns(term)
+		if len(runs) == 0 {
+			continue
+		}
+
+		tokens := []string{}
+		isPrefix := false
+		for i, run := range runs {
+			// CJK run at the end of term may continue in text, its trailing
+			// unigram won't be found right after its last bigram.
+			isLast := i == len(runs)-1
+			tokens = append(tokens, tokenizeRun(run, !isLast || !run.isCJK)...)
+			isPrefix = isLast && run.isCJK && len(run.runes) == 1
+		}
+
+		phrase := `"` + strings.Join(tokens, " ") + `"`
+		if isPrefix {
+			phrase += "*"
+		}
+		phrases = append(phrases, phrase)
+	}
+
+	return strings.Join(phrases, " AND ")
+}
+
+// ----------------------------------------------------------------------------
+// Text extraction
+
+// kinds of separator between text of adjacent nodes.
+const (
+	textSepNone = iota
+	textSepSpace
+	textSepNewLine
+)
+
+// blockElements are elements whose text gets its own lines.
+var blockElements = map[string]bool{
+	"br": true, "p": true, "div": true, "li": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// extractHTMLText returns visible text in HTML document, text in different
+// block are separated by new line. Text of other adjacent elements is separated
+// by a space, unless the space would fall between two CJK characters.
+func extractHTMLText(data []byte) (string, error) {
+	root, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+
+	buffer := strings.Builder{}
+	sep := textSepNone
+	var lastRune rune
+
+	addSep := func(kind int) {
+		sep = max(sep, kind)
+	}
+
+	writeText := func(text string) {
+		content := strings.Join(strings.Fields(text), " ")
+		if content == "" {
+			if text != "" {
+				addSep(textSepSpace)
+			}
+			return
+		}
+
+		if !strings.HasPrefix(text, content) {
+			addSep(textSepSpace)
+		}
+
+		firstRune, _ := utf8.DecodeRuneInString(content)
+		if buffer.Len() > 0 {
+			switch {
+			case sep == textSepNewLine:
+				buffer.WriteString("\n")
+			case sep == textSepSpace && !(isCJKRune(lastRune) && isCJKRune(firstRune)):
+				buffer.WriteString(" ")
+			}
+		}
+
+		buffer.WriteString(content)
+		lastRune, _ = utf8.DecodeLastRuneInString(content)
+		sep = textSepNone
+
+		if !strings.HasSuffix(text, content) {
+			addSep(textSepSpace)
+		}
+	}
+
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		boundary := textSepNone
+
+		switch node.Type {
+		case html.TextNode:
+			writeText(node.Data)
+			return
+		case html.ElementNode:
+			switch node.Data {
+			case "script", "style", "head":
+				return
+			}
+
+			if blockElements[node.Data] {
+				boundary = textSepNewLine
+			} else {
+				boundary = textSepSpace
+			}
+		}
+
+		addSep(boundary)
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+		addSep(boundary)
+	}
+	walk(root)
+
+	return buffer.String(), nil
+}
+
+// makeSnippet returns a piece of content around first occurrence of any term,
+// with all occurrences of terms in it decorated by highlight function.
+func makeSnippet(content string, terms []string, highlight func(string) string) string {
+	runes := []rune(strings.ReplaceAll(content, "\n", " "))
+	lowered := []rune(strings.ToLower(string(runes)))
+	if len(lowered) != len(runes) {
+		// lower case conversion changes rune count, fallback to exact matching
+		lowered = runes
+	}
+
+	termRunes := [][]rune{}
+	for _, term := range terms {
+		termRunes = append(termRunes, []rune(strings.ToLower(term)))
+	}
+
+	matchAt := func(pos int) int {
+		for _, term := range termRunes {
+			if len(term) > 0 && pos+len(term) <= len(lowered) && string(lowered[pos:pos+len(term)]) == string(term) {
+				return len(term)
+			}
+		}
+		return 0
+	}
+
+	first := -1
+	for i := range lowered {
+		if matchAt(i) > 0 {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		first = 0
+	}
+
+	start := max(first-snippetRunesBefore, 0)
+	end := min(first+snippetRunesAfter, len(runes))
+
+	buffer := strings.Builder{}
+	if start > 0 {
+		buffer.WriteString("...")
+	}
+
+	for i := start; i < end; {
+		if size := matchAt(i); size > 0 {
+			stop := min(i+size, len(runes))
+			text := string(runes[i:stop])
+			if highlight != nil {
+				text = highlight(text)
+			}
+			buffer.WriteString(text)
+			i = stop
+			continue
+		}
+
+		buffer.WriteRune(runes[i])
+		i++
+	}
+
+	if end < len(runes) {
+		buffer.WriteString("...")
+	}
+
+	return buffer.String()
+}
@@ -0,0 +1,180 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestTokenizeText(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"empty", "", []string{}},
+		{"words", "Hello, World 123", []string{"hello", "world", "123"}},
+		{"single CJK character", "日", []string{"日"}},
+		{"CJK run", "東京タワー", []string{"東京", "京タ", "タワ", "ワー", "ー"}},
+		{"CJK split by punctuation", "東京、大阪", []string{"東京", "京", "大阪", "阪"}},
+		{"mixed", "abc東京def", []string{"abc", "東京", "京", "def"}},
+		{"hangul", "한국어", []string{"한국", "국어", "어"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := TokenizeText(c.input)
+			if !slices.Equal(got, c.want) {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestBuildMatchExpr(t *testing.T) {
+	cases := []struct {
+		name  string
+		terms []string
+		want  string
+	}{
+		{"no term", nil, ""},
+		{"no word", []string{"!!!"}, ""},
+		{"word", []string{"Hello"}, `"hello"`},
+		{"multiple terms", []string{"hello", "World"}, `"hello" AND "world"`},
+		{"single CJK character", []string{"東"}, `"東"*`},
+		{"CJK bigram", []string{"東京"}, `"東京"`},
+		{"CJK run", []string{"東京タワー"}, `"東京 京タ タワ ワー"`},
+		{"word then CJK", []string{"abc東京"}, `"abc 東京"`},
+		{"CJK then word", []string{"東京abc"}, `"東京 京 abc"`},
+		{"mixed terms", []string{"東京", "!!!", "tower"}, `"東京" AND "tower"`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := buildMatchExpr(c.terms)
+			if got != c.want {
+				t.Errorf("got %s, want %s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestExtractHTMLText(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "blocks",
+			input: "<p>first</p><p>second</p>",
+			want:  "first\nsecond",
+		},
+		{
+			name:  "line break",
+			input: "<p>first<br>second</p>",
+			want:  "first\nsecond",
+		},
+		{
+			name:  "adjacent inline elements",
+			input: "<p><span>hello</span><span>world</span></p>",
+			want:  "hello world",
+		},
+		{
+			name:  "inline element in text",
+			input: "<p>hello<b>big</b>world</p>",
+			want:  "hello big world",
+		},
+		{
+			name:  "white spaces collapsed",
+			input: "<p>  hello \n\t world  </p>",
+			want:  "hello world",
+		},
+		{
+			name:  "CJK inline elements",
+			input: "<p><span>東京</span><span>タワー</span></p>",
+			want:  "東京タワー",
+		},
+		{
+			name:  "CJK next to word",
+			input: "<p><span>東京</span><span>Tower</span></p>",
+			want:  "東京 Tower",
+		},
+		{
+			name:  "hidden elements",
+			input: "<html><head><title>title</title></head><body><script>var a;</script><style>p {}</style><p>text</p></body></html>",
+			want:  "text",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := extractHTMLText([]byte(c.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestSearchText(t *testing.T) {
+	db := openTestDatabase(t)
+	if err := MigrateTo(db, LatestVersion()); err != nil {
+		t.Fatal(err)
+	}
+
+	textDir := t.TempDir()
+	files := map[string]string{
+		"001.html": "<p>東京タワーに行った。</p>",
+		"002.html": "<p>大阪城を見た。</p><p><span>Osaka</span><span>Castle</span></p>",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(textDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, _, err := IndexBookTexts(db, "book", "Book", textDir); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{"東京", []string{"001.html"}},
+		{"京タ", []string{"001.html"}},
+		{"タワー", []string{"001.html"}},
+		{"東", []string{"001.html"}},
+		{"城", []string{"002.html"}},
+		{"大阪 城", []string{"002.html"}},
+		{"東京 大阪", nil},
+		{"osaka", []string{"002.html"}},
+		{"castle", []string{"002.html"}},
+		{"osakacastle", nil},
+		{"京都", nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			results, err := SearchText(db, c.query, TextSearchOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := []string{}
+			for _, result := range results {
+				got = append(got, result.FilePath)
+			}
+			slices.Sort(got)
+
+			if !slices.Equal(got, c.want) && !(len(got) == 0 && len(c.want) == 0) {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
@@ -2,7 +2,11 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/SirZenith/delite/cmd/book_dl"
@@ -15,6 +19,7 @@ import (
 	"github.com/SirZenith/delite/cmd/library"
 	"github.com/SirZenith/delite/cmd/nhentai"
 	"github.com/SirZenith/delite/cmd/page_decypher"
+	"github.com/SirZenith/delite/network"
 	"github.com/charmbracelet/log"
 	"github.com/urfave/cli/v3"
 )
@@ -34,6 +39,18 @@ func main() {
 		Usage:                 "scraper program for downloading books and images from various website",
 		Version:               "0.7.1",
 		EnableShellCompletion: true,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:   "max-bandwidth",
+				Usage:  "total download rate limit of all requests, e.g. 2MB/s",
+				Action: onMaxBandwidthFlag,
+			},
+			&cli.StringSliceFlag{
+				Name:   "domain-bandwidth",
+				Usage:  "download rate limit for a domain and its sub-domains, overrides global limit, e.g. example.com=500KB/s",
+				Action: onDomainBandwidthFlag,
+			},
+		},
 		Commands: []*cli.Command{
 			book_dl.Cmd(),
 			bundle.Cmd(),
@@ -48,13 +65,47 @@ func main() {
 		},
 	}
 
-	err := cmd.Run(context.Background(), os.Args)
+	// Cancels context on interrupt, giving running jobs a chance to stop in a
+	// state that can be resumed later.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := cmd.Run(ctx, os.Args)
 	if err != nil {
 		log.Error(err)
 		os.Exit(1)
 	}
 }
 
+func onMaxBandwidthFlag(_ context.Context, _ *cli.Command, value string) error {
+	rate, err := network.ParseBandwidth(value)
+	if err != nil {
+		return err
+	}
+
+	network.SetMaxBandwidth(rate)
+
+	return nil
+}
+
+func onDomainBandwidthFlag(_ context.Context, _ *cli.Command, values []string) error {
+	for _, value := range values {
+		domain, rateStr, ok := strings.Cut(value, "=")
+		if !ok || domain == "" {
+			return fmt.Errorf("invalid domain bandwidth %q, expecting <domain>=<rate>", value)
+		}
+
+		rate, err := network.ParseBandwidth(rateStr)
+		if err != nil {
+			return err
+		}
+
+		network.SetDomainBandwidth(domain, rate)
+	}
+
+	return nil
+}
+
 func getLogLevel() log.Level {
 	level := os.Getenv(keyEnvLogLevel)
 	switch level {
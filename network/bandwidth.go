@@ -0,0 +1,250 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bandwidthUnits maps unit name to byte count, unit names are matched case
+// insensitively.
+var bandwidthUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"k":   1 << 10,
+	"kb":  1 << 10,
+	"kib": 1 << 10,
+	"m":   1 << 20,
+	"mb":  1 << 20,
+	"mib": 1 << 20,
+	"g":   1 << 30,
+	"gb":  1 << 30,
+	"gib": 1 << 30,
+}
+
+// ParseBandwidth parses bandwidth string such as `2MB/s`, `512k` or `1048576`
+// into bytes per second. Units are binary, `1KB` is 1024 bytes.
+func ParseBandwidth(value string) (int64, error) {
+	str := strings.ToLower(strings.TrimSpace(value))
+	str = strings.TrimSuffix(str, "/s")
+
+	index := strings.IndexFunc(str, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if index < 0 {
+		index = len(str)
+	}
+
+	number, err := strconv.ParseFloat(str[:index], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bandwidth %q: %s", value, err)
+	}
+
+	unit, ok := bandwidthUnits[strings.TrimSpace(str[index:])]
+	if !ok {
+		return 0, fmt.Errorf("invalid bandwidth unit in %q", value)
+	}
+
+	rate := int64(number * float64(unit))
+	if rate <= 0 {
+		return 0, fmt.Errorf("bandwidth must be positive: %q", value)
+	}
+
+	return rate, nil
+}
+
+// ----------------------------------------------------------------------------
+// Token bucket
+
+// tokenBucket limits data rate with tokens refilled at a constant rate, one
+// token for one byte. Tokens can be borrowed ahead, later takers wait for the
+// debt to be paid off, so that all readers sharing one bucket get limited
+// together.
+type tokenBucket struct {
+	lock   sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64 // max tokens can be saved up
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int64) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// take removes `n` tokens from bucket, and waits until bucket is no longer in
+// debt. Returns `ctx.Err()` if context gets canceled during waiting.
+func (b *tokenBucket) take(ctx context.Context, n int) error {
+	delay := b.reserve(time.Now(), n)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reserve refills bucket up to time `now`, removes `n` tokens from it, and
+// returns how long taker should wait for the debt to be paid off.
+func (b *tokenBucket) reserve(now time.Time, n int) time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// chunkSize returns max size of a single read through this bucket, keeping
+// each wait shorter than a second.
+func (b *tokenBucket) chunkSize() int {
+	return max(1, int(b.burst))
+}
+
+// ----------------------------------------------------------------------------
+// Bandwidth limit
+
+// bandwidthSetting holds process wide bandwidth limit, it's shared by all
+// clients and collectors created by this package.
+var bandwidthSetting = struct {
+	lock    sync.Mutex
+	global  *tokenBucket
+	domains map[string]*tokenBucket
+}{
+	domains: map[string]*tokenBucket{},
+}
+
+// SetMaxBandwidth sets total download rate in bytes per second of all
+// responses, 0 or negative value removes the limit.
+func SetMaxBandwidth(rate int64) {
+	bandwidthSetting.lock.Lock()
+	defer bandwidthSetting.lock.Unlock()
+
+	if rate <= 0 {
+		bandwidthSetting.global = nil
+	} else {
+		bandwidthSetting.global = newTokenBucket(rate)
+	}
+}
+
+// SetDomainBandwidth overrides download rate for responses from given domain
+// and its sub-domains. Responses from this domain do not count towards global
+// limit set by SetMaxBandwidth. 0 or negative value removes the override.
+func SetDomainBandwidth(domain string, rate int64) {
+	bandwidthSetting.lock.Lock()
+	defer bandwidthSetting.lock.Unlock()
+
+	domain = strings.ToLower(strings.TrimPrefix(domain, "*."))
+	if rate <= 0 {
+		delete(bandwidthSetting.domains, domain)
+	} else {
+		bandwidthSetting.domains[domain] = newTokenBucket(rate)
+	}
+}
+
+// getBandwidthBucket returns token bucket for given host, nil will be returned
+// if there is no bandwidth limit on that host.
+func getBandwidthBucket(host string) *tokenBucket {
+	bandwidthSetting.lock.Lock()
+	defer bandwidthSetting.lock.Unlock()
+
+	host = strings.ToLower(host)
+
+	var result *tokenBucket
+	matchLen := -1
+	for domain, bucket := range bandwidthSetting.domains {
+		if host != domain && !strings.HasSuffix(host, "."+domain) {
+			continue
+		}
+
+		// longest match wins
+		if len(domain) > matchLen {
+			result, matchLen = bucket, len(domain)
+		}
+	}
+
+	if result == nil {
+		result = bandwidthSetting.global
+	}
+
+	return result
+}
+
+// bandwidthTransport applies bandwidth limit to response body read from
+// underlying transport.
+type bandwidthTransport struct {
+	base http.RoundTripper
+}
+
+func newBandwidthTransport(base http.RoundTripper) http.RoundTripper {
+	return &bandwidthTransport{base: base}
+}
+
+func (t *bandwidthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	bucket := getBandwidthBucket(req.URL.Hostname())
+	if bucket == nil {
+		return resp, nil
+	}
+
+	resp.Body = &limitedReadCloser{
+		ctx:    req.Context(),
+		bucket: bucket,
+		body:   resp.Body,
+	}
+
+	return resp, nil
+}
+
+// limitedReadCloser reads response body with rate limited by token bucket.
+type limitedReadCloser struct {
+	ctx    context.Context
+	bucket *tokenBucket
+	body   io.ReadCloser
+}
+
+func (r *limitedReadCloser) Read(p []byte) (int, error) {
+	if size := r.bucket.chunkSize(); len(p) > size {
+		p = p[:size]
+	}
+
+	n, err := r.body.Read(p)
+	if n > 0 {
+		if waitErr := r.bucket.take(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+
+	return n, err
+}
+
+func (r *limitedReadCloser) Close() error {
+	return r.body.Close()
+}
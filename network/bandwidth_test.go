@@ -0,0 +1,197 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseBandwidth(t *testing.T) {
+	cases := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{input: "1048576", want: 1 << 20},
+		{input: "512", want: 512},
+		{input: "512b", want: 512},
+		{input: "512k", want: 512 << 10},
+		{input: "512KB", want: 512 << 10},
+		{input: "512KiB", want: 512 << 10},
+		{input: "2MB/s", want: 2 << 20},
+		{input: "2 mb/s", want: 2 << 20},
+		{input: "1.5M", want: 3 << 19},
+		{input: " 1g ", want: 1 << 30},
+		{input: "1GiB/s", want: 1 << 30},
+		{input: "", wantErr: true},
+		{input: "MB", wantErr: true},
+		{input: "0", wantErr: true},
+		{input: "0.0001", wantErr: true},
+		{input: "-1k", wantErr: true},
+		{input: "10TB", wantErr: true},
+		{input: "1..5M", wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.input, func(t *testing.T) {
+			got, err := ParseBandwidth(c.input)
+			if c.wantErr {
+				if err == nil {
+					t.Errorf("expecting error, got %d", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Errorf("got %d, want %d", got, c.want)
+			}
+		})
+	}
+}
+
+func TestTokenBucketReserve(t *testing.T) {
+	type takeStep struct {
+		after time.Duration // time passed since last take
+		n     int
+		want  time.Duration // delay returned for this take
+	}
+
+	cases := []struct {
+		name  string
+		rate  int64
+		steps []takeStep
+	}{
+		{
+			name: "within burst",
+			rate: 1000,
+			steps: []takeStep{
+				{n: 500, want: 0},
+				{n: 500, want: 0},
+			},
+		},
+		{
+			name: "in debt",
+			rate: 1000,
+			steps: []takeStep{
+				{n: 1000, want: 0},
+				{n: 200, want: 200 * time.Millisecond},
+			},
+		},
+		{
+			name: "borrow ahead",
+			rate: 1000,
+			steps: []takeStep{
+				{n: 1300, want: 300 * time.Millisecond},
+			},
+		},
+		{
+			name: "refilled",
+			rate: 1000,
+			steps: []takeStep{
+				{n: 1000, want: 0},
+				{after: 500 * time.Millisecond, n: 600, want: 100 * time.Millisecond},
+			},
+		},
+		{
+			name: "refill capped by burst",
+			rate: 1000,
+			steps: []takeStep{
+				{after: 10 * time.Second, n: 1500, want: 500 * time.Millisecond},
+			},
+		},
+		{
+			name: "debt paid off",
+			rate: 1000,
+			steps: []takeStep{
+				{n: 1500, want: 500 * time.Millisecond},
+				{after: 600 * time.Millisecond, n: 100, want: 0},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bucket := newTokenBucket(c.rate)
+
+			now := bucket.last
+			for i, step := range c.steps {
+				now = now.Add(step.after)
+				if got := bucket.reserve(now, step.n); got != step.want {
+					t.Errorf("take %d: got delay %s, want %s", i+1, got, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTokenBucketTakeCanceled(t *testing.T) {
+	bucket := newTokenBucket(100)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// bucket goes 10 seconds into debt, canceled context stops waiting at once
+	err := bucket.take(ctx, 1100)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestTokenBucketChunkSize(t *testing.T) {
+	cases := []struct {
+		rate int64
+		want int
+	}{
+		{rate: 1, want: 1},
+		{rate: 4096, want: 4096},
+	}
+
+	for _, c := range cases {
+		if got := newTokenBucket(c.rate).chunkSize(); got != c.want {
+			t.Errorf("rate %d: got chunk size %d, want %d", c.rate, got, c.want)
+		}
+	}
+}
+
+func TestGetBandwidthBucket(t *testing.T) {
+	t.Cleanup(func() {
+		SetMaxBandwidth(0)
+		SetDomainBandwidth("example.com", 0)
+		SetDomainBandwidth("img.example.com", 0)
+	})
+
+	if getBandwidthBucket("example.com") != nil {
+		t.Fatal("expecting no limit by default")
+	}
+
+	SetMaxBandwidth(1 << 20)
+	SetDomainBandwidth("*.example.com", 1<<10)
+	SetDomainBandwidth("img.example.com", 1<<12)
+
+	global := bandwidthSetting.global
+	domain := bandwidthSetting.domains["example.com"]
+	sub := bandwidthSetting.domains["img.example.com"]
+
+	cases := []struct {
+		host string
+		want *tokenBucket
+	}{
+		{"example.com", domain},
+		{"www.example.com", domain},
+		{"WWW.Example.com", domain},
+		{"img.example.com", sub},
+		{"a.img.example.com", sub},
+		{"notexample.com", global},
+		{"other.org", global},
+	}
+
+	for _, c := range cases {
+		if got := getBandwidthBucket(c.host); got != c.want {
+			t.Errorf("%s: got wrong bucket", c.host)
+		}
+	}
+}
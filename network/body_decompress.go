@@ -4,96 +4,181 @@ import (
 	"bytes"
 	"compress/flate"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"strings"
 
 	"github.com/andybalholm/brotli"
+	"github.com/charmbracelet/log"
 	"github.com/gocolly/colly/v2"
 	"github.com/klauspost/compress/zstd"
 )
 
-type bodyDecompressFunc = func([]byte) ([]byte, error)
 type decompressorFactory = func(io.Reader) (io.Reader, error)
 
+// DecompressResponseBody decodes buffered body of colly response according to
+// its `Content-Encoding` header.
 func DecompressResponseBody(r *colly.Response) ([]byte, error) {
 	encoding := r.Headers.Get("content-encoding")
-	decompressFunc, err := getBodyDecompressFunc(encoding)
+	if len(ParseContentEncoding(encoding)) == 0 {
+		return r.Body, nil
+	}
+
+	reader, err := NewDecompressReader(encoding, io.NopCloser(bytes.NewReader(r.Body)))
 	if err != nil {
 		return nil, err
 	}
+	defer reader.Close()
 
-	data, err := decompressFunc(r.Body)
-	if err != nil {
+	buffer := bytes.NewBuffer(make([]byte, 0, len(r.Body)*4))
+	if _, err := io.Copy(buffer, reader); err != nil {
 		return nil, fmt.Errorf("failed to decompress response: %s", err)
 	}
 
-	return data, nil
+	return buffer.Bytes(), nil
+}
+
+// ParseContentEncoding splits `Content-Encoding` header value into a list of
+// encoding tokens, in the order they were applied to content. `identity`
+// tokens are dropped, since they make no change to content.
+func ParseContentEncoding(encoding string) []string {
+	tokens := []string{}
+
+	for _, token := range strings.Split(encoding, ",") {
+		token = strings.ToLower(strings.TrimSpace(token))
+		if token == "" || token == "identity" {
+			continue
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens
 }
 
-// Returns a byte decompress function according to encoding type.
-func getBodyDecompressFunc(encoding string) (bodyDecompressFunc, error) {
+// Returns decompressor factory for given encoding token, nil will be returned
+// for unknown encoding.
+func getDecompressorFactory(encoding string) decompressorFactory {
 	switch encoding {
 	case "br":
-		return brotliDecompress, nil
+		return func(reader io.Reader) (io.Reader, error) {
+			return brotli.NewReader(reader), nil
+		}
 	case "deflate":
-		return flateDecompress, nil
-	case "gzip":
-		return gzipDecompress, nil
+		return func(reader io.Reader) (io.Reader, error) {
+			return flate.NewReader(reader), nil
+		}
+	case "gzip", "x-gzip":
+		return func(reader io.Reader) (io.Reader, error) {
+			return gzip.NewReader(reader)
+		}
 	case "zstd":
-		return zstdDecompress, nil
-	case "", "identity":
-		return noDecompress, nil
+		return func(reader io.Reader) (io.Reader, error) {
+			decoder, err := zstd.NewReader(reader)
+			if err != nil {
+				return nil, err
+			}
+			return decoder.IOReadCloser(), nil
+		}
 	default:
-		return nil, fmt.Errorf("unknown content-encoding: %s", encoding)
+		return nil
+	}
+}
+
+// decompressReadCloser reads decompressed data from underlying response body,
+// closing it also closes all decompressor layers and response body.
+type decompressReadCloser struct {
+	io.Reader
+	layers []io.Reader
+	body   io.Closer
+}
+
+func (r *decompressReadCloser) Close() error {
+	errList := []error{}
+
+	for i := len(r.layers) - 1; i >= 0; i-- {
+		if closer, ok := r.layers[i].(io.Closer); ok {
+			errList = append(errList, closer.Close())
+		}
 	}
+	errList = append(errList, r.body.Close())
+
+	return errors.Join(errList...)
 }
 
-// Decompresses given data with decompress function.
-func decompressBodyWith(body []byte, factory decompressorFactory) ([]byte, error) {
-	byteReader := bytes.NewReader(body)
+// decompressTransport decodes response body while it's being read, before it
+// reaches HTTP client user. Decoded response has no `Content-Encoding` header.
+type decompressTransport struct {
+	base http.RoundTripper
+}
+
+// NewDecompressTransport wraps given transport, all response bodies read through
+// returned transport are decoded according to their `Content-Encoding`.
+func NewDecompressTransport(base http.RoundTripper) http.RoundTripper {
+	return &decompressTransport{base: base}
+}
 
-	reader, err := factory(byteReader)
+func (t *decompressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
 	if err != nil {
-		return nil, err
+		return resp, err
 	}
 
-	output, err := io.ReadAll(reader)
+	encoding := resp.Header.Get("Content-Encoding")
+	if len(ParseContentEncoding(encoding)) == 0 {
+		return resp, nil
+	}
+
+	reader, err := NewDecompressReader(encoding, resp.Body)
 	if err != nil {
 		return nil, err
 	}
 
-	return output, nil
-}
+	resp.Body = reader
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
 
-// An no-opt decompress function. Input data will be returned directly.
-func noDecompress(body []byte) ([]byte, error) {
-	return body, nil
+	return resp, nil
 }
 
-// brotliDecompress decodes data with brotli
-func brotliDecompress(body []byte) ([]byte, error) {
-	return decompressBodyWith(body, func(reader io.Reader) (io.Reader, error) {
-		return brotli.NewReader(reader), nil
-	})
-}
+// NewDecompressReader wraps response body with a reader that decompresses body
+// content according to `Content-Encoding` value while reading.
+// Stacked encodings such as `gzip, br` are decoded in reverse order of
+// application. Decoding stops at the first unknown encoding with a warning,
+// and content is returned as is from that layer on.
+func NewDecompressReader(encoding string, body io.ReadCloser) (io.ReadCloser, error) {
+	tokens := ParseContentEncoding(encoding)
+	if len(tokens) == 0 {
+		return body, nil
+	}
 
-// flateDecompress decodes data with flate
-func flateDecompress(body []byte) ([]byte, error) {
-	return decompressBodyWith(body, func(reader io.Reader) (io.Reader, error) {
-		return flate.NewReader(reader), nil
-	})
-}
+	result := &decompressReadCloser{
+		Reader: body,
+		body:   body,
+	}
 
-// gzipDecompress decodes data with gzip.
-func gzipDecompress(body []byte) ([]byte, error) {
-	return decompressBodyWith(body, func(reader io.Reader) (io.Reader, error) {
-		return gzip.NewReader(reader)
-	})
-}
+	for i := len(tokens) - 1; i >= 0; i-- {
+		token := tokens[i]
+
+		factory := getDecompressorFactory(token)
+		if factory == nil {
+			log.Warnf("unknown content-encoding %q, leaving content undecoded", token)
+			break
+		}
+
+		reader, err := factory(result.Reader)
+		if err != nil {
+			result.Close()
+			return nil, fmt.Errorf("failed to decompress response with %s: %s", token, err)
+		}
+
+		result.layers = append(result.layers, reader)
+		result.Reader = reader
+	}
 
-// zstdDecompress decodes data with zstd.
-func zstdDecompress(body []byte) ([]byte, error) {
-	return decompressBodyWith(body, func(reader io.Reader) (io.Reader, error) {
-		return zstd.NewReader(reader)
-	})
+	return result, nil
 }
@@ -0,0 +1,167 @@
+package network
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestParseContentEncoding(t *testing.T) {
+	cases := []struct {
+		input string
+		want  []string
+	}{
+		{"", []string{}},
+		{"gzip", []string{"gzip"}},
+		{" GZip ", []string{"gzip"}},
+		{"identity", []string{}},
+		{"gzip, br", []string{"gzip", "br"}},
+		{"deflate,identity,zstd", []string{"deflate", "zstd"}},
+		{"gzip,,br,", []string{"gzip", "br"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.input, func(t *testing.T) {
+			got := ParseContentEncoding(c.input)
+			if !slices.Equal(got, c.want) {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+// compress encodes data with given encoding token.
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+
+	buffer := &bytes.Buffer{}
+
+	var writer io.WriteCloser
+	switch encoding {
+	case "br":
+		writer = brotli.NewWriter(buffer)
+	case "deflate":
+		w, err := flate.NewWriter(buffer, flate.DefaultCompression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer = w
+	case "gzip", "x-gzip":
+		writer = gzip.NewWriter(buffer)
+	case "zstd":
+		w, err := zstd.NewWriter(buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer = w
+	default:
+		t.Fatalf("unsupported encoding in test: %s", encoding)
+	}
+
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+// trackedBody records whether it has been closed.
+type trackedBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *trackedBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestNewDecompressReader(t *testing.T) {
+	content := []byte("<html><body>hello, 世界</body></html>")
+
+	cases := []struct {
+		name     string
+		encoding string
+		applied  []string // encodings actually applied to body, in order
+	}{
+		{"no encoding", "", nil},
+		{"identity", "identity", nil},
+		{"brotli", "br", []string{"br"}},
+		{"deflate", "deflate", []string{"deflate"}},
+		{"gzip", "gzip", []string{"gzip"}},
+		{"x-gzip", "x-gzip", []string{"x-gzip"}},
+		{"zstd", "zstd", []string{"zstd"}},
+		{"upper case", "GZIP", []string{"gzip"}},
+		{"stacked", "gzip, br", []string{"gzip", "br"}},
+		{"stacked with identity", "zstd, identity, deflate", []string{"zstd", "deflate"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data := content
+			for _, encoding := range c.applied {
+				data = compress(t, encoding, data)
+			}
+
+			body := &trackedBody{Reader: bytes.NewReader(data)}
+			reader, err := NewDecompressReader(c.encoding, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("got %q, want %q", got, content)
+			}
+
+			if err := reader.Close(); err != nil {
+				t.Errorf("failed to close reader: %s", err)
+			}
+			if !body.closed {
+				t.Error("response body is not closed")
+			}
+		})
+	}
+}
+
+func TestNewDecompressReaderUnknownEncoding(t *testing.T) {
+	content := []byte("some content")
+
+	// unknown outer layer stops decoding, content is left as is
+	data := compress(t, "gzip", content)
+	reader, err := NewDecompressReader("gzip, compress", io.NopCloser(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("content should be left undecoded, got %q", got)
+	}
+}
+
+func TestNewDecompressReaderBrokenData(t *testing.T) {
+	body := &trackedBody{Reader: bytes.NewReader([]byte("not gzip data"))}
+	if _, err := NewDecompressReader("gzip", body); err == nil {
+		t.Fatal("expecting error for broken gzip data")
+	}
+	if !body.closed {
+		t.Error("response body should be closed on error")
+	}
+}
@@ -0,0 +1,532 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"mime"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SirZenith/delite/common"
+	"github.com/charmbracelet/log"
+	"github.com/gocolly/colly/v2"
+)
+
+// DefaultUserAgent is used when neither options nor headers provide a user agent.
+const DefaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:127.0) Gecko/20100101 Firefox/127.0"
+
+// DefaultRetryDelay is default base waiting time before retrying a failed request.
+const DefaultRetryDelay = 500 * time.Millisecond
+
+// maxRetryDelay is upper bound of waiting time before retrying a request.
+const maxRetryDelay = 30 * time.Second
+
+// ClientOptions holds settings shared by all HTTP clients and colly collectors
+// created by this package.
+type ClientOptions struct {
+	Ctx context.Context // when not nil, all requests get canceled along with it
+
+	Headers   map[string]string // default headers of every request
+	UserAgent string            // overrides `User-Agent` in headers when not empty
+
+	// HeaderTemplate renders headers for each request according to request
+	// URL, see HeaderTemplate.Apply.
+	HeaderTemplate *HeaderTemplate
+
+	HTTPProxy  string // proxy for HTTP request, see makeProxyFunc for how empty value is handled
+	HTTPSProxy string // proxy for HTTPS request, see makeProxyFunc for how empty value is handled
+	Cookies    map[string]map[string]string
+
+	Timeout    time.Duration      // request timeout, 0 for no timeout
+	RetryCnt   int                // retry count for failed request without custom error handler
+	RetryDelay time.Duration      // base waiting time before retry, doubled on each attempt
+	LimitRules []*colly.LimitRule // request limit rules
+
+	Async bool // makes collector send request asynchronously
+
+	// TranscodeHTML makes collector detect charset of HTML response and
+	// transcode response body to UTF-8 before any callback gets called.
+	TranscodeHTML bool
+
+	// Transport replaces default transport built from proxy settings. Use
+	// `NewTransport` to get a transport with proxy settings as base of a
+	// custom transport.
+	Transport http.RoundTripper
+
+	// ErrorLogger is used for reporting errors not handled by request specific
+	// callbacks, `log.Error` is used when it's nil.
+	ErrorLogger func(msg string)
+}
+
+func (options *ClientOptions) getUserAgent() string {
+	if options.UserAgent != "" {
+		return options.UserAgent
+	}
+
+	if options.HeaderTemplate != nil && options.HeaderTemplate.UserAgent() != "" {
+		return options.HeaderTemplate.UserAgent()
+	}
+
+	for name, value := range options.Headers {
+		if strings.EqualFold(name, "User-Agent") && value != "" {
+			return value
+		}
+	}
+
+	return DefaultUserAgent
+}
+
+func (options *ClientOptions) getRetryDelay() time.Duration {
+	if options.RetryDelay > 0 {
+		return options.RetryDelay
+	}
+	return DefaultRetryDelay
+}
+
+// getTransport returns transport used by client, response body read from it is
+// already decoded, and limited by bandwidth setting of this package.
+func (options *ClientOptions) getTransport() http.RoundTripper {
+	transport := options.Transport
+	if transport == nil {
+		transport = NewTransport(*options)
+	}
+
+	return NewDecompressTransport(newBandwidthTransport(transport))
+}
+
+func (options *ClientOptions) logError(msg string) {
+	if options.ErrorLogger != nil {
+		options.ErrorLogger(msg)
+	} else {
+		log.Error(msg)
+	}
+}
+
+// NewTransport creates HTTP transport with proxy settings in options.
+func NewTransport(options ClientOptions) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = makeProxyFunc(options.HTTPProxy, options.HTTPSProxy)
+
+	return transport
+}
+
+// makeProxyFunc returns proxy function choosing proxy by request scheme. Proxy
+// settings from environment is used only when neither proxy is given, otherwise
+// request of scheme without explicit proxy is sent directly.
+func makeProxyFunc(httpProxy, httpsProxy string) func(*http.Request) (*url.URL, error) {
+	if httpProxy == "" && httpsProxy == "" {
+		return http.ProxyFromEnvironment
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		var proxy string
+		switch req.URL.Scheme {
+		case "http":
+			proxy = httpProxy
+		case "https":
+			proxy = httpsProxy
+		default:
+			return http.ProxyFromEnvironment(req)
+		}
+
+		if proxy == "" {
+			return nil, nil
+		}
+
+		return url.Parse(proxy)
+	}
+}
+
+// newCookieJar creates cookie jar with cookies in options set.
+func newCookieJar(options ClientOptions) (http.CookieJar, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cookie jar: %s", err)
+	}
+
+	for urlStr, cookies := range options.Cookies {
+		target, err := url.Parse(urlStr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse cookie URL %s: %s", urlStr, err)
+		}
+
+		list := []*http.Cookie{}
+		for name, value := range cookies {
+			list = append(list, &http.Cookie{Name: name, Value: value})
+		}
+		jar.SetCookies(target, list)
+	}
+
+	return jar, nil
+}
+
+// Backoff waits before `attempt`-th retry of a request, waiting time doubles on
+// each attempt starting from `base`. Returns `ctx.Err()` if context gets
+// canceled during waiting.
+func Backoff(ctx context.Context, attempt int, base time.Duration) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	delay := base
+	for i := 1; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxRetryDelay)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Colly collector
+
+// NewCollector creates colly collector with given options.
+// Response body is decompressed by transport before any callback gets called.
+// Headers in `HeaderTemplate` get applied to every request.
+// With `TranscodeHTML` set, HTML response body is also transcoded to UTF-8.
+// Request context without `maxRetryCnt` value gets `RetryCnt` in options as its
+// max retry count, which is used by `RetryRequest`.
+// If request context has a `colly.ResponseCallback` with key `onResponse`, it
+// gets called with response.
+// A 304 response of conditional request is not treated as error, it's passed to
+// `colly.ResponseCallback` with key `onNotModified` in request context instead.
+// On error, `colly.ErrorCallback` stored with key `onError` in request context
+// gets called. If no such callback, request is retried with backoff according
+// to options, error is logged after all retry failed.
+func NewCollector(options ClientOptions, extra ...colly.CollectorOption) (*colly.Collector, error) {
+	collectorOptions := []colly.CollectorOption{
+		colly.Async(options.Async),
+		colly.UserAgent(options.getUserAgent()),
+	}
+	if options.Headers != nil {
+		collectorOptions = append(collectorOptions, colly.Headers(options.Headers))
+	}
+	if options.Ctx != nil {
+		collectorOptions = append(collectorOptions, colly.StdlibContext(options.Ctx))
+	}
+	collectorOptions = append(collectorOptions, extra...)
+
+	c := colly.NewCollector(collectorOptions...)
+
+	c.WithTransport(options.getTransport())
+
+	jar, err := newCookieJar(options)
+	if err != nil {
+		return nil, err
+	}
+	c.SetCookieJar(jar)
+
+	if options.Timeout > 0 {
+		c.SetRequestTimeout(options.Timeout)
+	}
+
+	if len(options.LimitRules) > 0 {
+		if err := c.Limits(options.LimitRules); err != nil {
+			return nil, fmt.Errorf("invalid limit rule: %s", err)
+		}
+	}
+
+	if options.HeaderTemplate != nil {
+		c.OnRequest(func(r *colly.Request) {
+			options.HeaderTemplate.Apply(r.URL, *r.Headers)
+		})
+	}
+
+	c.OnRequest(func(r *colly.Request) {
+		// default retry count used by `RetryRequest`
+		if r.Ctx.GetAny("maxRetryCnt") == nil {
+			r.Ctx.Put("maxRetryCnt", options.RetryCnt)
+		}
+	})
+
+	if options.TranscodeHTML {
+		setupHTMLTranscode(c, options)
+	}
+
+	c.OnResponse(func(r *colly.Response) {
+		if onResponse, ok := r.Ctx.GetAny("onResponse").(colly.ResponseCallback); ok {
+			onResponse(r)
+		}
+	})
+
+	c.OnError(func(r *colly.Response, err error) {
+		if r.StatusCode == http.StatusNotModified {
+			if onNotModified, ok := r.Ctx.GetAny("onNotModified").(colly.ResponseCallback); ok {
+				onNotModified(r)
+			} else {
+				log.Debugf("not modified: %s", r.Request.URL)
+			}
+			return
+		}
+
+		if onError, ok := r.Ctx.GetAny("onError").(colly.ErrorCallback); ok {
+			onError(r, err)
+			return
+		}
+
+		if retryCollectorRequest(r.Request, options) {
+			return
+		}
+
+		options.logError(fmt.Sprintf("error requesting %s: %s", r.Request.URL, err))
+	})
+
+	return c, nil
+}
+
+// setupHTMLTranscode registers callbacks transcoding HTML response to UTF-8.
+// Charset parameter is taken off `Content-Type` header before colly reads
+// response body, so that colly won't decode body on its own, and the original
+// value is used for charset detection after body is read.
+func setupHTMLTranscode(c *colly.Collector, options ClientOptions) {
+	c.OnResponseHeaders(func(r *colly.Response) {
+		contentType := r.Headers.Get("Content-Type")
+		if !common.IsHTMLContentType(contentType) {
+			r.Ctx.Put("rawContentType", "")
+			return
+		}
+
+		r.Ctx.Put("rawContentType", contentType)
+		if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+			r.Headers.Set("Content-Type", mediaType)
+		}
+	})
+
+	c.OnResponse(func(r *colly.Response) {
+		contentType := r.Ctx.Get("rawContentType")
+		if contentType == "" {
+			return
+		}
+
+		data, name, err := common.DecodeHTMLToUTF8(r.Body, contentType)
+		if err != nil {
+			options.logError(fmt.Sprintf("failed to transcode %s: %s", r.Request.URL, err))
+			return
+		}
+
+		if name != "utf-8" {
+			log.Debugf("transcode %s from %s to utf-8", r.Request.URL, name)
+		}
+
+		r.Body = data
+		if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+			r.Headers.Set("Content-Type", mediaType+"; charset=utf-8")
+		}
+	})
+}
+
+// retryCollectorRequest retries request with backoff if retry count in options
+// has not been used up. Returns true if request is retried.
+func retryCollectorRequest(req *colly.Request, options ClientOptions) bool {
+	retryCnt, _ := req.Ctx.GetAny("retryCnt").(int)
+	if retryCnt >= options.RetryCnt {
+		return false
+	}
+
+	retryCnt++
+	req.Ctx.Put("retryCnt", retryCnt)
+
+	if Backoff(options.Ctx, retryCnt, options.getRetryDelay()) != nil {
+		return false
+	}
+
+	return req.Retry() == nil
+}
+
+// ----------------------------------------------------------------------------
+// Plain HTTP client
+
+// Client is a HTTP client sharing the same behavior as collectors created by
+// `NewCollector`: default headers, proxy, cookie, decompression, retry and
+// rate limit are all handled by client.
+type Client struct {
+	*http.Client
+
+	options ClientOptions
+	headers map[string]string
+	limiter *hostLimiter
+}
+
+// NewClient creates HTTP client with given options.
+func NewClient(options ClientOptions) (*Client, error) {
+	jar, err := newCookieJar(options)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := map[string]string{}
+	for name, value := range options.Headers {
+		headers[name] = value
+	}
+	headers["User-Agent"] = options.getUserAgent()
+
+	client := &Client{
+		Client: &http.Client{
+			Transport: options.getTransport(),
+			Jar:       jar,
+			Timeout:   options.Timeout,
+		},
+		options: options,
+		headers: headers,
+		limiter: newHostLimiter(options.LimitRules),
+	}
+
+	return client, nil
+}
+
+// Do sends a new request with given method to `url`. Requests without body are
+// retried with backoff on network error or server error. Body of returned
+// response is decompressed according to its `Content-Encoding`.
+func (c *Client) Do(method, url string, body io.Reader) (*http.Response, error) {
+	ctx := c.options.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new request: %s", err)
+	}
+
+	for name, value := range c.headers {
+		req.Header.Set(name, value)
+	}
+
+	if c.options.HeaderTemplate != nil {
+		c.options.HeaderTemplate.Apply(req.URL, req.Header)
+	}
+
+	retryCnt := c.options.RetryCnt
+	if body != nil {
+		retryCnt = 0
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if err := Backoff(ctx, attempt, c.options.getRetryDelay()); err != nil {
+				return nil, err
+			}
+		}
+
+		var resp *http.Response
+		resp, err = c.doOnce(req)
+
+		if err == nil && !isRetryableStatus(resp.StatusCode) {
+			return resp, nil
+		}
+
+		if attempt >= retryCnt || ctx.Err() != nil {
+			return resp, err
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+	}
+}
+
+// doOnce sends request under rate limit.
+func (c *Client) doOnce(req *http.Request) (*http.Response, error) {
+	release, err := c.limiter.acquire(req.Context(), req.URL.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return c.Client.Do(req)
+}
+
+// isRetryableStatus checks if request with given response status is worth
+// retrying.
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// ----------------------------------------------------------------------------
+// Rate limit
+
+// hostLimiter applies colly limit rules to requests made by plain HTTP client.
+type hostLimiter struct {
+	rules []*colly.LimitRule
+	slots map[*colly.LimitRule]chan struct{}
+}
+
+func newHostLimiter(rules []*colly.LimitRule) *hostLimiter {
+	limiter := &hostLimiter{
+		slots: map[*colly.LimitRule]chan struct{}{},
+	}
+
+	for _, rule := range rules {
+		if err := rule.Init(); err != nil {
+			log.Warnf("skip invalid limit rule %q: %s", rule.DomainGlob, err)
+			continue
+		}
+
+		parallelism := rule.Parallelism
+		if parallelism <= 0 {
+			parallelism = 1
+		}
+
+		limiter.rules = append(limiter.rules, rule)
+		limiter.slots[rule] = make(chan struct{}, parallelism)
+	}
+
+	return limiter
+}
+
+// acquire waits until a request to given host is allowed by limit rule. Returned
+// function should be called after request finishes, it applies delay of rule
+// before freeing request slot.
+func (l *hostLimiter) acquire(ctx context.Context, host string) (func(), error) {
+	var rule *colly.LimitRule
+	for _, r := range l.rules {
+		if r.Match(host) {
+			rule = r
+			break
+		}
+	}
+
+	if rule == nil {
+		return func() {}, nil
+	}
+
+	slot := l.slots[rule]
+	select {
+	case slot <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	once := sync.Once{}
+	release := func() {
+		once.Do(func() {
+			delay := rule.Delay
+			if rule.RandomDelay > 0 {
+				delay += time.Duration(rand.Int63n(int64(rule.RandomDelay)))
+			}
+
+			if delay <= 0 {
+				<-slot
+				return
+			}
+
+			time.AfterFunc(delay, func() { <-slot })
+		})
+	}
+
+	return release, nil
+}
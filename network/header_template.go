@@ -0,0 +1,122 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// HeaderValue is one header entry in header file. Value can contain following
+// placeholders, which are filled with information of each request:
+//
+//   - {{host}}: host of request URL, including port if any
+//   - {{hostname}}: host name of request URL
+//   - {{origin}}: scheme and host of request URL, e.g. https://example.com
+//   - {{referer}}: root page of request host, e.g. https://example.com/
+//   - {{user_agent}}: user agent picked for current session
+type HeaderValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// headerFileContent is content of header file in object form.
+type headerFileContent struct {
+	UserAgents []string      `json:"user_agents"` // user agent pool, one of them is used for each session
+	Headers    []HeaderValue `json:"headers"`
+}
+
+// HeaderTemplate renders request headers from header values with placeholders.
+type HeaderTemplate struct {
+	headers   []HeaderValue
+	userAgent string // user agent picked from pool for this session, empty if pool is empty
+}
+
+// NewHeaderTemplate creates header template with given header values. A user
+// agent is picked randomly from `userAgents` as user agent of this session.
+func NewHeaderTemplate(headers []HeaderValue, userAgents []string) *HeaderTemplate {
+	template := &HeaderTemplate{
+		headers: headers,
+	}
+
+	if len(userAgents) > 0 {
+		template.userAgent = userAgents[rand.Intn(len(userAgents))]
+	}
+
+	return template
+}
+
+// ReadHeaderFile loads header template from JSON file. File content can be
+// either an array of header objects, each with string field `name` and `value`;
+// or an object with field `headers` holding such array and an optional field
+// `user_agents` which is an array of user agent strings.
+func ReadHeaderFile(path string) (*HeaderTemplate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header file %s: %s", path, err)
+	}
+
+	content := headerFileContent{}
+	if err = json.Unmarshal(data, &content.Headers); err != nil {
+		if err = json.Unmarshal(data, &content); err != nil {
+			return nil, fmt.Errorf("failed to parse header file %s: %s", path, err)
+		}
+	}
+
+	return NewHeaderTemplate(content.Headers, content.UserAgents), nil
+}
+
+// UserAgent returns user agent picked for this session, empty string will be
+// returned if template has no user agent pool.
+func (t *HeaderTemplate) UserAgent() string {
+	return t.userAgent
+}
+
+// Render returns all headers in template with placeholders filled according to
+// target URL.
+func (t *HeaderTemplate) Render(target *url.URL) http.Header {
+	header := http.Header{}
+	replacer := t.makeReplacer(target)
+
+	for _, entry := range t.headers {
+		header.Set(entry.Name, replacer.Replace(entry.Value))
+	}
+
+	return header
+}
+
+// Apply writes headers in template to given header. Headers with placeholders
+// are always overwritten, since their values depend on target URL. Other
+// headers are only added when they are missing from given header.
+func (t *HeaderTemplate) Apply(target *url.URL, header http.Header) {
+	replacer := t.makeReplacer(target)
+
+	for _, entry := range t.headers {
+		isTemplated := strings.Contains(entry.Value, "{{")
+		if !isTemplated && header.Get(entry.Name) != "" {
+			continue
+		}
+
+		header.Set(entry.Name, replacer.Replace(entry.Value))
+	}
+}
+
+func (t *HeaderTemplate) makeReplacer(target *url.URL) *strings.Replacer {
+	userAgent := t.userAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+
+	origin := target.Scheme + "://" + target.Host
+
+	return strings.NewReplacer(
+		"{{host}}", target.Host,
+		"{{hostname}}", target.Hostname(),
+		"{{origin}}", origin,
+		"{{referer}}", origin+"/",
+		"{{user_agent}}", userAgent,
+	)
+}
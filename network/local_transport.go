@@ -0,0 +1,221 @@
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/SirZenith/delite/common"
+	"github.com/charmbracelet/log"
+)
+
+// LocalFileTransport answers HTTP requests with files saved under a local
+// directory. Request URL stays untouched, so callbacks see the same canonical
+// URL as they do during live crawling.
+//
+// HTML files whose `<link rel="canonical">` points to requested URL are used
+// first. Otherwise, for a request to `https://example.com/novel/1/2.html`,
+// following files are looked up in order:
+//
+//   - <root>/example.com/novel/1/2.html
+//   - <root>/novel/1/2.html
+//
+// URL path ending with `/` maps to `index.html` in that directory, and path
+// without extension also matches file with `.html` extension.
+//
+// When URL has query string, it's kept in file name, either as is or joined to
+// file stem with `_`, e.g. `/novel/?p=2` matches `novel/index.html?p=2` or
+// `novel/index_p=2.html`. Characters not allowed in path are replaced the same
+// way as downloaded file names. Such request never matches file saved without
+// query.
+type LocalFileTransport struct {
+	root     string
+	fallback http.RoundTripper // used when no local file is found, can be nil
+
+	canonicalOnce  sync.Once
+	canonicalFiles map[string]string // canonical URL key to file path
+}
+
+// NewLocalFileTransport creates a transport reading files from `root`. Requests
+// that have no matching local file are sent through `fallback`, if `fallback`
+// is nil, a 404 response is returned for such requests instead.
+func NewLocalFileTransport(root string, fallback http.RoundTripper) *LocalFileTransport {
+	return &LocalFileTransport{
+		root:     root,
+		fallback: fallback,
+	}
+}
+
+func (t *LocalFileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fileName := t.findFile(req.URL)
+	if fileName == "" && t.fallback != nil {
+		return t.fallback.RoundTrip(req)
+	}
+
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	if fileName == "" {
+		return makeLocalResponse(req, http.StatusNotFound, nil, ""), nil
+	}
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read local file %s: %s", fileName, err)
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(fileName))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+
+	return makeLocalResponse(req, http.StatusOK, data, contentType), nil
+}
+
+// findFile returns path of local file matching given URL, empty string will be
+// returned if no such file exists.
+func (t *LocalFileTransport) findFile(reqURL *url.URL) string {
+	t.canonicalOnce.Do(t.loadCanonicalFiles)
+	if fileName, ok := t.canonicalFiles[makeCanonicalKey(reqURL)]; ok {
+		return fileName
+	}
+
+	urlPath := reqURL.Path
+	isDir := urlPath == "" || strings.HasSuffix(urlPath, "/")
+
+	urlPath = path.Clean("/" + urlPath)
+	if isDir {
+		urlPath = path.Join(urlPath, "index.html")
+	}
+
+	names := []string{urlPath}
+	if path.Ext(urlPath) == "" {
+		names = append(names, urlPath+".html", path.Join(urlPath, "index.html"))
+	}
+
+	if query := reqURL.RawQuery; query != "" {
+		queryNames := []string{}
+		for _, name := range names {
+			ext := path.Ext(name)
+			queryNames = append(
+				queryNames,
+				name+"?"+query,
+				strings.TrimSuffix(name, ext)+"_"+common.InvalidPathCharReplace(query)+ext,
+			)
+		}
+		names = queryNames
+	}
+
+	for _, prefix := range []string{reqURL.Host, ""} {
+		for _, name := range names {
+			fileName := filepath.Join(t.root, prefix, filepath.FromSlash(name))
+
+			if stat, err := os.Stat(fileName); err == nil && !stat.IsDir() {
+				return fileName
+			}
+		}
+	}
+
+	return ""
+}
+
+// loadCanonicalFiles scans HTML files under root directory, and records files
+// declaring canonical URL.
+func (t *LocalFileTransport) loadCanonicalFiles() {
+	t.canonicalFiles = map[string]string{}
+
+	filepath.WalkDir(t.root, func(fileName string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+
+		switch strings.ToLower(filepath.Ext(fileName)) {
+		case ".html", ".htm":
+		default:
+			return nil
+		}
+
+		canonical := readCanonicalURL(fileName)
+		if canonical == nil {
+			return nil
+		}
+
+		key := makeCanonicalKey(canonical)
+		if old, ok := t.canonicalFiles[key]; ok {
+			log.Warnf("%s and %s have the same canonical URL %s, using the former", old, fileName, canonical)
+		} else {
+			t.canonicalFiles[key] = fileName
+		}
+
+		return nil
+	})
+}
+
+// readCanonicalURL returns absolute URL in `<link rel="canonical">` tag of
+// given HTML file, nil will be returned if file has no such tag.
+func readCanonicalURL(fileName string) *url.URL {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	doc, err := goquery.NewDocumentFromReader(file)
+	if err != nil {
+		return nil
+	}
+
+	href, ok := doc.Find(`link[rel="canonical"][href]`).First().Attr("href")
+	if !ok {
+		return nil
+	}
+
+	canonical, err := url.Parse(strings.TrimSpace(href))
+	if err != nil || canonical.Host == "" {
+		return nil
+	}
+
+	return canonical
+}
+
+// makeCanonicalKey returns key used for matching URL against canonical URL of
+// local files. Scheme and fragment are ignored.
+func makeCanonicalKey(target *url.URL) string {
+	key := strings.ToLower(target.Host) + path.Clean("/"+target.Path)
+	if target.RawQuery != "" {
+		key += "?" + target.RawQuery
+	}
+
+	return key
+}
+
+// makeLocalResponse creates response object for request served by local file.
+func makeLocalResponse(req *http.Request, status int, data []byte, contentType string) *http.Response {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}
+}
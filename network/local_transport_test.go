@@ -0,0 +1,79 @@
+package network
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalFileTransport(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"example.com/novel/index.html":     "toc page 1",
+		"example.com/novel/index.html?p=2": "toc page 2",
+		"novel/index_p=3.html":             "toc page 3",
+		"example.com/novel/1/2.html":       "chapter 2",
+		"novel/1/3.html":                   "chapter 3",
+		"saved/Chapter 4.html":             `<html><head><link rel="canonical" href="https://example.com/novel/1/4"></head></html>`,
+		"saved/Chapter 5.html":             `<html><head><link rel="canonical" href="https://example.com/novel/1/?id=5"></head></html>`,
+	}
+	for name, content := range files {
+		fileName := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fileName), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fileName, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	transport := NewLocalFileTransport(root, nil)
+
+	cases := []struct {
+		url  string
+		want string // path of file served, empty for 404
+	}{
+		{"https://example.com/novel/", "example.com/novel/index.html"},
+		{"https://example.com/novel", "example.com/novel/index.html"},
+		{"https://example.com/novel/?p=2", "example.com/novel/index.html?p=2"},
+		{"https://example.com/novel/?p=3", "novel/index_p=3.html"},
+		{"https://example.com/novel/?p=4", ""},
+		{"https://example.com/novel/1/2.html", "example.com/novel/1/2.html"},
+		{"https://example.com/novel/1/3", "novel/1/3.html"},
+		{"http://example.com/novel/1/4#top", "saved/Chapter 4.html"},
+		{"https://example.com/novel/1/?id=5", "saved/Chapter 5.html"},
+		{"https://example.com/novel/1/6.html", ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.url, func(t *testing.T) {
+			req, err := http.NewRequest("GET", c.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if c.want == "" {
+				if resp.StatusCode != http.StatusNotFound {
+					t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusNotFound)
+				}
+				return
+			}
+
+			data, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusOK || string(data) != files[c.want] {
+				t.Errorf("got %d %q, want content of %s", resp.StatusCode, data, c.want)
+			}
+		})
+	}
+}
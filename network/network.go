@@ -1,7 +1,10 @@
 package network
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"strconv"
 
 	"github.com/SirZenith/delite/common"
 	"github.com/charmbracelet/log"
@@ -10,11 +13,15 @@ import (
 
 var ErrMaxRetry = errors.New("max retry")
 
+// ErrRetrying indicates request has been sent again, and result of current
+// response should be discarded.
+var ErrRetrying = errors.New("retrying")
+
 // MakeSaveBodyCallback returns a closure that saves response body to given path
 // and can be used as colly onResponse callback.
 func MakeSaveBodyCallback(outputName string) colly.ResponseCallback {
 	return colly.ResponseCallback(func(resp *colly.Response) {
-		if err := resp.Save(outputName); err == nil {
+		if err := common.WriteBytesAtomic(outputName, resp.Body, 0o644); err == nil {
 			log.Infof("file downloaded: %s", outputName)
 		} else {
 			log.Warnf("failed to save file %s: %s\n", outputName, err)
@@ -22,34 +29,90 @@ func MakeSaveBodyCallback(outputName string) colly.ResponseCallback {
 	})
 }
 
-func MakeSaveImageBodyCallback(outputName string, outputFormat string) colly.ResponseCallback {
+// MakeSaveImageBodyCallback returns a closure that converts response body to
+// image of given format and saves it to given path. `onSaved` will be called
+// after image file is successfully written, it can be nil.
+// Response body is verified with SaveImageResponse, invalid image gets
+// re-fetched until `ctx` gets canceled.
+func MakeSaveImageBodyCallback(ctx context.Context, outputName string, outputFormat string, onSaved func()) colly.ResponseCallback {
 	return colly.ResponseCallback(func(resp *colly.Response) {
-		err := common.SaveImageAs(resp.Body, outputName, outputFormat)
+		err := SaveImageResponse(ctx, resp, outputName, outputFormat)
 		if err == nil {
 			log.Infof("image downloaded: %s", outputName)
-		} else {
+			if onSaved != nil {
+				onSaved()
+			}
+		} else if !errors.Is(err, ErrRetrying) {
 			log.Warnf("failed to save image %s: %s\n", outputName, err)
 		}
 	})
 }
 
+// GetContentLength returns `Content-Length` of response, -1 is returned when
+// response has no such header or header value is invalid.
+func GetContentLength(resp *colly.Response) int64 {
+	if resp.Headers == nil {
+		return -1
+	}
+
+	value := resp.Headers.Get("Content-Length")
+	if value == "" {
+		return -1
+	}
+
+	length, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || length < 0 {
+		return -1
+	}
+
+	return length
+}
+
+// SaveImageResponse verifies response body against its `Content-Length`
+// and decodes it as image, then saves image to given path in given format.
+// If response body is not a complete image, request is retried according to
+// retry count in request context, see RetryRequest. `ErrRetrying` is returned
+// in that case, and response of retried request will go through the same
+// response callback again.
+func SaveImageResponse(ctx context.Context, resp *colly.Response, outputName string, outputFormat string) error {
+	img, err := common.DecodeImageData(resp.Body, GetContentLength(resp))
+	if err != nil {
+		retryCnt, retryErr := RetryRequest(ctx, resp.Request)
+		if retryErr == nil {
+			log.Warnf("retry(%d) %s: %s", retryCnt, resp.Request.URL, err)
+			return ErrRetrying
+		} else if errors.Is(retryErr, ErrMaxRetry) {
+			return fmt.Errorf("invalid image after %d time(s) of retry %s: %s", retryCnt, resp.Request.URL, err)
+		}
+
+		return fmt.Errorf("invalid image %s: %s, failed to retry: %s", resp.Request.URL, err, retryErr)
+	}
+
+	return common.SaveDecodedImage(img, resp.Body, outputName, outputFormat)
+}
+
 // RetryRequest reads `retryCnt` and `maxRetryCnt` from request context. If
 // current retry count is less than max retry count, this function retries given
-// request, else a `ErrMaxRetry` will be retruned.
+// request after backoff waiting, else a `ErrMaxRetry` will be retruned.
+// Request is not retried if `ctx` gets canceled during waiting.
 // This function returns retry count after operation, and error happenes during
 // operation.
-func RetryRequest(req *colly.Request) (int, error) {
-	ctx := req.Ctx
+func RetryRequest(ctx context.Context, req *colly.Request) (int, error) {
+	reqCtx := req.Ctx
 
-	maxRetryCnt, _ := ctx.GetAny("maxRetryCnt").(int)
+	maxRetryCnt, _ := reqCtx.GetAny("maxRetryCnt").(int)
 
-	retryCnt, _ := ctx.GetAny("retryCnt").(int)
+	retryCnt, _ := reqCtx.GetAny("retryCnt").(int)
 	if retryCnt >= maxRetryCnt {
 		return retryCnt, ErrMaxRetry
 	}
 
 	retryCnt++
-	ctx.Put("retryCnt", retryCnt)
+	reqCtx.Put("retryCnt", retryCnt)
+
+	if err := Backoff(ctx, retryCnt, DefaultRetryDelay); err != nil {
+		return retryCnt, err
+	}
 
 	return retryCnt, req.Retry()
 }
@@ -1,22 +1,40 @@
 package page_collect
 
 import (
+	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/SirZenith/delite/database"
+	"github.com/SirZenith/delite/database/data_model"
 	"github.com/gocolly/colly/v2"
 	"gorm.io/gorm"
 )
 
 type CtxGlobal struct {
+	Ctx       context.Context // cancellation signal for the whole download job
 	Target    *DlTarget
 	Collector *colly.Collector
 	Db        *gorm.DB
 	Link      *ChapterLink
+
+	BookRecord bool // when true, downloaded volumes and chapters are recorded in book tables
+
+	History *database.HistoryRun // statistics of this download run, may be nil
+
+	statusLock sync.Mutex
+	bookStatus int // series status found during downloading, 0 for unknown
+
+	validatorLock sync.Mutex
+	tocValidator  *data_model.HTTPValidator // validators of TOC page received in this run
+
+	hasFailure atomic.Bool // set when any chapter failed to download
 }
 
-func NewCtxGlobal() *CtxGlobal {
+func NewCtxGlobal(ctx context.Context) *CtxGlobal {
 	return &CtxGlobal{
+		Ctx: ctx,
 		Link: &ChapterLink{
 			visited:    map[int64]struct{}{},
 			urlMap:     map[int64]string{},
@@ -25,17 +43,71 @@ func NewCtxGlobal() *CtxGlobal {
 	}
 }
 
+// SetBookStatus records series status of downloading book.
+func (g *CtxGlobal) SetBookStatus(status int) {
+	g.statusLock.Lock()
+	defer g.statusLock.Unlock()
+
+	g.bookStatus = status
+}
+
+// GetBookStatus returns series status recorded during downloading, 0 will be
+// returned if no status is found.
+func (g *CtxGlobal) GetBookStatus() int {
+	g.statusLock.Lock()
+	defer g.statusLock.Unlock()
+
+	return g.bookStatus
+}
+
+// SetTocValidator records cache validators of book's TOC page. They should only
+// be saved after the whole book is downloaded.
+func (g *CtxGlobal) SetTocValidator(validator *data_model.HTTPValidator) {
+	g.validatorLock.Lock()
+	defer g.validatorLock.Unlock()
+
+	g.tocValidator = validator
+}
+
+// GetTocValidator returns validators of TOC page received in this run, nil will
+// be returned if TOC page was not fetched.
+func (g *CtxGlobal) GetTocValidator() *data_model.HTTPValidator {
+	g.validatorLock.Lock()
+	defer g.validatorLock.Unlock()
+
+	return g.tocValidator
+}
+
+// MarkFailed records that part of book failed to download.
+func (g *CtxGlobal) MarkFailed() {
+	g.hasFailure.Store(true)
+	g.History.AddFailed()
+}
+
+// HasFailure reports if any part of book failed to download in this run.
+func (g *CtxGlobal) HasFailure() bool {
+	return g.hasFailure.Load()
+}
+
 type Options struct {
 	Timeout    time.Duration      // download timeout
 	RetryCnt   int64              // retry count for each page download request
+	Proxy      string             // proxy URL used by both HTTP and HTTPS request
 	LimitRules []*colly.LimitRule // a list of requeest limit rule.
 
 	IgnoreTakenDownFlag bool // also process books that has been taken down
+	NoConditional       bool // always parse TOC page, ignoring validators stored from last download
+
+	LibraryPath string // path to library info JSON, book meta found during downloading is written back to it
+
+	ImportDir string // when not empty, pages are read from HTML files saved under this directory
+	Offline   bool   // when importing, do not fetch pages missing from import directory over network
 }
 
 type DlTarget struct {
 	Options *Options
 
+	BookID string // stable ID of book in library info, empty if book has none
 	Title  string
 	Author string
 
@@ -46,6 +118,8 @@ type DlTarget struct {
 	HeaderFile string // header file path
 	DbPath     string // path to book database file
 
+	BookIndex int // index of this book in library book list
+
 	IsTakenDown bool
 	IsLocal     bool
 }
@@ -58,6 +132,8 @@ type ChapterLink struct {
 	volInfoMap map[int64]*VolumeInfo
 }
 
+// makeKey packs volume and chapter index into map key. Part index is not
+// needed here, since volume index keeps counting across parts.
 func (c *ChapterLink) makeKey(volIndex, chapIndex int) int64 {
 	return (int64(volIndex) << 32) + int64(chapIndex)
 }
@@ -0,0 +1,285 @@
+package page_collect
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/SirZenith/delite/common"
+)
+
+// chapterProgress is saved next to partial chapter file, it records infomation
+// needed for resuming download of that chapter.
+type chapterProgress struct {
+	URL         string `json:"url"`           // URL of the first page of chapter
+	Title       string `json:"title"`         // chapter title found during downloading
+	LastPage    int    `json:"last_page"`     // page number of the last page written to partial file
+	LastPageURL string `json:"last_page_url"` // URL of the last page written to partial file
+	Size        int64  `json:"size"`          // byte size of partial file after writing the last page
+}
+
+// chapterWriter writes chapter pages to a partial file in page order. Pages
+// arrived out of order are held in memory until all pages before them have
+// been written.
+// Writing progress is saved along with partial file, so download of a chapter
+// can be resumed from the last written page.
+type chapterWriter struct {
+	partialName  string
+	progressName string
+	pageURL      func(pageNumber int) string
+
+	file   *os.File
+	writer *bufio.Writer
+
+	progress chapterProgress
+	pending  map[int]PageContent
+}
+
+// newChapterWriter opens partial file for given chapter. If a valid progress
+// record of the same chapter is found, writer continues from where that
+// progress ends.
+func newChapterWriter(info *ChapterInfo, pageURL func(pageNumber int) string) (*chapterWriter, error) {
+	partialName := info.GetChapterPartialPath()
+
+	w := &chapterWriter{
+		partialName:  partialName,
+		progressName: partialName + ".json",
+		pageURL:      pageURL,
+		progress: chapterProgress{
+			URL: info.URL,
+		},
+		pending: map[int]PageContent{},
+	}
+
+	w.loadProgress(info.URL)
+
+	file, err := os.OpenFile(partialName, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open partial chapter file %s: %s", partialName, err)
+	}
+
+	if err = file.Truncate(w.progress.Size); err == nil {
+		_, err = file.Seek(w.progress.Size, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to restore partial chapter file %s: %s", partialName, err)
+	}
+
+	w.file = file
+	w.writer = bufio.NewWriter(file)
+
+	return w, nil
+}
+
+// loadProgress reads progress record of previous download. Progress record is
+// ignored if it belongs to another chapter, or partial file is not as long as
+// the record says.
+func (w *chapterWriter) loadProgress(url string) {
+	data, err := os.ReadFile(w.progressName)
+	if err != nil {
+		return
+	}
+
+	progress := chapterProgress{}
+	if err = json.Unmarshal(data, &progress); err != nil || progress.URL != url {
+		return
+	}
+
+	stat, err := os.Stat(w.partialName)
+	if err != nil || stat.Size() < progress.Size {
+		return
+	}
+
+	w.progress = progress
+}
+
+// saveProgress writes current progress to disk.
+func (w *chapterWriter) saveProgress() error {
+	data, err := json.Marshal(w.progress)
+	if err != nil {
+		return fmt.Errorf("failed to encode chapter progress: %s", err)
+	}
+
+	return common.WriteBytesAtomic(w.progressName, data, 0o644)
+}
+
+// ResumePage returns page number and URL that downloading should start from.
+// If no page has been written yet, page number 0 and empty URL will be returned.
+func (w *chapterWriter) ResumePage() (int, string) {
+	if w.progress.LastPage <= 0 || w.progress.LastPageURL == "" {
+		return 0, ""
+	}
+
+	return w.progress.LastPage, w.progress.LastPageURL
+}
+
+// Title returns chapter title recorded by writer.
+func (w *chapterWriter) Title() string {
+	return w.progress.Title
+}
+
+// SetTitle updates chapter title recorded by writer.
+func (w *chapterWriter) SetTitle(title string) {
+	w.progress.Title = title
+}
+
+// PageCnt returns number of pages received by writer, including pages that
+// are still waiting to be written.
+func (w *chapterWriter) PageCnt() int {
+	return w.progress.LastPage + len(w.pending)
+}
+
+// AddPage accepts a new page of chapter, and writes all pages that are ready
+// to be written. Pages that have already been written are ignored.
+func (w *chapterWriter) AddPage(page PageContent) error {
+	if page.PageNumber <= w.progress.LastPage {
+		return nil
+	}
+
+	w.pending[page.PageNumber] = page
+
+	return w.flushPending()
+}
+
+// flushPending writes pending pages right after the last written page to
+// partial file. Pages after a missing page are kept pending.
+func (w *chapterWriter) flushPending() error {
+	written := false
+
+	for {
+		pageNumber := w.progress.LastPage + 1
+		page, ok := w.pending[pageNumber]
+		if !ok {
+			break
+		}
+
+		if err := w.writePage(page); err != nil {
+			return err
+		}
+
+		written = true
+	}
+
+	if !written {
+		return nil
+	}
+
+	if err := w.writer.Flush(); err != nil {
+		return fmt.Errorf("failed to flush partial chapter file %s: %s", w.partialName, err)
+	}
+
+	return w.saveProgress()
+}
+
+// missingPages returns page numbers absent between the last written page and
+// the last pending page.
+func (w *chapterWriter) missingPages() []int {
+	if len(w.pending) == 0 {
+		return nil
+	}
+
+	maxPage := 0
+	for pageNumber := range w.pending {
+		maxPage = max(maxPage, pageNumber)
+	}
+
+	missing := []int{}
+	for pageNumber := w.progress.LastPage + 1; pageNumber < maxPage; pageNumber++ {
+		if _, ok := w.pending[pageNumber]; !ok {
+			missing = append(missing, pageNumber)
+		}
+	}
+
+	return missing
+}
+
+// writePage writes content of a single page to partial file, and updates
+// progress record.
+func (w *chapterWriter) writePage(page PageContent) error {
+	n, err := w.writer.WriteString(page.Content)
+	if err != nil {
+		return fmt.Errorf("failed to write page %d to %s: %s", page.PageNumber, w.partialName, err)
+	}
+
+	delete(w.pending, page.PageNumber)
+
+	w.progress.LastPage = page.PageNumber
+	w.progress.Size += int64(n)
+	if w.pageURL != nil {
+		w.progress.LastPageURL = w.pageURL(page.PageNumber)
+	}
+
+	return nil
+}
+
+// Close stops writing and keeps partial file for later resuming. If no page
+// has been written, partial file gets removed.
+func (w *chapterWriter) Close() error {
+	flushErr := w.writer.Flush()
+	closeErr := w.file.Close()
+
+	if w.progress.LastPage <= 0 {
+		w.removePartial()
+		return nil
+	}
+
+	return errors.Join(flushErr, closeErr)
+}
+
+// Finish writes all pending pages, then writes complete chapter file to
+// `outputName`, with chapter title header prepended to page content.
+// Partial file and its progress record are removed afterward.
+// If some pages are missing, chapter is not completed, pages before the first
+// missing one are kept in partial file for resuming, and error is returned.
+func (w *chapterWriter) Finish(outputName string) error {
+	if err := w.flushPending(); err != nil {
+		w.file.Close()
+		return err
+	}
+
+	if missing := w.missingPages(); len(missing) > 0 {
+		w.Close()
+		return fmt.Errorf("chapter is incomplete, missing page(s): %v", missing)
+	}
+
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close partial chapter file %s: %s", w.partialName, err)
+	}
+
+	partial, err := os.Open(w.partialName)
+	if err != nil {
+		return fmt.Errorf("failed to read partial chapter file %s: %s", w.partialName, err)
+	}
+	defer partial.Close()
+
+	err = common.WriteFileAtomic(outputName, 0o644, func(out io.Writer) error {
+		_, err := io.WriteString(out, "<h1 class=\"chapter-title\">"+w.progress.Title+"</h1>\n")
+		if err == nil {
+			_, err = io.Copy(out, partial)
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to write chapter file %s: %s", outputName, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	partial.Close()
+	w.removePartial()
+
+	return nil
+}
+
+// removePartial deletes partial file and its progress record.
+func (w *chapterWriter) removePartial() {
+	os.Remove(w.partialName)
+	os.Remove(w.progressName)
+}
@@ -0,0 +1,199 @@
+package page_collect
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func newTestChapterInfo(dir string) *ChapterInfo {
+	return &ChapterInfo{
+		VolumeInfo: VolumeInfo{
+			VolIndex:  1,
+			OutputDir: dir,
+		},
+		ChapIndex: 1,
+		URL:       "https://example.com/chapter/1.html",
+	}
+}
+
+func testPageURL(pageNumber int) string {
+	return fmt.Sprintf("https://example.com/chapter/1_%d.html", pageNumber)
+}
+
+func makeTestPage(pageNumber int) PageContent {
+	return PageContent{
+		PageNumber: pageNumber,
+		Content:    fmt.Sprintf("<p>page %d</p>", pageNumber),
+	}
+}
+
+func TestChapterWriterFinish(t *testing.T) {
+	cases := []struct {
+		name        string
+		pages       []int
+		want        string
+		wantErr     bool
+		wantPartial bool // partial file is kept for resuming
+	}{
+		{
+			name:  "in order",
+			pages: []int{1, 2, 3},
+			want:  "<h1 class=\"chapter-title\">Title</h1>\n<p>page 1</p><p>page 2</p><p>page 3</p>",
+		},
+		{
+			name:  "out of order",
+			pages: []int{3, 1, 2},
+			want:  "<h1 class=\"chapter-title\">Title</h1>\n<p>page 1</p><p>page 2</p><p>page 3</p>",
+		},
+		{
+			name:  "duplicated page",
+			pages: []int{1, 2, 1, 3},
+			want:  "<h1 class=\"chapter-title\">Title</h1>\n<p>page 1</p><p>page 2</p><p>page 3</p>",
+		},
+		{
+			name:        "missing middle page",
+			pages:       []int{1, 3, 4},
+			wantErr:     true,
+			wantPartial: true,
+		},
+		{
+			name:    "missing first page",
+			pages:   []int{2},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			info := newTestChapterInfo(t.TempDir())
+
+			w, err := newChapterWriter(info, testPageURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.SetTitle("Title")
+
+			for _, pageNumber := range c.pages {
+				if err := w.AddPage(makeTestPage(pageNumber)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			outputName := info.GetChapterOutputPath("Title")
+			err = w.Finish(outputName)
+
+			if c.wantErr {
+				if err == nil {
+					t.Fatal("expecting error for incomplete chapter")
+				}
+				if _, err := os.Stat(outputName); err == nil {
+					t.Errorf("incomplete chapter should not be written to %s", outputName)
+				}
+				if _, err := os.Stat(info.GetChapterPartialPath()); (err == nil) != c.wantPartial {
+					t.Errorf("partial file existence: got %v, want %v", err == nil, c.wantPartial)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(outputName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != c.want {
+				t.Errorf("got %q, want %q", data, c.want)
+			}
+
+			if _, err := os.Stat(info.GetChapterPartialPath()); err == nil {
+				t.Errorf("partial file should be removed after finishing")
+			}
+		})
+	}
+}
+
+func TestChapterWriterResume(t *testing.T) {
+	info := newTestChapterInfo(t.TempDir())
+
+	w, err := newChapterWriter(info, testPageURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetTitle("Title")
+
+	for _, pageNumber := range []int{1, 2, 4} {
+		if err := w.AddPage(makeTestPage(pageNumber)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// page 3 missing, chapter is kept partial
+	if err := w.Finish(info.GetChapterOutputPath("Title")); err == nil {
+		t.Fatal("expecting error for incomplete chapter")
+	}
+
+	w, err = newChapterWriter(info, testPageURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Title() != "Title" {
+		t.Errorf("title not restored, got %q", w.Title())
+	}
+
+	pageNumber, url := w.ResumePage()
+	if pageNumber != 2 || url != testPageURL(2) {
+		t.Fatalf("got resume page %d %q, want 2 %q", pageNumber, url, testPageURL(2))
+	}
+
+	// last written page is fetched again when resuming, it should be ignored
+	for _, pageNumber := range []int{2, 3, 4} {
+		if err := w.AddPage(makeTestPage(pageNumber)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	outputName := info.GetChapterOutputPath("Title")
+	if err := w.Finish(outputName); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(outputName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "<h1 class=\"chapter-title\">Title</h1>\n<p>page 1</p><p>page 2</p><p>page 3</p><p>page 4</p>"
+	if string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+}
+
+func TestChapterWriterIgnoresProgressOfOtherChapter(t *testing.T) {
+	info := newTestChapterInfo(t.TempDir())
+
+	w, err := newChapterWriter(info, testPageURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddPage(makeTestPage(1)); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	other := *info
+	other.URL = "https://example.com/chapter/2.html"
+
+	w, err = newChapterWriter(&other, testPageURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if pageNumber, url := w.ResumePage(); pageNumber != 0 || url != "" {
+		t.Errorf("progress of another chapter should be ignored, got %d %q", pageNumber, url)
+	}
+}
@@ -1,15 +1,28 @@
 package page_collect
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
 
 	"github.com/SirZenith/delite/common"
 )
 
+// patternPartDirName matches directory name generated by `GetPartDirName`.
+var patternPartDirName = regexp.MustCompile(`^Part\.(\d+)(?: - (.+))?$`)
+
 type VolumeInfo struct {
-	Book            string
-	VolIndex        int
+	Book string
+
+	// Part is an optional grouping level above volume, such as arc of a series.
+	// PartIndex is 0 when book has no part level.
+	PartIndex int
+	PartTitle string
+
+	VolIndex        int // volume index counted across the whole book, not restarted in each part
 	Title           string
 	TotalChapterCnt int
 
@@ -17,6 +30,56 @@ type VolumeInfo struct {
 	ImgOutputDir string
 }
 
+// Returns directory name used by part this volume belongs to, empty string will
+// be returned if volume belongs to no part.
+// Part directory name always starts with `Part.NNN`, so that it can be told
+// apart from volume directory by name.
+func (v *VolumeInfo) GetPartDirName() string {
+	if v.PartIndex <= 0 {
+		return ""
+	}
+
+	outputTitle := common.InvalidPathCharReplace(v.PartTitle)
+	if outputTitle == "" {
+		return fmt.Sprintf("Part.%03d", v.PartIndex)
+	}
+
+	return fmt.Sprintf("Part.%03d - %s", v.PartIndex, outputTitle)
+}
+
+// ParsePartDirName parses directory name generated by `GetPartDirName`, returns
+// part index and part title in it. `ok` is false if name is not a part directory
+// name.
+func ParsePartDirName(name string) (index int, title string, ok bool) {
+	match := patternPartDirName.FindStringSubmatch(name)
+	if match == nil {
+		return 0, "", false
+	}
+
+	index, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0, "", false
+	}
+
+	return index, match[2], true
+}
+
+// IsPartDirName checks if given name is a directory name generated by
+// `GetPartDirName`.
+func IsPartDirName(name string) bool {
+	_, _, ok := ParsePartDirName(name)
+	return ok
+}
+
+// Sets text and image output directory of volume with given directory name.
+// If volume belongs to a part, its directory is placed under part directory.
+func (v *VolumeInfo) SetOutputDir(target *DlTarget, volumeDirName string) {
+	subDir := filepath.Join(v.GetPartDirName(), volumeDirName)
+
+	v.OutputDir = filepath.Join(target.OutputDir, subDir)
+	v.ImgOutputDir = filepath.Join(target.ImgOutputDir, subDir)
+}
+
 type ChapterInfo struct {
 	VolumeInfo
 	ChapIndex int    // chapter index of this chapter
@@ -41,6 +104,64 @@ type ChapterDownloadState struct {
 	RootNameStem  string
 	ResultChan    chan PageContent
 	CurPageNumber int
+
+	pageURLLock sync.Mutex
+	pageURLMap  map[int]string // page number to URL of each page fetched
+
+	resultLock   sync.Mutex
+	resultClosed bool
+}
+
+// SendPage passes page content to chapter waiter through result channel.
+// Sending is given up when `ctx` gets canceled or result channel has already
+// been closed, in which case false is returned.
+func (s *ChapterDownloadState) SendPage(ctx context.Context, page PageContent) bool {
+	s.resultLock.Lock()
+	defer s.resultLock.Unlock()
+
+	if s.resultClosed {
+		return false
+	}
+
+	select {
+	case s.ResultChan <- page:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// CloseResult closes result channel, signaling chapter waiter that no more page
+// is coming. Only the first call takes effect, so it's safe to be called by
+// every sender.
+func (s *ChapterDownloadState) CloseResult() {
+	s.resultLock.Lock()
+	defer s.resultLock.Unlock()
+
+	if !s.resultClosed {
+		s.resultClosed = true
+		close(s.ResultChan)
+	}
+}
+
+// setPageURL records URL of page with given page number.
+func (s *ChapterDownloadState) setPageURL(pageNumber int, url string) {
+	s.pageURLLock.Lock()
+	defer s.pageURLLock.Unlock()
+
+	if s.pageURLMap == nil {
+		s.pageURLMap = map[int]string{}
+	}
+	s.pageURLMap[pageNumber] = url
+}
+
+// GetPageURL returns URL of page with given page number, empty string will be
+// returned if that page has not been fetched yet.
+func (s *ChapterDownloadState) GetPageURL(pageNumber int) string {
+	s.pageURLLock.Lock()
+	defer s.pageURLLock.Unlock()
+
+	return s.pageURLMap[pageNumber]
 }
 
 // Composes outputpath of chapter content with chapter info.
@@ -55,6 +176,12 @@ func (c *ChapterInfo) GetChapterOutputPath(title string) string {
 	return filepath.Join(c.OutputDir, outputTitle)
 }
 
+// Composes path of partial chapter file, which holds pages written so far
+// while chapter is still being downloaded.
+func (c *ChapterInfo) GetChapterPartialPath() string {
+	return filepath.Join(c.OutputDir, fmt.Sprintf(".Chap.%04d.html.partial", c.ChapIndex))
+}
+
 // Composes chapter key used by name map look up.
 func (c *ChapterInfo) GetNameMapKey(title string) string {
 	return fmt.Sprintf("%03d-%04d-%s", c.VolIndex, c.ChapIndex, title)
@@ -0,0 +1,55 @@
+package page_collect
+
+import "testing"
+
+func TestPartDirName(t *testing.T) {
+	cases := []struct {
+		name      string
+		partIndex int
+		partTitle string
+		want      string
+	}{
+		{name: "no part", want: ""},
+		{name: "untitled", partIndex: 1, want: "Part.001"},
+		{name: "titled", partIndex: 2, partTitle: "第二部", want: "Part.002 - 第二部"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			info := VolumeInfo{PartIndex: c.partIndex, PartTitle: c.partTitle}
+
+			got := info.GetPartDirName()
+			if got != c.want {
+				t.Fatalf("got %q, want %q", got, c.want)
+			}
+			if got == "" {
+				return
+			}
+
+			index, title, ok := ParsePartDirName(got)
+			if !ok || index != c.partIndex || title != c.partTitle {
+				t.Errorf("parsed %q as %d %q %v", got, index, title, ok)
+			}
+		})
+	}
+}
+
+func TestIsPartDirName(t *testing.T) {
+	cases := []struct {
+		name string
+		want bool
+	}{
+		{"Part.001", true},
+		{"Part.012 - Title", true},
+		{"001 - Title", false},
+		{"Vol.001", false},
+		{"Part.001.html", false},
+		{"images", false},
+	}
+
+	for _, c := range cases {
+		if got := IsPartDirName(c.name); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
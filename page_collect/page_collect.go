@@ -1,9 +1,8 @@
 package page_collect
 
 import (
-	"bufio"
-	"container/list"
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path"
@@ -12,7 +11,9 @@ import (
 	"sync"
 	"time"
 
+	"github.com/SirZenith/delite/database"
 	"github.com/SirZenith/delite/database/data_model"
+	"github.com/SirZenith/delite/network"
 	"github.com/charmbracelet/log"
 	"github.com/gocolly/colly/v2"
 	"gorm.io/gorm"
@@ -26,6 +27,10 @@ func CollectChapterPages(r *colly.Request, timeout time.Duration, info ChapterIn
 	collector := global.Collector
 	db := global.Db
 
+	if global.Ctx.Err() != nil {
+		return
+	}
+
 	if global.Link.CheckVisited(info.VolIndex, info.ChapIndex) {
 		return
 	}
@@ -40,7 +45,7 @@ func CollectChapterPages(r *colly.Request, timeout time.Duration, info ChapterIn
 	}
 
 	// check skip
-	existingTitle := checkShouldSkipChapter(db, &info)
+	existingTitle := checkShouldSkipChapter(global, &info)
 	if existingTitle != "" {
 		log.Debugf("skip chapter: %s", info.GetLogName(existingTitle))
 		return
@@ -48,29 +53,59 @@ func CollectChapterPages(r *colly.Request, timeout time.Duration, info ChapterIn
 
 	// downloading
 	resultChan := make(chan PageContent, 5)
-	dlCtx := makePageCollectContext(info, resultChan, global.Target.Options.RetryCnt)
+	dlCtx, state := makePageCollectContext(info, resultChan, global.Target.Options.RetryCnt)
+
+	writer, err := newChapterWriter(&info, state.GetPageURL)
+	if err != nil {
+		log.Warnf("failed to prepare chapter file for %s: %s", info.GetLogName(info.Title), err)
+		return
+	}
+
+	title := info.Title
+	if writer.Title() != "" {
+		title = writer.Title()
+	}
 
-	collector.Request("GET", info.URL, nil, dlCtx, r.Headers.Clone())
+	pageURL := info.URL
+	if pageNumber, url := writer.ResumePage(); url != "" {
+		// re-fetching last written page gives parser the infomation needed for
+		// reaching the next page, its content is ignored by writer.
+		log.Infof("resume chapter from page %d: %s", pageNumber, info.GetLogName(title))
+		state.CurPageNumber = pageNumber
+		pageURL = url
+	}
 
-	waitResult := waitPages(info.Title, timeout, resultChan)
-	if waitResult.Err != nil {
-		onWaitPagesError(&info, waitResult.Err)
+	if err := collector.Request("GET", pageURL, nil, dlCtx, r.Headers.Clone()); err != nil {
+		writer.Close()
+		onWaitPagesError(global, &info, err)
 		return
 	}
 
-	pageCnt := waitResult.PageList.Len()
-	if pageCnt > 0 {
-		waitResult.PageList.PushFront(PageContent{
-			Content: "<h1 class=\"chapter-title\">" + waitResult.Title + "</h1>\n",
-		})
+	waitResult := waitPages(global.Ctx, writer, title, timeout, resultChan)
+	if errors.Is(waitResult.Err, context.Canceled) {
+		// pages written so far are kept in partial file, next run can resume
+		// from there.
+		writer.Close()
+		log.Warnf("chapter download canceled: %s", info.GetLogName(waitResult.Title))
+		return
+	} else if waitResult.Err != nil {
+		writer.Close()
+		onWaitPagesError(global, &info, waitResult.Err)
+		return
+	}
 
+	if waitResult.PageCnt <= 0 {
+		writer.Close()
+	} else {
 		// save content to file
 		outputName := info.GetChapterOutputPath(waitResult.Title)
-		if err := saveChapterContent(waitResult.PageList, outputName); err == nil {
+		if err := writer.Finish(outputName); err == nil {
 			saveChapterFileEntry(db, &info, waitResult.Title)
-			log.Infof("save chapter (%dp): %s", pageCnt, info.GetLogName(waitResult.Title))
+			saveChapterRecord(global, &info, waitResult.Title, outputName)
+			addHistoryChapter(global, outputName)
+			log.Infof("save chapter (%dp): %s", waitResult.PageCnt, info.GetLogName(waitResult.Title))
 		} else {
-			log.Warnf("error occured during saving %s: %s", outputName, err)
+			onWaitPagesError(global, &info, fmt.Errorf("error occured during saving %s: %s", outputName, err))
 			return
 		}
 	}
@@ -80,11 +115,21 @@ func CollectChapterPages(r *colly.Request, timeout time.Duration, info ChapterIn
 
 // Checks if downloading of a chapter can be skipped. If yes, then title name
 // used by downloaded file will be return, else empty string will be returned.
-func checkShouldSkipChapter(db *gorm.DB, info *ChapterInfo) string {
+func checkShouldSkipChapter(global *CtxGlobal, info *ChapterInfo) string {
+	db := global.Db
 	if db == nil {
 		return ""
 	}
 
+	if global.BookRecord {
+		chapter := database.FindChapterByURL(db, global.Target.BookID, info.URL)
+		if chapter != nil && chapter.FilePath != "" {
+			if _, err := os.Stat(filepath.Join(global.Target.OutputDir, chapter.FilePath)); err == nil {
+				return chapter.Title
+			}
+		}
+	}
+
 	entry := data_model.FileEntry{}
 	db.Limit(1).Find(&entry, "url = ?", info.URL)
 	if entry.FileName == "" {
@@ -101,11 +146,12 @@ func checkShouldSkipChapter(db *gorm.DB, info *ChapterInfo) string {
 }
 
 // Makes a context variable with necessary download state infomation in it.
-func makePageCollectContext(info ChapterInfo, resultChan chan PageContent, retryCnt int64) *colly.Context {
+// Download state stored in context is also returned.
+func makePageCollectContext(info ChapterInfo, resultChan chan PageContent, retryCnt int64) (*colly.Context, *ChapterDownloadState) {
 	rootBaseName := path.Base(info.URL)
 	rootExt := path.Ext(rootBaseName)
 	rootBaseStem := rootBaseName[:len(rootBaseName)-len(rootExt)]
-	state := ChapterDownloadState{
+	state := &ChapterDownloadState{
 		Info:          info,
 		RootNameExt:   rootExt,
 		RootNameStem:  rootBaseStem,
@@ -114,60 +160,74 @@ func makePageCollectContext(info ChapterInfo, resultChan chan PageContent, retry
 	}
 
 	ctx := colly.NewContext()
-	ctx.Put("downloadState", &state)
+	ctx.Put("downloadState", state)
 	ctx.Put("leftRetryCnt", retryCnt)
-	ctx.Put("resultChan", resultChan)
 	ctx.Put("onResponse", colly.ResponseCallback(onPageCollectResponse))
 	ctx.Put("onError", colly.ErrorCallback(onPageCollectError))
 
-	return ctx
+	return ctx, state
 }
 
 func onPageCollectResponse(resp *colly.Response) {
 	global := resp.Ctx.GetAny("global").(*CtxGlobal)
 	respState := resp.Ctx.GetAny("downloadState").(*ChapterDownloadState)
 	global.Link.MarkVisited(respState.Info.VolIndex, respState.Info.ChapIndex)
+	respState.setPageURL(respState.CurPageNumber, resp.Request.URL.String())
 }
 
 func onPageCollectError(resp *colly.Response, err error) {
-	resultChan := resp.Ctx.GetAny("resultChan").(chan PageContent)
+	global := resp.Ctx.GetAny("global").(*CtxGlobal)
+	state := resp.Ctx.GetAny("downloadState").(*ChapterDownloadState)
+
+	// sends error to waiter, and ends chapter downloading
+	fail := func(err error) {
+		state.SendPage(global.Ctx, PageContent{Err: err})
+		state.CloseResult()
+	}
+
+	if global.Ctx.Err() != nil {
+		fail(global.Ctx.Err())
+		return
+	}
 
 	leftRetryCnt := resp.Ctx.GetAny("leftRetryCnt").(int64)
 	if leftRetryCnt <= 0 {
-		resultChan <- PageContent{
-			Err: fmt.Errorf("failed after all retry: %s", err),
-		}
-		close(resultChan)
+		fail(fmt.Errorf("failed after all retry: %s", err))
 		return
 	}
 
 	resp.Ctx.Put("leftRetryCnt", leftRetryCnt-1)
+
+	attempt := int(global.Target.Options.RetryCnt - leftRetryCnt + 1)
+	if err = network.Backoff(global.Ctx, attempt, network.DefaultRetryDelay); err != nil {
+		fail(err)
+		return
+	}
+
 	if err = resp.Request.Retry(); err != nil {
-		resultChan <- PageContent{
-			Err: fmt.Errorf("unable to retry request: %s", err),
-		}
-		close(resultChan)
+		fail(fmt.Errorf("unable to retry request: %s", err))
+		return
 	}
 
 	// signaling continuation
-	resultChan <- PageContent{}
+	state.SendPage(global.Ctx, PageContent{})
 }
 
 type WaitPagesResult struct {
-	PageList       *list.List
+	PageCnt        int // number of pages written to chapter file
 	Title          string
 	NextChapterURL string // An absolute URL to the first page of next chapter
 	Err            error
 }
 
-// Collects all pages sent from colly jobs with timeout.
-func waitPages(title string, timeout time.Duration, resultChan chan PageContent) WaitPagesResult {
-	pageList := list.New()
-
+// Collects all pages sent from colly jobs with timeout, pages are passed to
+// `writer` as they arrive. Waiting stops early with `ctx.Err()` as error when
+// given context gets canceled.
+func waitPages(ctx context.Context, writer *chapterWriter, title string, timeout time.Duration, resultChan chan PageContent) WaitPagesResult {
 	waitResult := WaitPagesResult{
-		PageList: pageList,
-		Title:    title,
+		Title: title,
 	}
+	writer.SetTitle(title)
 
 loop:
 	for {
@@ -182,12 +242,15 @@ loop:
 				continue
 			}
 
-			if data.Content != "" {
-				insertChapterPage(pageList, data)
-			}
-
 			if data.Title != "" {
 				waitResult.Title = data.Title
+				writer.SetTitle(data.Title)
+			}
+
+			if data.Content != "" && waitResult.Err == nil {
+				if err := writer.AddPage(data); err != nil {
+					waitResult.Err = err
+				}
 			}
 
 			if data.NextChapterURL != "" {
@@ -195,21 +258,51 @@ loop:
 			}
 		case <-time.After(timeout):
 			waitResult.Err = fmt.Errorf("download timeout after %s", timeout.String())
+			drainPages(resultChan)
+			break loop
+		case <-ctx.Done():
+			waitResult.Err = ctx.Err()
+			drainPages(resultChan)
 			break loop
 		}
 	}
 
+	waitResult.PageCnt = writer.PageCnt()
+
 	return waitResult
 }
 
+// drainPages keeps reading result channel in background until it gets closed,
+// so that colly callbacks still sending pages to it won't block.
+func drainPages(resultChan chan PageContent) {
+	go func() {
+		for range resultChan {
+		}
+	}()
+}
+
+// Name prefix and extension of marker file written for chapter that fails to
+// download, see onWaitPagesError.
+const (
+	FailedMarkPrefix = "failed - "
+	FailedMarkExt    = ".mark"
+)
+
+// IsFailedMarkName checks if given file name is name of a failure marker file.
+func IsFailedMarkName(name string) bool {
+	return strings.HasPrefix(name, FailedMarkPrefix) && strings.HasSuffix(name, FailedMarkExt)
+}
+
 // Handling error happended during download chapter pages, write a marker file
 // as a record of error.
-func onWaitPagesError(info *ChapterInfo, err error) {
+func onWaitPagesError(global *CtxGlobal, info *ChapterInfo, err error) {
+	global.MarkFailed()
+
 	outputName := info.GetChapterOutputPath(info.Title)
 	outputDir := filepath.Dir(outputName)
 	outputBase := filepath.Base(outputName)
 
-	failedName := filepath.Join(outputDir, "failed - "+outputBase+".mark")
+	failedName := filepath.Join(outputDir, FailedMarkPrefix+outputBase+FailedMarkExt)
 
 	failedContent := info.URL + "\n" + err.Error()
 
@@ -218,50 +311,84 @@ func onWaitPagesError(info *ChapterInfo, err error) {
 	log.Warnf("failed to download %s: %s", info.GetLogName(info.Title), err)
 }
 
-// Inserts newly fetched page content into page list according its page number.
-func insertChapterPage(list *list.List, data PageContent) {
-	target := list.Front()
-	for target != nil && target.Value.(PageContent).PageNumber <= data.PageNumber {
-		target = target.Next()
+// Saves name map to file.
+func saveChapterFileEntry(db *gorm.DB, info *ChapterInfo, fileTitle string) {
+	if db != nil {
+		entry := data_model.FileEntry{
+			URL:      info.URL,
+			Book:     info.Book,
+			Part:     info.PartTitle,
+			Volume:   info.VolumeInfo.Title,
+			FileName: fileTitle,
+		}
+		db.Save(&entry)
 	}
+}
 
-	if target == nil {
-		list.PushBack(data)
-	} else {
-		list.InsertBefore(data, target)
+// addHistoryChapter counts saved chapter in history of download run.
+func addHistoryChapter(global *CtxGlobal, outputName string) {
+	if global.History == nil {
+		return
 	}
-}
 
-// Writes content of chapter pages to file.
-func saveChapterContent(list *list.List, outputName string) error {
-	file, err := os.Create(outputName)
+	relPath, err := filepath.Rel(global.Target.OutputDir, outputName)
 	if err != nil {
-		return fmt.Errorf("failed to open output file %s: %s", outputName, err)
+		relPath = outputName
 	}
-	defer file.Close()
 
-	writer := bufio.NewWriter(file)
-	for element := list.Front(); element != nil; element = element.Next() {
-		writer.WriteString(element.Value.(PageContent).Content)
+	global.History.AddProcessed(filepath.ToSlash(relPath))
+}
+
+// saveChapterRecord records volume and chapter of downloaded chapter file in
+// book tables.
+func saveChapterRecord(global *CtxGlobal, info *ChapterInfo, fileTitle string, outputName string) {
+	if global.Db == nil || !global.BookRecord {
+		return
 	}
 
-	if err = writer.Flush(); err != nil {
-		return fmt.Errorf("failed to flush chapter file %s: %s", outputName, err)
+	target := global.Target
+	dirPath, err := filepath.Rel(target.OutputDir, info.OutputDir)
+	if err != nil {
+		log.Warnf("failed to record chapter %s: %s", info.GetLogName(fileTitle), err)
+		return
 	}
 
-	return nil
-}
+	filePath, err := filepath.Rel(target.OutputDir, outputName)
+	if err != nil {
+		log.Warnf("failed to record chapter %s: %s", info.GetLogName(fileTitle), err)
+		return
+	}
 
-// Saves name map to file.
-func saveChapterFileEntry(db *gorm.DB, info *ChapterInfo, fileTitle string) {
-	if db != nil {
-		entry := data_model.FileEntry{
-			URL:      info.URL,
-			Book:     info.Book,
-			Volume:   info.VolumeInfo.Title,
-			FileName: fileTitle,
+	hash, err := database.HashFile(outputName)
+	if err != nil {
+		log.Warnf("failed to hash chapter file %s: %s", outputName, err)
+	}
+
+	err = global.Db.Transaction(func(tx *gorm.DB) error {
+		volume := data_model.Volume{
+			BookID:    target.BookID,
+			PartIndex: info.PartIndex,
+			PartTitle: info.PartTitle,
+			VolIndex:  info.VolIndex,
+			Title:     info.VolumeInfo.Title,
+			DirPath:   filepath.ToSlash(dirPath),
 		}
-		db.Save(&entry)
+		if err := database.SaveVolume(tx, &volume); err != nil {
+			return err
+		}
+
+		return database.SaveChapter(tx, &data_model.Chapter{
+			BookID:      target.BookID,
+			VolumeID:    volume.ID,
+			ChapIndex:   info.ChapIndex,
+			Title:       fileTitle,
+			URL:         info.URL,
+			FilePath:    filepath.ToSlash(filePath),
+			ContentHash: hash,
+		})
+	})
+	if err != nil {
+		log.Warnf("failed to record chapter %s: %s", info.GetLogName(fileTitle), err)
 	}
 }
 
@@ -314,8 +441,11 @@ type ImgDlWorkerFunc = func(collator *colly.Collector, task ImageTask, resultCha
 // StartImageDlWorker starts a new goroutine waiting for in coming download tasks.
 // And returns a channel for submitting new image task. When all tasks has been
 // submitted, task channel should be closed.
-// After all task are handled, background goroutine will close chapter result channel,
-func StartImageDlWorker(collector *colly.Collector, chapterLogName string, pageResultChan chan PageContent, dlFunc ImgDlWorkerFunc) chan ImageTask {
+// After all task are handled, background goroutine will close result channel of
+// chapter download state.
+// Once `ctx` is canceled, remaining tasks are marked as failed without being
+// downloaded.
+func StartImageDlWorker(ctx context.Context, collector *colly.Collector, state *ChapterDownloadState, dlFunc ImgDlWorkerFunc) chan ImageTask {
 	taskChan := make(chan ImageTask, 5)
 	dlResultChan := make(chan bool, 5)
 
@@ -329,6 +459,11 @@ func StartImageDlWorker(collector *colly.Collector, chapterLogName string, pageR
 			taskCnt++
 			lock.Unlock()
 
+			if ctx.Err() != nil {
+				dlResultChan <- false
+				continue
+			}
+
 			dlFunc(collector, task, dlResultChan)
 		}
 
@@ -346,7 +481,7 @@ func StartImageDlWorker(collector *colly.Collector, chapterLogName string, pageR
 		for dlOk := range dlResultChan {
 			allOk = allOk && dlOk
 			finishedCnt++
-			pageResultChan <- PageContent{}
+			state.SendPage(ctx, PageContent{})
 
 			lock.Lock()
 			isEnded := taskClosed && finishedCnt >= taskCnt
@@ -358,15 +493,17 @@ func StartImageDlWorker(collector *colly.Collector, chapterLogName string, pageR
 		}
 
 		var finalErr error
-		if !allOk {
-			finalErr = fmt.Errorf("failed to complete %s", chapterLogName)
+		if err := ctx.Err(); err != nil {
+			finalErr = err
+		} else if !allOk {
+			finalErr = fmt.Errorf("failed to complete %s", state.Info.GetLogName(state.Info.Title))
 		}
 
-		pageResultChan <- PageContent{
+		state.SendPage(ctx, PageContent{
 			Err: finalErr,
-		}
+		})
 
-		close(pageResultChan)
+		state.CloseResult()
 	}()
 
 	return taskChan
@@ -382,8 +519,7 @@ func GetImageDlWorkerChanFromCtx(ctx *colly.Context, key string, dlFunc ImgDlWor
 
 	dlChan, getOk := ctx.GetAny(key).(chan ImageTask)
 	if !getOk {
-		logName := state.Info.GetLogName(state.Info.Title)
-		dlChan = StartImageDlWorker(global.Collector, logName, state.ResultChan, dlFunc)
+		dlChan = StartImageDlWorker(global.Ctx, global.Collector, state, dlFunc)
 		ctx.Put(key, dlChan)
 	}
 